@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"codex-runner/internal/codexremote/client"
+)
+
+// execAnnotate runs `exec annotate`: it renames an exec, changes its labels or
+// adds a note, and prints the exec's meta.
+func execAnnotate(args []string) {
+	fs := flag.NewFlagSet("exec annotate", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	execID := fs.String("id", "", "exec id")
+	name := fs.String("name", "", "new name of the exec (empty clears it)")
+	labels := labelFlag{}
+	fs.Var(&labels, "label", "set label KEY=VAL (repeatable)")
+	unlabel := multiFlag{}
+	fs.Var(&unlabel, "unlabel", "remove label KEY (repeatable)")
+	note := fs.String("note", "", "free-form note to add to the exec")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || *execID == "" {
+		fmt.Fprintln(os.Stderr, "--machine and --id are required")
+		os.Exit(2)
+	}
+	p := client.ExecPatch{Note: *note, Caller: callerName()}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "name" {
+			p.Name = name
+		}
+	})
+	if len(labels)+len(unlabel) > 0 {
+		p.Labels = map[string]*string{}
+		for k, v := range labels {
+			p.Labels[k] = &v
+		}
+		for _, k := range unlabel {
+			p.Labels[k] = nil
+		}
+	}
+	if p.Name == nil && p.Labels == nil && p.Note == "" {
+		fmt.Fprintln(os.Stderr, "one of --name, --label, --unlabel or --note is required")
+		os.Exit(2)
+	}
+	machineCall(*cfgPath, *machineName, "exec_annotate", func(ctx context.Context, cl *client.Client) (json.RawMessage, error) {
+		return cl.ExecAnnotate(ctx, *execID, p)
+	})
+}
+
+// execRemove runs `exec rm`: it deletes one exec that has ended, or every one
+// whose labels match a selector, with their logs.
+func execRemove(args []string) {
+	fs := flag.NewFlagSet("exec rm", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	execID := fs.String("id", "", "exec id")
+	selector := fs.String("selector", "", "remove every ended exec whose labels match instead, e.g. sweep=lr")
+	status := fs.String("status", "", "with --selector: only execs in this status")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || (*execID == "") == (*selector == "") {
+		fmt.Fprintln(os.Stderr, "--machine and one of --id or --selector are required")
+		os.Exit(2)
+	}
+	machineCall(*cfgPath, *machineName, "exec_rm", func(ctx context.Context, cl *client.Client) (json.RawMessage, error) {
+		if *selector != "" {
+			return cl.ExecDeleteSelected(ctx, client.ExecListOptions{Selector: *selector, Status: *status})
+		}
+		return cl.ExecDelete(ctx, *execID)
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"codex-runner/internal/shared/attach"
+)
+
+func execAttach(args []string) {
+	fs := flag.NewFlagSet("exec attach", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	execID := fs.String("id", "", "exec id (started with --pty)")
+	detachKeys := fs.String("detach-keys", "ctrl-p,ctrl-q", "key sequence that detaches and leaves the exec running")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || *execID == "" {
+		fmt.Fprintln(os.Stderr, "--machine and --id are required")
+		os.Exit(2)
+	}
+	keys, err := parseDetachKeys(*detachKeys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--detach-keys:", err)
+		os.Exit(2)
+	}
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		os.Exit(2)
+	}
+	m, ok := cfg.FindMachine(*machineName)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown machine:", *machineName)
+		os.Exit(2)
+	}
+	cl, closer, _, err := connectClientForExec(*m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if closer != nil {
+		defer closer()
+	}
+	conn, err := cl.ExecAttach(context.Background(), *execID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	restore, err := makeRawTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "attach needs an interactive terminal:", err)
+		os.Exit(1)
+	}
+	code := runAttach(conn, keys, *execID)
+	restore()
+	if closer != nil {
+		closer()
+	}
+	os.Exit(code)
+}
+
+// runAttach relays the local terminal until the exec exits, the detach keys are
+// typed, or the connection drops. It returns the exit code for codex-remote.
+func runAttach(conn io.ReadWriteCloser, keys []byte, execID string) int {
+	fw := &frameWriter{w: conn}
+	sendSize := func() {
+		if rows, cols, err := terminalSize(); err == nil {
+			_ = fw.write(attach.FrameResize, attach.ResizePayload(rows, cols))
+		}
+	}
+	sendSize()
+	stopResize := notifyResize(sendSize)
+	defer stopResize()
+
+	detached := make(chan struct{})
+	go func() {
+		dm := &detachMatcher{keys: keys}
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				out, detach := dm.feed(buf[:n])
+				if len(out) > 0 {
+					if fw.write(attach.FrameData, out) != nil {
+						return
+					}
+				}
+				if detach {
+					close(detached)
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	exited := make(chan string, 1)
+	lost := make(chan error, 1)
+	go func() {
+		for {
+			typ, payload, err := attach.ReadFrame(conn)
+			if err != nil {
+				lost <- err
+				return
+			}
+			switch typ {
+			case attach.FrameData:
+				_, _ = os.Stdout.Write(payload)
+			case attach.FrameExit:
+				exited <- string(payload)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-detached:
+		fmt.Fprintf(os.Stderr, "\r\n[detached from exec %s; it keeps running]\r\n", execID)
+		return 0
+	case code := <-exited:
+		fmt.Fprintf(os.Stderr, "\r\n[exec %s exited with code %s]\r\n", execID, code)
+		n, err := strconv.Atoi(code)
+		if err != nil {
+			return 1
+		}
+		return n
+	case err := <-lost:
+		if errors.Is(err, io.EOF) {
+			err = errors.New("connection closed")
+		}
+		fmt.Fprintf(os.Stderr, "\r\n[attach to exec %s lost: %v]\r\n", execID, err)
+		return 1
+	}
+}
+
+// frameWriter serializes frames from the input and resize goroutines.
+type frameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *frameWriter) write(typ byte, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return attach.WriteFrame(f.w, typ, payload)
+}
+
+// detachMatcher finds the detach key sequence in terminal input. Bytes that
+// might start the sequence are held back until it is clear they do not.
+type detachMatcher struct {
+	keys    []byte
+	matched int
+}
+
+// feed returns the input to forward and whether the sequence was completed.
+func (m *detachMatcher) feed(in []byte) (out []byte, detach bool) {
+	for _, b := range in {
+		if b == m.keys[m.matched] {
+			m.matched++
+			if m.matched == len(m.keys) {
+				return out, true
+			}
+			continue
+		}
+		out = append(out, m.keys[:m.matched]...)
+		m.matched = 0
+		if b == m.keys[0] {
+			m.matched = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
+
+// parseDetachKeys parses a comma separated key sequence like "ctrl-p,ctrl-q";
+// each key is ctrl-<letter or one of @[\]^_> or a single character.
+func parseDetachKeys(v string) ([]byte, error) {
+	var keys []byte
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case len(item) == 1:
+			keys = append(keys, item[0])
+		case len(item) == 6 && strings.HasPrefix(strings.ToLower(item), "ctrl-"):
+			c := strings.ToUpper(item[5:])[0]
+			if c < '@' || c > '_' {
+				return nil, fmt.Errorf("unsupported key %q", item)
+			}
+			keys = append(keys, c&0x1f)
+		default:
+			return nil, fmt.Errorf("unsupported key %q", item)
+		}
+	}
+	return keys, nil
+}
+
+// makeRawTerminal puts the local terminal in raw mode with stty and returns a
+// function that restores the previous settings.
+func makeRawTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { _, _ = stty(strings.TrimSpace(saved)) }, nil
+}
+
+func terminalSize() (rows, cols int, err error) {
+	out, err := stty("size")
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := fmt.Sscan(out, &rows, &cols); err != nil {
+		return 0, 0, err
+	}
+	return rows, cols, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %w", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
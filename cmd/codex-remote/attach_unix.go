@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize calls resize whenever the local terminal changes size.
+func notifyResize(resize func()) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		for range ch {
+			resize()
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"codex-runner/internal/codexremote/client"
+	"codex-runner/internal/shared/jsonutil"
+)
+
+type execListRow struct {
+	ExecID    string `json:"exec_id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	ProjectID string `json:"project_id"`
+	Cmd       string `json:"cmd"`
+	StartedAt string `json:"started_at"`
+	ExitCode  *int   `json:"exit_code"`
+}
+
+func execList(args []string) {
+	fs := flag.NewFlagSet("exec ls", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	status := fs.String("status", "", "filter by status (comma separated)")
+	projectID := fs.String("project", "", "filter by project id")
+	scheduleID := fs.String("schedule", "", "filter by the schedule that created the exec")
+	selector := fs.String("selector", "", "filter by labels: KEY=VAL, KEY!=VAL, KEY or !KEY, comma-separated")
+	since := fs.String("since", "", "started at or after (RFC3339 or relative like 10m)")
+	until := fs.String("until", "", "started at or before (RFC3339 or relative like 10m)")
+	exitCode := fs.String("exit-code", "", "filter by exit code")
+	limit := fs.Int("limit", 50, "max execs per page")
+	cursor := fs.String("cursor", "", "page cursor from a previous next_cursor")
+	all := fs.Bool("all", false, "follow next_cursor until all pages are fetched")
+	jsonOut := fs.Bool("json", false, "output json")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" {
+		fmt.Fprintln(os.Stderr, "--machine is required")
+		os.Exit(2)
+	}
+	sinceRFC3339, err := normalizeTimeBound(*since)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--since:", err)
+		os.Exit(2)
+	}
+	untilRFC3339, err := normalizeTimeBound(*until)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--until:", err)
+		os.Exit(2)
+	}
+	opts := client.ExecListOptions{
+		Status:     *status,
+		ProjectID:  *projectID,
+		ScheduleID: *scheduleID,
+		Selector:   *selector,
+		Since:      sinceRFC3339,
+		Until:      untilRFC3339,
+		Limit:      *limit,
+		Cursor:     *cursor,
+	}
+	if strings.TrimSpace(*exitCode) != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(*exitCode))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "--exit-code must be an integer")
+			os.Exit(2)
+		}
+		opts.ExitCode = &n
+	}
+
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		os.Exit(2)
+	}
+	m, ok := cfg.FindMachine(*machineName)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown machine:", *machineName)
+		os.Exit(2)
+	}
+	cl, closer, tm, err := connectClientForExec(*m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if closer != nil {
+		defer closer()
+	}
+
+	var out client.ExecListResponse
+	for {
+		var page client.ExecListResponse
+		err := withRetry(3, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			resp, callErr := cl.ExecList(ctx, opts)
+			if callErr != nil {
+				return callErr
+			}
+			page = resp
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out.Execs = append(out.Execs, page.Execs...)
+		out.NextCursor = page.NextCursor
+		if !*all || page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if tm != nil {
+		logTunnelEvent("exec_ls", map[string]any{
+			"machine":        tm.machine,
+			"local_port":     tm.localPort,
+			"tunnel_pid":     tm.tunnelPID,
+			"health_latency": tm.healthLatency.String(),
+			"retry_count":    tm.retryCount,
+		})
+	}
+
+	if *jsonOut {
+		if out.Execs == nil {
+			out.Execs = []json.RawMessage{}
+		}
+		_ = jsonutil.WriteJSON(os.Stdout, out)
+		return
+	}
+	rows := make([]execListRow, 0, len(out.Execs))
+	for _, raw := range out.Execs {
+		var row execListRow
+		if err := json.Unmarshal(raw, &row); err != nil {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := writeExecListTable(os.Stdout, rows); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write exec table:", err)
+		os.Exit(1)
+	}
+	if out.NextCursor != "" {
+		fmt.Fprintf(os.Stdout, "\nnext_cursor: %s\n", out.NextCursor)
+	}
+}
+
+func writeExecListTable(w io.Writer, rows []execListRow) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "EXEC_ID\tNAME\tSTATUS\tEXIT\tSTARTED\tPROJECT\tCMD"); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		exit := "-"
+		if row.ExitCode != nil {
+			exit = strconv.Itoa(*row.ExitCode)
+		}
+		project := row.ProjectID
+		if project == "" {
+			project = "-"
+		}
+		name := row.Name
+		if name == "" {
+			name = "-"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", row.ExecID, name, row.Status, exit, row.StartedAt, project, truncateCmd(row.Cmd, 60)); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func truncateCmd(cmd string, max int) string {
+	r := []rune(strings.Join(strings.Fields(cmd), " "))
+	if len(r) <= max {
+		return string(r)
+	}
+	return string(r[:max-3]) + "..."
+}
@@ -14,7 +14,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"os/user"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -27,6 +30,7 @@ import (
 	"codex-runner/internal/codexremote/machcheck"
 	"codex-runner/internal/codexremote/machineup"
 	"codex-runner/internal/codexremote/sshutil"
+	"codex-runner/internal/shared/id"
 	"codex-runner/internal/shared/jsonutil"
 	"codex-runner/internal/shared/selfupdate"
 )
@@ -62,6 +66,8 @@ func main() {
 		syncCmd(os.Args[2:])
 	case "machine":
 		machineCmd(os.Args[2:])
+	case "schedule":
+		scheduleCmd(os.Args[2:])
 	case "file":
 		fileCmd(os.Args[2:])
 	case "dashboard":
@@ -80,13 +86,26 @@ func usage() {
 	fmt.Fprintln(os.Stderr, "codex-remote: local CLI for codexd")
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "Usage:")
-	fmt.Fprintln(os.Stderr, "  codex-remote exec run   --machine <name> [--cmd <string> | --script PATH] [--shell SHELL] [--project <id> --ref <ref>] [--cwd <path>] [--env KEY=VAL ...]")
-	fmt.Fprintln(os.Stderr, "  codex-remote exec start --machine <name> [--cmd <string> | --script PATH] [--shell SHELL] [--project <id> --ref <ref>] [--cwd <path>] [--env KEY=VAL ...]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec run   --machine <name> [--cmd <string> | --script PATH] [--shell SHELL] [--project <id> --ref <ref>] [--cwd <path>] [--env KEY=VAL ...] [--timeout 90m] [--priority N] [--resource gpu=2] [--memory-max 16G] [--cpus 4] [--pids-max N] [--nice N] [--ionice CLASS[:LEVEL]] [--log-max 256M] [--log-head 64M] [--max-attempts N [--retry-backoff 10s] [--retry-max-backoff 10m] [--retry-exit-code N ...] [--retry-pattern REGEXP]] [--name NAME] [--label KEY=VAL ...] [--idempotency-key KEY] [--stdin | --pty]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec start --machine <name> [--cmd <string> | --script PATH] [--shell SHELL] [--project <id> --ref <ref>] [--cwd <path>] [--env KEY=VAL ...] [--timeout 90m] [--priority N] [--resource gpu=2] [--memory-max 16G] [--cpus 4] [--pids-max N] [--nice N] [--ionice CLASS[:LEVEL]] [--log-max 256M] [--log-head 64M] [--max-attempts N [--retry-backoff 10s] [--retry-max-backoff 10m] [--retry-exit-code N ...] [--retry-pattern REGEXP]] [--name NAME] [--label KEY=VAL ...] [--idempotency-key KEY] [--stdin | --pty] [--after <exec_id> ... [--after-policy success|any|failure]] [--not-before RFC3339|2h|02:00]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec rerun  --machine <name> --id <exec_id> [--env KEY=VAL ...]")
 	fmt.Fprintln(os.Stderr, "  codex-remote exec result --machine <name> --id <exec_id>")
-	fmt.Fprintln(os.Stderr, "  codex-remote exec logs --machine <name> --id <exec_id> [--stream stdout|stderr] [--tail 2000] [--tail-lines N] [--since RFC3339|10m] [--until RFC3339|10m]")
-	fmt.Fprintln(os.Stderr, "  codex-remote exec watch --machine <name> --id <exec_id> [--stream stdout|stderr|both] [--poll 1s] [--full]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec ls     --machine <name> [--status running] [--project <id>] [--schedule <id>] [--selector KEY=VAL,...] [--since RFC3339|10m] [--until RFC3339|10m] [--exit-code N] [--limit 50] [--cursor C] [--all] [--json]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec logs --machine <name> --id <exec_id> [--stream both|stdout|stderr] [--tail 2000] [--tail-lines N] [--since RFC3339|10m] [--until RFC3339|10m] [--follow] [--offset N|--seq N] [--limit N] [--attempt N]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec grep --machine <name> (--id <exec_id> | --all [--status S] [--project <id>] [--selector KEY=VAL,...]) --pattern REGEX [--stream both|stdout|stderr] [--context N] [--max-matches 100]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec watch --machine <name> --id <exec_id> [--stream stdout|stderr|both] [--tail 2000] [--full]")
 	fmt.Fprintln(os.Stderr, "  codex-remote exec doctor --machine <name> [--json]")
-	fmt.Fprintln(os.Stderr, "  codex-remote exec cancel --machine <name> --id <exec_id>")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec cancel --machine <name> (--id <exec_id> | --selector KEY=VAL,...) [--reason TEXT] [--grace 30s]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec annotate --machine <name> --id <exec_id> [--name NAME] [--label KEY=VAL ...] [--unlabel KEY ...] [--note TEXT]")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec rm     --machine <name> (--id <exec_id> | --selector KEY=VAL,... [--status S])")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec signal --machine <name> --id <exec_id> --signal USR1")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec pause  --machine <name> --id <exec_id>")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec resume --machine <name> --id <exec_id>")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec stdin  --machine <name> --id <exec_id> [--close]   (sends local stdin)")
+	fmt.Fprintln(os.Stderr, "  codex-remote exec attach --machine <name> --id <exec_id> [--detach-keys ctrl-p,ctrl-q]")
+	fmt.Fprintln(os.Stderr, "  codex-remote schedule add --machine <name> --cron \"0 2 * * *\" [--timezone Europe/Berlin] --cmd <string> [--shell SHELL] [--project <id> --ref <ref>] [--cwd <path>] [--env KEY=VAL ...] [--timeout 90m] [--priority N] [--resource gpu=2] [--memory-max 16G] [--cpus 4] [--pids-max N] [--nice N] [--ionice CLASS[:LEVEL]] [--log-max 256M] [--log-head 64M] [--max-attempts N [--retry-backoff 10s] [--retry-max-backoff 10m] [--retry-exit-code N ...] [--retry-pattern REGEXP]] [--name NAME] [--label KEY=VAL ...]")
+	fmt.Fprintln(os.Stderr, "  codex-remote schedule ls  --machine <name> [--json]")
+	fmt.Fprintln(os.Stderr, "  codex-remote schedule rm  --machine <name> --id <schedule_id>")
 	fmt.Fprintln(os.Stderr, "  codex-remote file write   --machine M --dst PATH [--content C | --src FILE] [--mode 0644] [--mkdir]")
 	fmt.Fprintln(os.Stderr, "  codex-remote file read    --machine M --path PATH [--dst LOCAL_FILE]")
 	fmt.Fprintln(os.Stderr, "  codex-remote sync push --machine <name> --src <local> --dst <remote> [--delete] [--exclude PATTERN ...] [--via-daemon]")
@@ -172,16 +191,36 @@ func execCmd(args []string) {
 		execRun(args[1:])
 	case "start":
 		execStart(args[1:])
+	case "rerun":
+		execRerun(args[1:])
 	case "result":
 		execResult(args[1:])
+	case "ls", "list":
+		execList(args[1:])
 	case "logs":
 		execLogs(args[1:])
+	case "grep":
+		execGrep(args[1:])
 	case "watch":
 		execWatch(args[1:])
 	case "doctor":
 		execDoctor(args[1:])
 	case "cancel":
 		execCancel(args[1:])
+	case "signal":
+		execSignal(args[1:])
+	case "pause":
+		execPause(args[1:])
+	case "resume":
+		execResume(args[1:])
+	case "stdin":
+		execStdin(args[1:])
+	case "attach":
+		execAttach(args[1:])
+	case "annotate":
+		execAnnotate(args[1:])
+	case "rm":
+		execRemove(args[1:])
 	default:
 		usage()
 		os.Exit(2)
@@ -211,6 +250,8 @@ func machineCmd(args []string) {
 func execRun(args []string) {
 	fs := flag.NewFlagSet("exec run", flag.ExitOnError)
 	cfgPath := configFlag(fs)
+	stdinFlag := fs.Bool("stdin", false, "stream local stdin to the remote command")
+	ptyFlag := fs.Bool("pty", false, "run the remote command on a pseudo-terminal")
 	machineName := fs.String("machine", "", "machine name")
 	projectID := fs.String("project", "", "project id")
 	ref := fs.String("ref", "", "git ref (required if project is set)")
@@ -218,6 +259,17 @@ func execRun(args []string) {
 	scriptPath := fs.String("script", "", "local script file to upload and execute")
 	cwd := fs.String("cwd", "", "working dir (relative or absolute)")
 	shell := fs.String("shell", "", "shell to use (sh, bash, zsh)")
+	timeout := fs.Duration("timeout", 0, "stop the exec and mark it timed_out after this duration (e.g. 90m)")
+	priority := fs.Int("priority", 0, "queue priority when the daemon is at max_concurrent_execs (higher runs first)")
+	resources := resourceFlag{}
+	fs.Var(&resources, "resource", "reserve daemon resource slots POOL=N, e.g. gpu=2 (repeatable)")
+	limits := limitFlags(fs)
+	logLimits := logLimitFlags(fs)
+	retry := retryFlags(fs)
+	name := fs.String("name", "", "human name of the exec, e.g. run-42")
+	labels := labelFlag{}
+	fs.Var(&labels, "label", "label KEY=VAL for exec ls/cancel/rm --selector (repeatable)")
+	idempotencyKey := fs.String("idempotency-key", "", "key that makes resubmitting this request return the same exec (default: new per invocation)")
 	envList := multiFlag{}
 	fs.Var(&envList, "env", "environment variable KEY=VAL (repeatable)")
 	if err := fs.Parse(args); err != nil {
@@ -283,9 +335,29 @@ func execRun(args []string) {
 		Cwd:       *cwd,
 		Env:       env,
 		Shell:     *shell,
+		Priority:  *priority,
+		Resources: resources,
+		Stdin:     *stdinFlag,
+		PTY:       *ptyFlag,
+	}
+	if *timeout > 0 {
+		req.Timeout = timeout.String()
+	}
+	req.Limits = limits()
+	req.LogLimits = logLimits()
+	req.Retry = retry()
+	req.Name = *name
+	req.Labels = labels
+	req.IdempotencyKey = *idempotencyKey
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = newIdempotencyKey()
 	}
 
-	if err := cl.ExecRun(ctx, req, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
+	var stdin io.Reader
+	if *stdinFlag {
+		stdin = os.Stdin
+	}
+	if err := cl.ExecRun(ctx, req, stdin, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -303,6 +375,8 @@ func execRun(args []string) {
 func execStart(args []string) {
 	fs := flag.NewFlagSet("exec start", flag.ExitOnError)
 	cfgPath := configFlag(fs)
+	stdinFlag := fs.Bool("stdin", false, "keep the remote command's stdin open for exec stdin")
+	ptyFlag := fs.Bool("pty", false, "run the remote command on a pseudo-terminal for exec attach")
 	machineName := fs.String("machine", "", "machine name")
 	projectID := fs.String("project", "", "project id")
 	ref := fs.String("ref", "", "git ref (required if project is set)")
@@ -310,11 +384,31 @@ func execStart(args []string) {
 	scriptPath := fs.String("script", "", "local script file to upload and execute")
 	cwd := fs.String("cwd", "", "working dir (relative or absolute)")
 	shell := fs.String("shell", "", "shell to use (sh, bash, zsh)")
+	timeout := fs.Duration("timeout", 0, "stop the exec and mark it timed_out after this duration (e.g. 90m)")
+	priority := fs.Int("priority", 0, "queue priority when the daemon is at max_concurrent_execs (higher runs first)")
+	resources := resourceFlag{}
+	fs.Var(&resources, "resource", "reserve daemon resource slots POOL=N, e.g. gpu=2 (repeatable)")
+	limits := limitFlags(fs)
+	logLimits := logLimitFlags(fs)
+	retry := retryFlags(fs)
+	name := fs.String("name", "", "human name of the exec, e.g. run-42")
+	labels := labelFlag{}
+	fs.Var(&labels, "label", "label KEY=VAL for exec ls/cancel/rm --selector (repeatable)")
+	idempotencyKey := fs.String("idempotency-key", "", "key that makes resubmitting this request return the same exec (default: new per invocation)")
 	envList := multiFlag{}
 	fs.Var(&envList, "env", "environment variable KEY=VAL (repeatable)")
+	after := multiFlag{}
+	fs.Var(&after, "after", "start only after this exec id has ended (repeatable)")
+	afterPolicy := fs.String("after-policy", "", "how --after execs must end: success (default), any or failure")
+	notBefore := fs.String("not-before", "", "start no earlier than this: RFC3339, a delay like 2h or a local clock time like 02:00")
 	if err := fs.Parse(args); err != nil {
 		os.Exit(2)
 	}
+	notBeforeRFC3339, err := normalizeStartTime(*notBefore, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--not-before:", err)
+		os.Exit(2)
+	}
 	if *scriptPath != "" && *cmdStr != "" {
 		fmt.Fprintln(os.Stderr, "error: --script and --cmd are mutually exclusive")
 		os.Exit(2)
@@ -369,12 +463,31 @@ func execStart(args []string) {
 	}
 
 	req := client.ExecStartRequest{
-		ProjectID: *projectID,
-		Ref:       *ref,
-		Cmd:       *cmdStr,
-		Cwd:       *cwd,
-		Env:       env,
-		Shell:     *shell,
+		ProjectID:       *projectID,
+		Ref:             *ref,
+		Cmd:             *cmdStr,
+		Cwd:             *cwd,
+		Env:             env,
+		Shell:           *shell,
+		Priority:        *priority,
+		Resources:       resources,
+		Stdin:           *stdinFlag,
+		PTY:             *ptyFlag,
+		DependsOn:       after,
+		DependsOnPolicy: *afterPolicy,
+		NotBefore:       notBeforeRFC3339,
+	}
+	if *timeout > 0 {
+		req.Timeout = timeout.String()
+	}
+	req.Limits = limits()
+	req.LogLimits = logLimits()
+	req.Retry = retry()
+	req.Name = *name
+	req.Labels = labels
+	req.IdempotencyKey = *idempotencyKey
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = newIdempotencyKey()
 	}
 	out, err := execStartOnce(cl, req)
 	if err != nil && tm != nil {
@@ -430,12 +543,90 @@ func execStart(args []string) {
 			"retry_count":    tm.retryCount,
 		})
 	}
-	_ = jsonutil.WriteJSON(os.Stdout, map[string]any{
+	result := map[string]any{
 		"exec_id":  out.ExecID,
 		"machine":  m.Name,
 		"status":   out.Status,
 		"base_url": cl.BaseURL,
-	})
+	}
+	if out.QueuePosition > 0 {
+		result["queue_position"] = out.QueuePosition
+	}
+	if out.IdempotentReplay {
+		result["idempotent_replay"] = true
+	}
+	_ = jsonutil.WriteJSON(os.Stdout, result)
+}
+
+func execRerun(args []string) {
+	fs := flag.NewFlagSet("exec rerun", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	execID := fs.String("id", "", "exec id to rerun")
+	envList := multiFlag{}
+	fs.Var(&envList, "env", "environment variable KEY=VAL to set over the exec's env (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || *execID == "" {
+		fmt.Fprintln(os.Stderr, "--machine and --id are required")
+		os.Exit(2)
+	}
+	env := map[string]string{}
+	for _, kv := range envList {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		env[k] = v
+	}
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		os.Exit(2)
+	}
+	m, ok := cfg.FindMachine(*machineName)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown machine:", *machineName)
+		os.Exit(2)
+	}
+	cl, closer, tm, err := connectClientForExec(*m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if closer != nil {
+		defer closer()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := cl.ExecRerun(ctx, *execID, env)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if tm != nil {
+		logTunnelEvent("exec_rerun", map[string]any{
+			"machine":        tm.machine,
+			"local_port":     tm.localPort,
+			"exec_id":        out.ExecID,
+			"parent_exec_id": *execID,
+			"tunnel_pid":     tm.tunnelPID,
+			"health_latency": tm.healthLatency.String(),
+			"retry_count":    tm.retryCount,
+		})
+	}
+	result := map[string]any{
+		"exec_id":        out.ExecID,
+		"parent_exec_id": out.ParentExecID,
+		"machine":        m.Name,
+		"status":         out.Status,
+		"base_url":       cl.BaseURL,
+	}
+	if out.QueuePosition > 0 {
+		result["queue_position"] = out.QueuePosition
+	}
+	_ = jsonutil.WriteJSON(os.Stdout, result)
 }
 
 func execResult(args []string) {
@@ -504,11 +695,16 @@ func execLogs(args []string) {
 	cfgPath := configFlag(fs)
 	machineName := fs.String("machine", "", "machine name")
 	execID := fs.String("id", "", "exec id")
-	stream := fs.String("stream", "stdout", "stdout or stderr")
+	stream := fs.String("stream", "both", "stdout, stderr, or both interleaved in the order written")
 	tailN := fs.Int64("tail", 2000, "tail bytes")
 	tailLines := fs.Int("tail-lines", 0, "tail lines")
 	since := fs.String("since", "", "lower time bound (RFC3339 or relative like 10m)")
 	until := fs.String("until", "", "upper time bound (RFC3339 or relative like 10m)")
+	follow := fs.Bool("follow", false, "keep streaming new output until the exec finishes (ends with a finished event)")
+	offset := fs.Int64("offset", -1, "read from this byte offset (the next_offset of a previous read) instead of the tail; needs --stream stdout or stderr")
+	seq := fs.Int64("seq", -1, "read from this line number (the next_seq of a previous read) instead of the tail; --stream both only")
+	limit := fs.Int64("limit", 0, "read at most this many bytes from --offset (0 = no limit)")
+	attempt := fs.Int("attempt", 0, "read the logs of this attempt of an exec with a retry policy (default: the last)")
 	if err := fs.Parse(args); err != nil {
 		os.Exit(2)
 	}
@@ -553,9 +749,27 @@ func execLogs(args []string) {
 		Since:     sinceRFC3339,
 		Until:     untilRFC3339,
 		Format:    "jsonl",
-	}
-	if err := withRetry(3, func() error {
-		return cl.ExecLogs(ctx, *execID, opts, os.Stdout)
+		Follow:    *follow,
+		Limit:     *limit,
+		Attempt:   *attempt,
+	}
+	if *offset >= 0 {
+		opts.Offset = offset
+	}
+	if *seq >= 0 {
+		opts.Seq = seq
+	}
+	if *follow {
+		// No retry: a second attempt would repeat the lines already printed.
+		followCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if _, err := cl.ExecLogs(followCtx, *execID, opts, os.Stdout); err != nil && followCtx.Err() == nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else if err := withRetry(3, func() error {
+		_, err := cl.ExecLogs(ctx, *execID, opts, os.Stdout)
+		return err
 	}); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -573,16 +787,86 @@ func execLogs(args []string) {
 	}
 }
 
+func execGrep(args []string) {
+	fs := flag.NewFlagSet("exec grep", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	execID := fs.String("id", "", "exec id")
+	all := fs.Bool("all", false, "search every retained exec, newest first")
+	pattern := fs.String("pattern", "", "regular expression (Go syntax; (?i) for case-insensitive)")
+	stream := fs.String("stream", "both", "stdout, stderr, or both in the order written")
+	contextN := fs.Int("context", 0, "lines of context before and after each match")
+	maxMatches := fs.Int("max-matches", 100, "stop after this many matches")
+	status := fs.String("status", "", "with --all: only execs with this status (comma-separated)")
+	projectID := fs.String("project", "", "with --all: only execs of this project")
+	selector := fs.String("selector", "", "with --all: only execs whose labels match, e.g. sweep=lr")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || *pattern == "" || (*execID == "") == !*all {
+		fmt.Fprintln(os.Stderr, "--machine, --pattern and one of --id or --all are required")
+		os.Exit(2)
+	}
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		os.Exit(2)
+	}
+	m, ok := cfg.FindMachine(*machineName)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown machine:", *machineName)
+		os.Exit(2)
+	}
+	cl, closer, tm, err := connectClientForExec(*m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if closer != nil {
+		defer closer()
+	}
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(sigCtx, 10*time.Minute)
+	defer cancel()
+	opts := client.ExecLogSearchOptions{
+		Pattern:    *pattern,
+		Stream:     *stream,
+		Context:    *contextN,
+		MaxMatches: *maxMatches,
+		Status:     *status,
+		ProjectID:  *projectID,
+		Selector:   *selector,
+	}
+	if err := cl.ExecLogSearch(ctx, *execID, opts, os.Stdout); err != nil && sigCtx.Err() == nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if tm != nil {
+		logTunnelEvent("exec_grep", map[string]any{
+			"machine":        tm.machine,
+			"local_port":     tm.localPort,
+			"exec_id":        *execID,
+			"tunnel_pid":     tm.tunnelPID,
+			"health_latency": tm.healthLatency.String(),
+			"retry_count":    tm.retryCount,
+		})
+	}
+}
+
 func execCancel(args []string) {
 	fs := flag.NewFlagSet("exec cancel", flag.ExitOnError)
 	cfgPath := configFlag(fs)
 	machineName := fs.String("machine", "", "machine name")
 	execID := fs.String("id", "", "exec id")
+	selector := fs.String("selector", "", "cancel every exec whose labels match instead, e.g. sweep=lr")
+	reason := fs.String("reason", "", "why the exec is canceled, recorded in its meta")
+	grace := fs.Duration("grace", 0, "time between SIGTERM and SIGKILL (default: the daemon's cancel_grace)")
 	if err := fs.Parse(args); err != nil {
 		os.Exit(2)
 	}
-	if *machineName == "" || *execID == "" {
-		fmt.Fprintln(os.Stderr, "--machine and --id are required")
+	if *machineName == "" || (*execID == "") == (*selector == "") {
+		fmt.Fprintln(os.Stderr, "--machine and one of --id or --selector are required")
 		os.Exit(2)
 	}
 	cfg, err := loadConfig(*cfgPath)
@@ -605,7 +889,17 @@ func execCancel(args []string) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
-	b, err := cl.ExecCancel(ctx, *execID)
+	opts := client.ExecCancelOptions{
+		Reason: *reason,
+		Grace:  *grace,
+		Caller: callerName(),
+	}
+	var b json.RawMessage
+	if *selector != "" {
+		b, err = cl.ExecCancelSelected(ctx, *selector, opts)
+	} else {
+		b, err = cl.ExecCancel(ctx, *execID, opts)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -626,6 +920,17 @@ func execCancel(args []string) {
 	}
 }
 
+// callerName identifies this CLI to codexd as user@host, for the records it
+// keeps of who did what.
+func callerName() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
+
 func machineCheck(args []string) {
 	fs := flag.NewFlagSet("machine check", flag.ExitOnError)
 	cfgPath := configFlag(fs)
@@ -892,6 +1197,122 @@ func dashboardCmd(args []string) {
 	}
 }
 
+// limitFlags registers the resource limit flags shared by exec run and exec start.
+func limitFlags(fs *flag.FlagSet) func() *client.ExecLimits {
+	memoryMax := fs.String("memory-max", "", "memory limit for the exec (e.g. 16G)")
+	cpus := fs.Float64("cpus", 0, "CPU quota in CPUs (e.g. 2.5)")
+	pidsMax := fs.Int("pids-max", 0, "max processes in the exec")
+	nice := fs.Int("nice", 0, "nice value (-20..19)")
+	ionice := fs.String("ionice", "", "io priority: idle | best-effort[:0-7] | realtime[:0-7]")
+	return func() *client.ExecLimits {
+		l := client.ExecLimits{
+			MemoryMax: *memoryMax,
+			CPUQuota:  *cpus,
+			PidsMax:   *pidsMax,
+			Nice:      *nice,
+			IONice:    *ionice,
+		}
+		if l == (client.ExecLimits{}) {
+			return nil
+		}
+		return &l
+	}
+}
+
+// logLimitFlags registers the output cap flags shared by exec run and exec start.
+func logLimitFlags(fs *flag.FlagSet) func() *client.ExecLogLimits {
+	maxBytes := fs.String("log-max", "", "bytes of output to keep per stream (e.g. 256M); the middle is dropped")
+	head := fs.String("log-head", "", "bytes of the start of each stream to keep under --log-max")
+	rotate := fs.String("log-rotate", "", "size of the rotated log segments")
+	return func() *client.ExecLogLimits {
+		l := client.ExecLogLimits{MaxBytes: *maxBytes, HeadBytes: *head, RotateBytes: *rotate}
+		if l == (client.ExecLogLimits{}) {
+			return nil
+		}
+		return &l
+	}
+}
+
+// retryFlags registers the retry policy flags shared by exec run, exec start
+// and schedule add.
+func retryFlags(fs *flag.FlagSet) func() *client.ExecRetry {
+	maxAttempts := fs.Int("max-attempts", 0, "run the command up to this many times in all while it fails (default 1: no retries)")
+	backoff := fs.Duration("retry-backoff", 0, "wait before the first retry, doubled for each one after it (default 10s)")
+	maxBackoff := fs.Duration("retry-max-backoff", 0, "longest wait between retries (default 10m)")
+	exitCodes := intListFlag{}
+	fs.Var(&exitCodes, "retry-exit-code", "retry only failures with this exit code (repeatable)")
+	pattern := fs.String("retry-pattern", "", "retry only failures with an output line matching this regexp (or one of --retry-exit-code)")
+	return func() *client.ExecRetry {
+		if *maxAttempts <= 1 {
+			return nil
+		}
+		r := &client.ExecRetry{MaxAttempts: *maxAttempts, ExitCodes: exitCodes, LogPattern: *pattern}
+		if *backoff > 0 {
+			r.Backoff = backoff.String()
+		}
+		if *maxBackoff > 0 {
+			r.MaxBackoff = maxBackoff.String()
+		}
+		return r
+	}
+}
+
+// intListFlag collects repeated integer flags.
+type intListFlag []int
+
+func (l *intListFlag) String() string { return fmt.Sprint([]int(*l)) }
+func (l *intListFlag) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("want an integer, got %q", v)
+	}
+	*l = append(*l, n)
+	return nil
+}
+
+// labelFlag collects repeated --label KEY=VAL flags.
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	parts := make([]string, 0, len(l))
+	for k, v := range l {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (l labelFlag) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("want KEY=VAL, got %q", v)
+	}
+	l[k] = val
+	return nil
+}
+
+// resourceFlag collects repeated --resource POOL=N flags.
+type resourceFlag map[string]int
+
+func (r resourceFlag) String() string {
+	parts := make([]string, 0, len(r))
+	for pool, n := range r {
+		parts = append(parts, fmt.Sprintf("%s=%d", pool, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (r resourceFlag) Set(v string) error {
+	pool, count, ok := strings.Cut(v, "=")
+	n, err := strconv.Atoi(count)
+	if !ok || pool == "" || err != nil || n <= 0 {
+		return fmt.Errorf("want POOL=N with N > 0, got %q", v)
+	}
+	r[pool] = n
+	return nil
+}
+
 type multiFlag []string
 
 func (m *multiFlag) String() string { return strings.Join(*m, ",") }
@@ -1027,6 +1448,16 @@ func isRetryableExecErr(err error) bool {
 	return false
 }
 
+// newIdempotencyKey returns the key of one exec start or run invocation, so
+// that its retries after a tunnel error do not create a second exec.
+func newIdempotencyKey() string {
+	key, err := id.New()
+	if err != nil {
+		return ""
+	}
+	return "codex-remote-" + key
+}
+
 func randomID() string {
 	b := make([]byte, 8)
 	_, _ = rand.Read(b)
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
@@ -82,21 +81,23 @@ func TestDefaultRemoteConfigPath(t *testing.T) {
 	}
 }
 
-func TestParseNDJSONLogLines(t *testing.T) {
-	b := []byte(`{"type":"log","stream":"stdout","line":"a"}` + "\n" + `{"type":"log","stream":"stdout","line":"b"}` + "\n")
-	got := parseNDJSONLogLines(b)
-	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
-		t.Fatalf("parseNDJSONLogLines() = %#v", got)
+func TestLogEventRelayDropsFinishedEvent(t *testing.T) {
+	var out bytes.Buffer
+	r := &logEventRelay{w: &out, nextOffset: -1}
+	stream := `{"type":"log","stream":"stdout","line":"a","next_offset":2,"seq":7}` + "\n" + `{"type":"finished","exit_code":0}` + "\n"
+	for _, chunk := range []string{stream[:7], stream[7:50], stream[50:]} {
+		if _, err := r.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
 	}
-}
-
-func TestDeltaLines(t *testing.T) {
-	prev := []string{"a", "b", "c"}
-	curr := []string{"b", "c", "d", "e"}
-	got := deltaLines(prev, curr)
-	b, _ := json.Marshal(got)
-	if string(b) != `["d","e"]` {
-		t.Fatalf("deltaLines() = %s, want [\"d\",\"e\"]", b)
+	if !r.finished {
+		t.Fatalf("finished event not noticed")
+	}
+	if got := out.String(); got != `{"type":"log","stream":"stdout","line":"a","next_offset":2,"seq":7}`+"\n" {
+		t.Fatalf("relayed %q, want only the log event", got)
+	}
+	if r.nextOffset != 2 || r.nextSeq != 8 {
+		t.Fatalf("cursors = %d, %d, want 2, 8", r.nextOffset, r.nextSeq)
 	}
 }
 
@@ -124,3 +125,57 @@ func TestNormalizeTimeBoundDuration(t *testing.T) {
 		t.Fatalf("expected past time, got %s", ts)
 	}
 }
+
+func TestWriteExecListTable(t *testing.T) {
+	code := 3
+	rows := []execListRow{
+		{ExecID: "abc", Name: "lr-sweep-1", Status: "finished", Cmd: "python train.py   --lr 1e-3", StartedAt: "2026-01-01T00:00:00Z", ExitCode: &code},
+		{ExecID: "def", Status: "running", ProjectID: "p1", Cmd: "sleep 30"},
+	}
+	var buf bytes.Buffer
+	if err := writeExecListTable(&buf, rows); err != nil {
+		t.Fatalf("writeExecListTable() error = %v", err)
+	}
+	out := buf.String()
+	for _, c := range []string{"EXEC_ID", "NAME", "abc", "lr-sweep-1", "python train.py --lr 1e-3", "def", "running", "p1"} {
+		if !strings.Contains(out, c) {
+			t.Fatalf("output missing %q: %s", c, out)
+		}
+	}
+}
+
+func TestDetachMatcher(t *testing.T) {
+	keys, err := parseDetachKeys("ctrl-p,ctrl-q")
+	if err != nil || string(keys) != "\x10\x11" {
+		t.Fatalf("parseDetachKeys = %q, %v", keys, err)
+	}
+	m := &detachMatcher{keys: keys}
+	if out, detach := m.feed([]byte("ab\x10")); string(out) != "ab" || detach {
+		t.Fatalf("feed = %q, %v; want ab held before a possible detach", out, detach)
+	}
+	if out, detach := m.feed([]byte("\x10c")); string(out) != "\x10\x10c" || detach {
+		t.Fatalf("feed = %q, %v; want the held key released", out, detach)
+	}
+	if out, detach := m.feed([]byte("x\x10\x11y")); string(out) != "x" || !detach {
+		t.Fatalf("feed = %q, %v; want detach after x", out, detach)
+	}
+}
+
+func TestNormalizeStartTime(t *testing.T) {
+	loc := time.FixedZone("test", 2*60*60)
+	now := time.Date(2026, 3, 1, 23, 30, 0, 0, loc)
+	for in, want := range map[string]string{
+		"2026-03-05T10:00:00+01:00": "2026-03-05T09:00:00Z",
+		"90m":                       "2026-03-01T23:00:00Z",
+		"23:45":                     "2026-03-01T21:45:00Z",
+		"02:00":                     "2026-03-02T00:00:00Z",
+	} {
+		got, err := normalizeStartTime(in, now)
+		if err != nil || got != want {
+			t.Fatalf("normalizeStartTime(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := normalizeStartTime("tomorrow", now); err == nil {
+		t.Fatalf("normalizeStartTime(tomorrow) succeeded, want an error")
+	}
+}
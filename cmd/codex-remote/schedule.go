@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"codex-runner/internal/codexremote/client"
+	"codex-runner/internal/shared/jsonutil"
+)
+
+type scheduleRow struct {
+	ScheduleID string `json:"schedule_id"`
+	Cron       string `json:"cron"`
+	Timezone   string `json:"timezone"`
+	NextRunAt  string `json:"next_run_at"`
+	LastExecID string `json:"last_exec_id"`
+	LastError  string `json:"last_error"`
+	Exec       struct {
+		Cmd string `json:"cmd"`
+	} `json:"exec"`
+}
+
+func scheduleCmd(args []string) {
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "add":
+		scheduleAdd(args[1:])
+	case "ls", "list":
+		scheduleList(args[1:])
+	case "rm", "remove":
+		scheduleRemove(args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func scheduleAdd(args []string) {
+	fs := flag.NewFlagSet("schedule add", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	cron := fs.String("cron", "", `when to start the exec: 5-field cron ("0 2 * * *"), @daily, @hourly, "@every 6h", ...`)
+	timezone := fs.String("timezone", "", "IANA time zone of --cron (default: the daemon's local time)")
+	projectID := fs.String("project", "", "project id")
+	ref := fs.String("ref", "", "git ref (required if project is set)")
+	cmdStr := fs.String("cmd", "", "command string")
+	cwd := fs.String("cwd", "", "working dir (relative or absolute)")
+	shell := fs.String("shell", "", "shell to use (sh, bash, zsh)")
+	timeout := fs.Duration("timeout", 0, "stop each exec and mark it timed_out after this duration (e.g. 90m)")
+	priority := fs.Int("priority", 0, "queue priority when the daemon is at max_concurrent_execs (higher runs first)")
+	resources := resourceFlag{}
+	fs.Var(&resources, "resource", "reserve daemon resource slots POOL=N, e.g. gpu=2 (repeatable)")
+	limits := limitFlags(fs)
+	logLimits := logLimitFlags(fs)
+	retry := retryFlags(fs)
+	name := fs.String("name", "", "human name of each exec")
+	labels := labelFlag{}
+	fs.Var(&labels, "label", "label KEY=VAL of each exec (repeatable)")
+	envList := multiFlag{}
+	fs.Var(&envList, "env", "environment variable KEY=VAL (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || *cron == "" || *cmdStr == "" {
+		fmt.Fprintln(os.Stderr, "--machine, --cron and --cmd are required")
+		os.Exit(2)
+	}
+	env := map[string]string{}
+	for _, kv := range envList {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		env[k] = v
+	}
+	req := client.ScheduleRequest{
+		Cron:     *cron,
+		Timezone: *timezone,
+		Exec: client.ExecStartRequest{
+			ProjectID: *projectID,
+			Ref:       *ref,
+			Cmd:       *cmdStr,
+			Cwd:       *cwd,
+			Env:       env,
+			Shell:     *shell,
+			Priority:  *priority,
+			Resources: resources,
+			Limits:    limits(),
+			LogLimits: logLimits(),
+			Retry:     retry(),
+			Name:      *name,
+			Labels:    labels,
+		},
+	}
+	if *timeout > 0 {
+		req.Exec.Timeout = timeout.String()
+	}
+	machineCall(*cfgPath, *machineName, "schedule_add", func(ctx context.Context, cl *client.Client) (json.RawMessage, error) {
+		return cl.ScheduleAdd(ctx, req)
+	})
+}
+
+func scheduleList(args []string) {
+	fs := flag.NewFlagSet("schedule ls", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	jsonOut := fs.Bool("json", false, "output json")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" {
+		fmt.Fprintln(os.Stderr, "--machine is required")
+		os.Exit(2)
+	}
+	var out client.ScheduleListResponse
+	machineCall(*cfgPath, *machineName, "schedule_ls", func(ctx context.Context, cl *client.Client) (json.RawMessage, error) {
+		var err error
+		out, err = cl.ScheduleList(ctx)
+		return nil, err
+	})
+	if *jsonOut {
+		if out.Schedules == nil {
+			out.Schedules = []json.RawMessage{}
+		}
+		_ = jsonutil.WriteJSON(os.Stdout, out)
+		return
+	}
+	rows := make([]scheduleRow, 0, len(out.Schedules))
+	for _, raw := range out.Schedules {
+		var row scheduleRow
+		if err := json.Unmarshal(raw, &row); err != nil {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := writeScheduleTable(os.Stdout, rows); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write schedule table:", err)
+		os.Exit(1)
+	}
+}
+
+func scheduleRemove(args []string) {
+	fs := flag.NewFlagSet("schedule rm", flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	scheduleID := fs.String("id", "", "schedule id")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || *scheduleID == "" {
+		fmt.Fprintln(os.Stderr, "--machine and --id are required")
+		os.Exit(2)
+	}
+	machineCall(*cfgPath, *machineName, "schedule_rm", func(ctx context.Context, cl *client.Client) (json.RawMessage, error) {
+		return cl.ScheduleDelete(ctx, *scheduleID)
+	})
+}
+
+// machineCall connects to the machine, makes one request and prints
+// the JSON it returns, if any.
+func machineCall(cfgPath, machineName, event string, call func(context.Context, *client.Client) (json.RawMessage, error)) {
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		os.Exit(2)
+	}
+	m, ok := cfg.FindMachine(machineName)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown machine:", machineName)
+		os.Exit(2)
+	}
+	cl, closer, tm, err := connectClientForExec(*m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if closer != nil {
+		defer closer()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	b, err := call(ctx, cl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if tm != nil {
+		logTunnelEvent(event, map[string]any{
+			"machine":        tm.machine,
+			"local_port":     tm.localPort,
+			"tunnel_pid":     tm.tunnelPID,
+			"health_latency": tm.healthLatency.String(),
+			"retry_count":    tm.retryCount,
+		})
+	}
+	if b == nil {
+		return
+	}
+	_, _ = os.Stdout.Write(b)
+	if len(b) == 0 || b[len(b)-1] != '\n' {
+		_, _ = os.Stdout.Write([]byte("\n"))
+	}
+}
+
+func writeScheduleTable(w io.Writer, rows []scheduleRow) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "SCHEDULE_ID\tCRON\tNEXT_RUN\tLAST_EXEC\tCMD"); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		cron := row.Cron
+		if row.Timezone != "" {
+			cron += " (" + row.Timezone + ")"
+		}
+		next := row.NextRunAt
+		if next == "" {
+			next = "-"
+		}
+		last := row.LastExecID
+		switch {
+		case row.LastError != "":
+			last = "error: " + row.LastError
+		case last == "":
+			last = "-"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", row.ScheduleID, cron, next, last, truncateCmd(row.Exec.Cmd, 60)); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
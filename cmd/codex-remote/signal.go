@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func execSignal(args []string) { execControl("signal", args) }
+
+func execPause(args []string) { execControl("pause", args) }
+
+func execResume(args []string) { execControl("resume", args) }
+
+// execControl runs `exec signal|pause|resume`: one request about a running exec
+// whose JSON answer is printed as is.
+func execControl(action string, args []string) {
+	fs := flag.NewFlagSet("exec "+action, flag.ExitOnError)
+	cfgPath := configFlag(fs)
+	machineName := fs.String("machine", "", "machine name")
+	execID := fs.String("id", "", "exec id")
+	sig := new(string)
+	if action == "signal" {
+		fs.StringVar(sig, "signal", "", "signal to send to the exec's process group, e.g. USR1, INT, HUP")
+	}
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *machineName == "" || *execID == "" {
+		fmt.Fprintln(os.Stderr, "--machine and --id are required")
+		os.Exit(2)
+	}
+	if action == "signal" && *sig == "" {
+		fmt.Fprintln(os.Stderr, "--signal is required")
+		os.Exit(2)
+	}
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		os.Exit(2)
+	}
+	m, ok := cfg.FindMachine(*machineName)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown machine:", *machineName)
+		os.Exit(2)
+	}
+	cl, closer, tm, err := connectClientForExec(*m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if closer != nil {
+		defer closer()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	var b json.RawMessage
+	switch action {
+	case "signal":
+		b, err = cl.ExecSignal(ctx, *execID, *sig)
+	case "pause":
+		b, err = cl.ExecPause(ctx, *execID)
+	default:
+		b, err = cl.ExecResume(ctx, *execID)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if tm != nil {
+		logTunnelEvent("exec_"+action, map[string]any{
+			"machine":        tm.machine,
+			"local_port":     tm.localPort,
+			"exec_id":        *execID,
+			"tunnel_pid":     tm.tunnelPID,
+			"health_latency": tm.healthLatency.String(),
+			"retry_count":    tm.retryCount,
+		})
+	}
+	_, _ = os.Stdout.Write(b)
+	if len(b) == 0 || b[len(b)-1] != '\n' {
+		_, _ = os.Stdout.Write([]byte("\n"))
+	}
+}
@@ -20,3 +20,27 @@ func normalizeTimeBound(v string) (string, error) {
 	}
 	return time.Now().UTC().Add(-d).Format(time.RFC3339Nano), nil
 }
+
+// normalizeStartTime reads when to start an exec: RFC3339, a delay like 2h, or
+// a local clock time like 02:00 (the next one to come).
+func normalizeStartTime(v string, now time.Time) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(d).UTC().Format(time.RFC3339), nil
+	}
+	clock, err := time.ParseInLocation("15:04", v, now.Location())
+	if err != nil {
+		return "", errors.New("must be RFC3339, a delay like 2h or a clock time like 02:00")
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
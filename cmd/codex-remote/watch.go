@@ -6,8 +6,8 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"strings"
 	"time"
 
 	"codex-runner/internal/codexremote/client"
@@ -20,8 +20,8 @@ func execWatch(args []string) {
 	machineName := fs.String("machine", "", "machine name")
 	execID := fs.String("id", "", "exec id")
 	stream := fs.String("stream", "both", "stdout|stderr|both")
-	poll := fs.Duration("poll", time.Second, "poll interval")
-	tail := fs.Int64("tail", 2000, "tail bytes fetched each poll")
+	_ = fs.Duration("poll", time.Second, "ignored: codexd pushes new output (kept for compatibility)")
+	tail := fs.Int64("tail", 2000, "tail bytes shown before following new output")
 	full := fs.Bool("full", false, "stream all logs from beginning instead of tail")
 	if err := fs.Parse(args); err != nil {
 		os.Exit(2)
@@ -54,54 +54,26 @@ func execWatch(args []string) {
 		defer closer()
 	}
 
-	streams := []string{"stdout", "stderr"}
-	if *stream == "stdout" {
-		streams = []string{"stdout"}
-	}
-	if *stream == "stderr" {
-		streams = []string{"stderr"}
-	}
-	seen := map[string][]string{}
 	start := time.Now()
-	var lastMeta map[string]any
-
-	for {
-		for _, s := range streams {
-			lines, err := fetchLogLines(cl, *execID, s, *tail, *full)
-			if err != nil && !isRetryableExecErr(err) {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
-			if err != nil {
-				continue
-			}
-			newLines := deltaLines(seen[s], lines)
-			for _, line := range newLines {
-				ev := map[string]any{"type": "log", "stream": s, "line": line}
-				_ = jsonutil.WriteJSON(os.Stdout, ev)
-			}
-			seen[s] = lines
-		}
 
-		meta, err := fetchExecMeta(cl, *execID)
-		if err != nil {
-			if isRetryableExecErr(err) {
-				time.Sleep(*poll)
-				continue
-			}
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
-		lastMeta = meta
-		if status, _ := meta["status"].(string); status == "finished" {
-			break
-		}
-		time.Sleep(*poll)
+	// codexd pushes new lines, in the order they were written, and ends the
+	// response with a finished event once the exec is done.
+	if err := followLogEvents(cl, *execID, *stream, *tail, *full, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lastMeta, err := fetchExecMeta(cl, *execID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	exitCode := 0
 	if v, ok := lastMeta["exit_code"].(float64); ok {
 		exitCode = int(v)
+	} else if lastMeta["status"] != "finished" {
+		// lost: the exit status is unknown, so never report success.
+		exitCode = 1
 	}
 	summary := map[string]any{
 		"type":            "summary",
@@ -112,9 +84,20 @@ func execWatch(args []string) {
 		"stdout_log_path": fmt.Sprintf("exec/%s/stdout.log", *execID),
 		"stderr_log_path": fmt.Sprintf("exec/%s/stderr.log", *execID),
 	}
+	// An exec with a retry policy keeps the logs of each attempt apart.
+	if attempts, ok := lastMeta["attempts"].([]any); ok && len(attempts) > 0 {
+		if last, ok := attempts[len(attempts)-1].(map[string]any); ok {
+			summary["attempts"] = len(attempts)
+			summary["stdout_log_path"] = fmt.Sprintf("exec/%s/%v/stdout.log", *execID, last["log_dir"])
+			summary["stderr_log_path"] = fmt.Sprintf("exec/%s/%v/stderr.log", *execID, last["log_dir"])
+		}
+	}
 	if arts, ok := lastMeta["artifacts"]; ok {
 		summary["artifacts"] = arts
 	}
+	if usage, ok := lastMeta["usage"]; ok {
+		summary["usage"] = usage
+	}
 	_ = jsonutil.WriteJSON(os.Stdout, summary)
 	if exitCode != 0 {
 		os.Exit(exitCode)
@@ -135,79 +118,91 @@ func fetchExecMeta(cl *client.Client, execID string) (map[string]any, error) {
 	return out, err
 }
 
-func fetchLogLines(cl *client.Client, execID string, stream string, tail int64, full bool) ([]string, error) {
-	var raw []byte
-	err := withRetry(3, func() error {
-		var buf bytes.Buffer
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-		opts := client.ExecLogsOptions{
-			Stream:    stream,
-			TailBytes: tail,
-			Format:    "jsonl",
+// followLogEvents copies the log events of stream (stdout, stderr or both) to w
+// until codexd reports the exec finished. A dropped connection resumes after the
+// last line relayed.
+func followLogEvents(cl *client.Client, execID string, stream string, tail int64, full bool, w io.Writer) error {
+	opts := client.ExecLogsOptions{
+		Stream:    stream,
+		TailBytes: tail,
+		Format:    "jsonl",
+		Follow:    true,
+	}
+	if full {
+		opts.Full = true
+		opts.TailBytes = -1
+	}
+	relay := &logEventRelay{w: w, nextOffset: -1}
+	for attempt := 1; ; attempt++ {
+		relay.buf = nil
+		_, err := cl.ExecLogs(context.Background(), execID, opts, relay)
+		if relay.finished {
+			return nil
 		}
-		if full {
-			opts.Full = true
-			opts.TailBytes = -1
+		if err == nil {
+			err = io.ErrUnexpectedEOF
 		}
-		if err := cl.ExecLogs(ctx, execID, opts, &buf); err != nil {
-			return err
+		if !isRetryableExecErr(err) || attempt == 3 {
+			return fmt.Errorf("follow %s: %w", stream, err)
 		}
-		raw = append(raw[:0], buf.Bytes()...)
-		return nil
-	})
-	if err != nil {
-		return nil, err
+		if stream == "both" && relay.nextSeq > 0 {
+			next := relay.nextSeq
+			opts.Seq = &next
+		} else if stream != "both" && relay.nextOffset >= 0 {
+			next := relay.nextOffset
+			opts.Offset = &next
+		}
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
 	}
-	return parseNDJSONLogLines(raw), nil
 }
 
-func parseNDJSONLogLines(b []byte) []string {
-	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
-	out := make([]string, 0, len(lines))
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
+// logEventRelay passes log events through and swallows the finished event,
+// noting that it arrived; exec watch prints its own summary instead. It keeps
+// the cursors after the last event so a dropped follow can resume from there;
+// an attempt event starts them over, as the logs of each attempt have their own.
+type logEventRelay struct {
+	w          io.Writer
+	buf        []byte
+	finished   bool
+	nextOffset int64
+	nextSeq    int64
+}
+
+func (r *logEventRelay) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	for {
+		i := bytes.IndexByte(r.buf, '\n')
+		if i < 0 {
+			return len(p), nil
 		}
-		var obj map[string]any
-		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		line := r.buf[:i+1]
+		r.buf = r.buf[i+1:]
+		var ev struct {
+			Type       string `json:"type"`
+			NextOffset *int64 `json:"next_offset"`
+			Seq        int64  `json:"seq"`
+		}
+		if json.Unmarshal(line, &ev) == nil && ev.Type == "finished" {
+			r.finished = true
 			continue
 		}
-		v, ok := obj["line"].(string)
-		if !ok {
+		if _, err := r.w.Write(line); err != nil {
+			return 0, err
+		}
+		if ev.Type == "attempt" {
+			r.nextOffset, r.nextSeq = 0, 1
 			continue
 		}
-		out = append(out, v)
-	}
-	return out
-}
-
-func deltaLines(prev, curr []string) []string {
-	if len(prev) == 0 {
-		return curr
-	}
-	max := len(prev)
-	if len(curr) < max {
-		max = len(curr)
-	}
-	overlap := 0
-	for k := max; k > 0; k-- {
-		if sameStrings(prev[len(prev)-k:], curr[:k]) {
-			overlap = k
-			break
+		if ev.NextOffset != nil {
+			r.nextOffset = *ev.NextOffset
+		}
+		if ev.Seq > 0 {
+			r.nextSeq = ev.Seq + 1
 		}
 	}
-	return curr[overlap:]
 }
 
-func sameStrings(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
-		}
-	}
-	return true
+// isTerminalStatus mirrors codexd: execs in these states will not produce more output.
+func isTerminalStatus(status string) bool {
+	return status == "finished" || status == "timed_out" || status == "lost" || status == "canceled" || status == "skipped"
 }
@@ -31,6 +31,17 @@ func main() {
 		fmt.Println(service.Version)
 	case "update":
 		update(os.Args[2:])
+	case "supervise":
+		// Internal: started by serve for each exec, see service.Supervise.
+		if len(os.Args) != 3 {
+			usage()
+			os.Exit(2)
+		}
+		os.Exit(service.Supervise(os.Args[2]))
+	case "limit-exec":
+		// Internal: started by supervise to run the exec's shell under its
+		// limits, see service.LimitExec.
+		os.Exit(service.LimitExec(os.Args[2:]))
 	default:
 		usage()
 		os.Exit(2)
@@ -44,6 +55,8 @@ func usage() {
 	fmt.Fprintln(os.Stderr, "  codexd serve [--config <path>]")
 	fmt.Fprintln(os.Stderr, "  codexd version")
 	fmt.Fprintln(os.Stderr, "  codexd update [--check] [--yes]")
+	fmt.Fprintln(os.Stderr, "  codexd supervise <exec_dir>   (internal: runs one exec)")
+	fmt.Fprintln(os.Stderr, "  codexd limit-exec ...         (internal: applies an exec's limits)")
 }
 
 func serve(args []string) {
@@ -3,9 +3,12 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"codex-runner/internal/shared/miniyaml"
 	"codex-runner/internal/shared/osutil"
@@ -17,6 +20,199 @@ type Project struct {
 	MirrorDir string `yaml:"mirror_dir" json:"mirror_dir"`
 }
 
+// ExecLimits are resource limits applied to each exec's process group.
+// Zero values mean "no limit".
+type ExecLimits struct {
+	MemoryMax string  `yaml:"memory_max" json:"memory_max,omitempty"` // bytes or with K/M/G/T suffix, e.g. "16G"
+	CPUQuota  float64 `yaml:"cpu_quota" json:"cpu_quota,omitempty"`   // number of CPUs, e.g. 2.5
+	PidsMax   int     `yaml:"pids_max" json:"pids_max,omitempty"`
+	Nice      int     `yaml:"nice" json:"nice,omitempty"`     // -20..19
+	IONice    string  `yaml:"ionice" json:"ionice,omitempty"` // idle | best-effort[:0-7] | realtime[:0-7]
+}
+
+func (l ExecLimits) IsZero() bool {
+	return l == ExecLimits{}
+}
+
+// Merge returns l with every unset field taken from defaults.
+func (l ExecLimits) Merge(defaults ExecLimits) ExecLimits {
+	if l.MemoryMax == "" {
+		l.MemoryMax = defaults.MemoryMax
+	}
+	if l.CPUQuota == 0 {
+		l.CPUQuota = defaults.CPUQuota
+	}
+	if l.PidsMax == 0 {
+		l.PidsMax = defaults.PidsMax
+	}
+	if l.Nice == 0 {
+		l.Nice = defaults.Nice
+	}
+	if l.IONice == "" {
+		l.IONice = defaults.IONice
+	}
+	return l
+}
+
+func (l ExecLimits) Validate() error {
+	if l.MemoryMax != "" {
+		if _, err := ParseByteSize(l.MemoryMax); err != nil {
+			return fmt.Errorf("memory_max: %w", err)
+		}
+	}
+	if l.CPUQuota < 0 {
+		return errors.New("cpu_quota must be >= 0")
+	}
+	if l.PidsMax < 0 {
+		return errors.New("pids_max must be >= 0")
+	}
+	if l.Nice < -20 || l.Nice > 19 {
+		return errors.New("nice must be between -20 and 19")
+	}
+	if l.IONice != "" {
+		if _, _, err := ParseIONice(l.IONice); err != nil {
+			return fmt.Errorf("ionice: %w", err)
+		}
+	}
+	return nil
+}
+
+// LogLimits cap the output each exec keeps per stream. A log that outgrows
+// MaxBytes keeps its first HeadBytes and a rolling tail in segments of
+// RotateBytes; the output in between is dropped. RotateBytes alone rotates the
+// logs without dropping anything. Empty values mean "no limit".
+type LogLimits struct {
+	MaxBytes    string `yaml:"max_bytes" json:"max_bytes,omitempty"`       // per stream, e.g. "256M"
+	HeadBytes   string `yaml:"head_bytes" json:"head_bytes,omitempty"`     // default: a quarter of max_bytes
+	RotateBytes string `yaml:"rotate_bytes" json:"rotate_bytes,omitempty"` // default: a quarter of the tail
+}
+
+func (l LogLimits) IsZero() bool {
+	return l == LogLimits{}
+}
+
+// Merge returns l with every unset field taken from defaults. An l with its own
+// MaxBytes is returned as is: its head and segment sizes default relative to it.
+func (l LogLimits) Merge(defaults LogLimits) LogLimits {
+	if l.MaxBytes != "" {
+		return l
+	}
+	l.MaxBytes = defaults.MaxBytes
+	if l.HeadBytes == "" {
+		l.HeadBytes = defaults.HeadBytes
+	}
+	if l.RotateBytes == "" {
+		l.RotateBytes = defaults.RotateBytes
+	}
+	return l
+}
+
+func (l LogLimits) Validate() error {
+	_, _, _, err := l.Sizes()
+	return err
+}
+
+// Sizes resolves the limits to bytes, filling in the defaults. maxBytes is 0
+// when logs are not capped, rotate is 0 when they are not rotated either.
+func (l LogLimits) Sizes() (maxBytes, head, rotate int64, err error) {
+	parse := func(name, v string) (int64, error) {
+		if v == "" {
+			return 0, nil
+		}
+		n, err := ParseByteSize(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		return n, nil
+	}
+	if maxBytes, err = parse("max_bytes", l.MaxBytes); err != nil {
+		return 0, 0, 0, err
+	}
+	if head, err = parse("head_bytes", l.HeadBytes); err != nil {
+		return 0, 0, 0, err
+	}
+	if rotate, err = parse("rotate_bytes", l.RotateBytes); err != nil {
+		return 0, 0, 0, err
+	}
+	if maxBytes == 0 {
+		if head != 0 {
+			return 0, 0, 0, errors.New("head_bytes needs max_bytes")
+		}
+		return 0, 0, rotate, nil
+	}
+	if head == 0 {
+		head = maxBytes / 4
+	}
+	if head >= maxBytes {
+		return 0, 0, 0, errors.New("head_bytes must be less than max_bytes")
+	}
+	tail := maxBytes - head
+	if rotate == 0 {
+		rotate = max(tail/4, 1)
+	}
+	if rotate > tail {
+		return 0, 0, 0, errors.New("rotate_bytes must not exceed max_bytes - head_bytes")
+	}
+	return maxBytes, head, rotate, nil
+}
+
+// ParseByteSize parses sizes like "512M", "16G" or "1073741824" (binary units).
+func ParseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// ParseIONice parses "idle", "best-effort[:level]" or "realtime[:level]" into an
+// ioprio class (1 realtime, 2 best-effort, 3 idle) and level (0-7).
+func ParseIONice(v string) (class int, level int, err error) {
+	name, lvl, hasLevel := strings.Cut(strings.ToLower(strings.TrimSpace(v)), ":")
+	switch name {
+	case "realtime", "rt":
+		class = 1
+	case "best-effort", "be":
+		class = 2
+	case "idle":
+		class = 3
+	default:
+		return 0, 0, fmt.Errorf("unknown class %q", name)
+	}
+	level = 4
+	if hasLevel {
+		if class == 3 {
+			return 0, 0, errors.New("idle class takes no level")
+		}
+		level, err = strconv.Atoi(lvl)
+		if err != nil || level < 0 || level > 7 {
+			return 0, 0, fmt.Errorf("level must be 0-7, got %q", lvl)
+		}
+	}
+	if class == 3 {
+		level = 0
+	}
+	return class, level, nil
+}
+
 type Config struct {
 	Listen          string    `yaml:"listen" json:"listen"`
 	DataDir         string    `yaml:"data_dir" json:"data_dir"`
@@ -26,14 +222,77 @@ type Config struct {
 	Projects        []Project `yaml:"projects" json:"projects"`
 	DefaultShell    string    `yaml:"default_shell" json:"default_shell"`
 	MaxFileSize     int64     `yaml:"max_file_size" json:"max_file_size"`
+	// Limits are default resource limits for every exec; requests may override them.
+	Limits ExecLimits `yaml:"limits" json:"limits"`
+	// LogLimits cap the output kept per exec; requests may override them.
+	LogLimits LogLimits `yaml:"log_limits" json:"log_limits"`
+	// CompressLogsAfter is how long after an exec finishes its logs are gzipped,
+	// as a Go duration; "0" compresses them right away and "off" never does.
+	CompressLogsAfter string `yaml:"compress_logs_after" json:"compress_logs_after"`
+	// CancelGrace is how long a canceled or timed-out exec gets between SIGTERM
+	// and SIGKILL, as a Go duration; a cancel request may set its own.
+	CancelGrace string `yaml:"cancel_grace" json:"cancel_grace"`
+	// CgroupParent is a delegated cgroup v2 directory for per-exec child groups.
+	// Empty means autodetect from codexd's own cgroup.
+	CgroupParent string `yaml:"cgroup_parent" json:"cgroup_parent"`
+	// MaxConcurrentExecs caps how many execs run at once; extra execs are queued.
+	// Zero means unlimited.
+	MaxConcurrentExecs int `yaml:"max_concurrent_execs" json:"max_concurrent_execs"`
+	// Resources are named slot pools (e.g. GPU indices) that execs can reserve.
+	Resources map[string][]string `yaml:"resources" json:"resources"`
+	// ResourceEnv names the env var each pool's reserved slots are exported in.
+	ResourceEnv map[string]string `yaml:"resource_env" json:"resource_env"`
+}
+
+// LogCompressDelay returns how long after an exec finishes its logs are
+// gzipped, and false if they are kept as they are.
+func (c Config) LogCompressDelay() (time.Duration, bool) {
+	switch c.CompressLogsAfter {
+	case "off":
+		return 0, false
+	case "0", "":
+		return 0, true
+	}
+	d, err := time.ParseDuration(c.CompressLogsAfter)
+	return d, err == nil && d >= 0
+}
+
+// StopGrace returns cancel_grace, or 3s if it is unset or invalid.
+func (c Config) StopGrace() time.Duration {
+	d, err := time.ParseDuration(c.CancelGrace)
+	if err != nil || d < 0 {
+		return 3 * time.Second
+	}
+	return d
+}
+
+// ResourceEnvVar returns the env var that receives the comma-separated slots
+// reserved from pool: resource_env if set, CUDA_VISIBLE_DEVICES for "gpu",
+// else CODEXD_RESOURCE_<POOL>.
+func (c Config) ResourceEnvVar(pool string) string {
+	if v := c.ResourceEnv[pool]; v != "" {
+		return v
+	}
+	if pool == "gpu" {
+		return "CUDA_VISIBLE_DEVICES"
+	}
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(pool))
+	return "CODEXD_RESOURCE_" + name
 }
 
 func Default() Config {
 	return Config{
-		Listen:         "127.0.0.1:7337",
-		DataDir:        "~/.codexd",
-		RetentionCount: 200,
-		MaxFileSize:    50 * 1024 * 1024,
+		Listen:            "127.0.0.1:7337",
+		DataDir:           "~/.codexd",
+		RetentionCount:    200,
+		MaxFileSize:       50 * 1024 * 1024,
+		CompressLogsAfter: "10m",
+		CancelGrace:       "3s",
 	}
 }
 
@@ -55,6 +314,43 @@ retention_count: 200
 # Optional: max file size for file write API (default: 50MB)
 # max_file_size: 52428800
 
+# Optional: run at most N execs at once; the rest wait in a priority queue (default: unlimited)
+# max_concurrent_execs: 4
+
+# Optional: named resource pools; an exec asking for {gpu: 2} waits for two free
+# slots and gets them in CUDA_VISIBLE_DEVICES (other pools: CODEXD_RESOURCE_<NAME>).
+# resources:
+#   gpu: [0, 1, 2, 3]
+# resource_env:
+#   gpu: CUDA_VISIBLE_DEVICES
+
+# Optional: default resource limits per exec (requests may override).
+# Uses a cgroup v2 child group when codexd's cgroup is delegated, else setrlimit.
+# limits:
+#   memory_max: 32G
+#   cpu_quota: 8
+#   pids_max: 4096
+#   nice: 10
+#   ionice: best-effort:7
+# cgroup_parent: /sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service/codexd.slice
+
+# Optional: cap the output kept per exec and stream (requests may override).
+# A log past max_bytes keeps its first head_bytes and a rolling tail rotated
+# every rotate_bytes; the output in between is dropped and counted in meta.json.
+# log_limits:
+#   max_bytes: 256M
+#   head_bytes: 64M
+#   rotate_bytes: 32M
+
+# Optional: gzip the logs of finished execs this long after they finish
+# (default: 10m; 0 compresses right away, off never). Reads decompress them
+# transparently.
+# compress_logs_after: 24h
+
+# Optional: how long a canceled or timed-out exec gets to exit after SIGTERM
+# before SIGKILL (default: 3s). exec cancel --grace overrides it per cancel.
+# cancel_grace: 30s
+
 # Optional: enable "project_id + ref" execution (requires git on the remote).
 # projects:
 #   - id: projA
@@ -130,6 +426,46 @@ func Load(path string) (Config, error) {
 	if cfg.MaxFileSize <= 0 {
 		cfg.MaxFileSize = 50 * 1024 * 1024
 	}
+	if cfg.MaxConcurrentExecs < 0 {
+		return Config{}, errors.New("max_concurrent_execs must be >= 0")
+	}
+	if err := cfg.Limits.Validate(); err != nil {
+		return Config{}, fmt.Errorf("limits: %w", err)
+	}
+	if err := cfg.LogLimits.Validate(); err != nil {
+		return Config{}, fmt.Errorf("log_limits: %w", err)
+	}
+	if cfg.CompressLogsAfter == "" {
+		cfg.CompressLogsAfter = "10m"
+	}
+	if c := cfg.CompressLogsAfter; c != "off" && c != "0" {
+		if d, err := time.ParseDuration(c); err != nil || d < 0 {
+			return Config{}, fmt.Errorf("compress_logs_after must be a duration like 24h or \"off\", got %q", c)
+		}
+	}
+	if cfg.CancelGrace == "" {
+		cfg.CancelGrace = "3s"
+	}
+	if d, err := time.ParseDuration(cfg.CancelGrace); err != nil || d < 0 {
+		return Config{}, fmt.Errorf("cancel_grace must be a duration like 30s, got %q", cfg.CancelGrace)
+	}
+	for pool, slots := range cfg.Resources {
+		if len(slots) == 0 {
+			return Config{}, fmt.Errorf("resources.%s: at least one slot is required", pool)
+		}
+		seen := map[string]bool{}
+		for _, slot := range slots {
+			if slot == "" || seen[slot] {
+				return Config{}, fmt.Errorf("resources.%s: slots must be unique and non-empty", pool)
+			}
+			seen[slot] = true
+		}
+	}
+	for pool := range cfg.ResourceEnv {
+		if _, ok := cfg.Resources[pool]; !ok {
+			return Config{}, fmt.Errorf("resource_env.%s: unknown resource pool", pool)
+		}
+	}
 	for i := range cfg.AllowedCwdRoots {
 		p, err := osutil.ExpandUser(cfg.AllowedCwdRoots[i])
 		if err != nil {
@@ -194,6 +530,83 @@ func applyMiniYAML(cfg *Config, n miniyaml.Node) error {
 			cfg.MaxFileSize = int64(t)
 		}
 	}
+	if v, ok := n["max_concurrent_execs"]; ok {
+		if t, ok := v.(int); ok {
+			cfg.MaxConcurrentExecs = t
+		}
+	}
+	if v, ok := n["limits"]; ok {
+		if m, ok := v.(map[string]any); ok {
+			if s, ok := m["memory_max"]; ok {
+				cfg.Limits.MemoryMax = fmt.Sprint(s)
+			}
+			switch t := m["cpu_quota"].(type) {
+			case int:
+				cfg.Limits.CPUQuota = float64(t)
+			case string:
+				f, err := strconv.ParseFloat(t, 64)
+				if err != nil {
+					return fmt.Errorf("limits.cpu_quota: %w", err)
+				}
+				cfg.Limits.CPUQuota = f
+			}
+			if t, ok := m["pids_max"].(int); ok {
+				cfg.Limits.PidsMax = t
+			}
+			if t, ok := m["nice"].(int); ok {
+				cfg.Limits.Nice = t
+			}
+			if t, ok := m["ionice"].(string); ok {
+				cfg.Limits.IONice = t
+			}
+		}
+	}
+	if v, ok := n["log_limits"]; ok {
+		if m, ok := v.(map[string]any); ok {
+			if s, ok := m["max_bytes"]; ok {
+				cfg.LogLimits.MaxBytes = fmt.Sprint(s)
+			}
+			if s, ok := m["head_bytes"]; ok {
+				cfg.LogLimits.HeadBytes = fmt.Sprint(s)
+			}
+			if s, ok := m["rotate_bytes"]; ok {
+				cfg.LogLimits.RotateBytes = fmt.Sprint(s)
+			}
+		}
+	}
+	if v, ok := n["compress_logs_after"]; ok {
+		cfg.CompressLogsAfter = fmt.Sprint(v)
+	}
+	if v, ok := n["cancel_grace"]; ok {
+		cfg.CancelGrace = fmt.Sprint(v)
+	}
+	if v, ok := n["cgroup_parent"]; ok {
+		cfg.CgroupParent, _ = v.(string)
+	}
+	if v, ok := n["resources"]; ok {
+		if m, ok := v.(map[string]any); ok {
+			cfg.Resources = map[string][]string{}
+			for pool, it := range m {
+				arr, ok := it.([]any)
+				if !ok {
+					return fmt.Errorf("resources.%s must be a list like [0, 1]", pool)
+				}
+				slots := make([]string, 0, len(arr))
+				for _, slot := range arr {
+					slots = append(slots, fmt.Sprint(slot))
+				}
+				cfg.Resources[pool] = slots
+			}
+		}
+	}
+	if v, ok := n["resource_env"]; ok {
+		if m, ok := v.(map[string]any); ok {
+			cfg.ResourceEnv = map[string]string{}
+			for pool, it := range m {
+				cfg.ResourceEnv[pool] = fmt.Sprint(it)
+			}
+		}
+	}
 	if v, ok := n["projects"]; ok {
 		if arr, ok := v.([]any); ok {
 			var out []Project
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEnsureDefaultConfigCreatesFile(t *testing.T) {
@@ -66,3 +67,130 @@ func TestLoadAfterEnsureDefaultConfig(t *testing.T) {
 		t.Fatalf("cfg.Listen is empty")
 	}
 }
+
+func TestLoadParsesLimits(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.yaml")
+	body := "listen: 127.0.0.1:7337\nlimits:\n  memory_max: 16G\n  cpu_quota: 2.5\n  pids_max: 512\n  nice: 10\n  ionice: best-effort:7\n"
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := ExecLimits{MemoryMax: "16G", CPUQuota: 2.5, PidsMax: 512, Nice: 10, IONice: "best-effort:7"}
+	if cfg.Limits != want {
+		t.Fatalf("cfg.Limits = %#v, want %#v", cfg.Limits, want)
+	}
+	if n, err := ParseByteSize(cfg.Limits.MemoryMax); err != nil || n != 16<<30 {
+		t.Fatalf("ParseByteSize(%q) = %d, %v", cfg.Limits.MemoryMax, n, err)
+	}
+}
+
+func TestLoadRejectsInvalidLimits(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.yaml")
+	if err := os.WriteFile(path, []byte("limits:\n  ionice: turbo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("Load() error = nil, want invalid ionice error")
+	}
+}
+
+func TestLoadParsesLogLimits(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.yaml")
+	if err := os.WriteFile(path, []byte("log_limits:\n  max_bytes: 1G\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	maxBytes, head, rotate, err := cfg.LogLimits.Sizes()
+	if err != nil || maxBytes != 1<<30 || head != 256<<20 || rotate != 192<<20 {
+		t.Fatalf("Sizes() = %d, %d, %d, %v", maxBytes, head, rotate, err)
+	}
+
+	bad := LogLimits{MaxBytes: "1M", HeadBytes: "2M"}
+	if err := bad.Validate(); err == nil {
+		t.Fatalf("Validate(%#v) = nil, want head_bytes error", bad)
+	}
+}
+
+func TestLoadParsesCompressLogsAfter(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.yaml")
+	if err := os.WriteFile(path, []byte("compress_logs_after: 36h\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if d, ok := cfg.LogCompressDelay(); !ok || d != 36*time.Hour {
+		t.Fatalf("LogCompressDelay() = %v, %v", d, ok)
+	}
+	if _, ok := (Config{CompressLogsAfter: "off"}).LogCompressDelay(); ok {
+		t.Fatal(`LogCompressDelay() for "off" = true`)
+	}
+
+	if err := os.WriteFile(path, []byte("compress_logs_after: soon\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("Load() accepted compress_logs_after: soon")
+	}
+}
+
+func TestLoadParsesCancelGrace(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.yaml")
+	if err := os.WriteFile(path, []byte("cancel_grace: 45s\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if d := cfg.StopGrace(); d != 45*time.Second {
+		t.Fatalf("StopGrace() = %v, want 45s", d)
+	}
+	if d := (Config{}).StopGrace(); d != 3*time.Second {
+		t.Fatalf("StopGrace() unset = %v, want 3s", d)
+	}
+
+	if err := os.WriteFile(path, []byte("cancel_grace: -1s\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("Load() accepted cancel_grace: -1s")
+	}
+}
+
+func TestLoadParsesResources(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.yaml")
+	body := "resources:\n  gpu: [0, 1, 2, 3]\n  nvme: [\"scratch0\"]\nresource_env:\n  nvme: SCRATCH_DIR\n"
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.Resources["gpu"], ","); got != "0,1,2,3" {
+		t.Fatalf("gpu slots = %q, want 0,1,2,3", got)
+	}
+	if got := cfg.ResourceEnvVar("gpu"); got != "CUDA_VISIBLE_DEVICES" {
+		t.Fatalf("gpu env = %q", got)
+	}
+	if got := cfg.ResourceEnvVar("nvme"); got != "SCRATCH_DIR" {
+		t.Fatalf("nvme env = %q", got)
+	}
+	if got := cfg.ResourceEnvVar("fpga-x"); got != "CODEXD_RESOURCE_FPGA_X" {
+		t.Fatalf("default env = %q", got)
+	}
+}
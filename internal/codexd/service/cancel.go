@@ -0,0 +1,253 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"codex-runner/internal/shared/jsonutil"
+)
+
+// cancelPoll is how often a cancel checks whether the exec's process has
+// started, when it came before the process did.
+const cancelPoll = 50 * time.Millisecond
+
+// cancelPath records the cancel of an exec. codexd writes it before signaling
+// the exec, and whoever finalizes the exec (the supervisor, or codexd for a
+// queued or orphaned exec) reports it as canceled. The first cancel wins.
+func cancelPath(execDir string) string { return filepath.Join(execDir, "cancel.json") }
+
+// execCancel is a cancel as recorded in cancel.json.
+type execCancel struct {
+	CanceledAt string        `json:"canceled_at"`
+	Reason     string        `json:"reason,omitempty"`
+	CanceledBy *execCanceler `json:"canceled_by,omitempty"`
+}
+
+// execCanceler is who asked for a cancel.
+type execCanceler struct {
+	Caller     string `json:"caller,omitempty"` // as the client named itself, e.g. user@host
+	Token      string `json:"token,omitempty"`  // "sha256:<prefix>" of the bearer token used
+	RemoteAddr string `json:"remote_addr,omitempty"`
+}
+
+func readCancel(execDir string) (execCancel, bool) {
+	b, err := os.ReadFile(cancelPath(execDir))
+	if err != nil {
+		return execCancel{}, false
+	}
+	var c execCancel
+	if err := json.Unmarshal(b, &c); err != nil {
+		return execCancel{}, false
+	}
+	return c, true
+}
+
+// recordCancel writes c unless the exec was canceled already, and returns the
+// cancel that stands.
+func recordCancel(execDir string, c execCancel) (execCancel, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return execCancel{}, err
+	}
+	f, err := os.OpenFile(cancelPath(execDir), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		if prev, ok := readCancel(execDir); ok {
+			return prev, nil
+		}
+		return c, writeFileAtomic(cancelPath(execDir), b)
+	}
+	if err != nil {
+		return execCancel{}, err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return c, err
+}
+
+// apply marks meta as canceled by c.
+func (c execCancel) apply(meta execMeta) execMeta {
+	meta.Status = statusCanceled
+	meta.CanceledAt = c.CanceledAt
+	meta.CancelReason = c.Reason
+	meta.CanceledBy = c.CanceledBy
+	return meta
+}
+
+// cancelRequest is the body of a cancel request; all fields are optional but
+// Selector, which POST /v1/exec/cancel requires.
+type cancelRequest struct {
+	Reason   string `json:"reason"`
+	Grace    string `json:"grace"`
+	Caller   string `json:"caller"`
+	Selector string `json:"selector"`
+}
+
+// decodeCancel reads the body of a cancel request and the cancel it asks for,
+// answering the request itself if it is invalid.
+func (s *Service) decodeCancel(w http.ResponseWriter, r *http.Request) (cancelRequest, execCancel, time.Duration, bool) {
+	var body cancelRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeErr(w, http.StatusBadRequest, "invalid json body")
+		return body, execCancel{}, 0, false
+	}
+	grace := s.cfg.StopGrace()
+	if body.Grace != "" {
+		d, err := time.ParseDuration(body.Grace)
+		if err != nil || d < 0 {
+			writeErr(w, http.StatusBadRequest, "grace must be a duration like 30s")
+			return body, execCancel{}, 0, false
+		}
+		grace = d
+	}
+	c := execCancel{
+		CanceledAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Reason:     strings.TrimSpace(body.Reason),
+		CanceledBy: canceler(r, s.cfg.AuthToken, body.Caller),
+	}
+	return body, c, grace, true
+}
+
+// handleExecCancel serves POST /v1/exec/{id}/cancel with an optional body
+// {"reason": "...", "grace": "30s", "caller": "user@host"}. A queued exec is
+// dropped right away. A running one gets SIGTERM, and SIGKILL once grace
+// (default cancel_grace) has passed; the request does not wait for it to exit.
+func (s *Service) handleExecCancel(w http.ResponseWriter, r *http.Request) {
+	_, c, grace, ok := s.decodeCancel(w, r)
+	if !ok {
+		return
+	}
+	out, err := s.cancelExec(r.PathValue("id"), c, grace)
+	if errors.Is(err, os.ErrNotExist) {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to record cancel")
+		return
+	}
+	_ = jsonutil.WriteJSON(w, out)
+}
+
+// handleExecCancelSelected serves POST /v1/exec/cancel with a body as for one
+// exec plus a label "selector" (see parseLabelSelector): it cancels every exec
+// that matches and has not ended, and answers with the outcome for each.
+func (s *Service) handleExecCancelSelected(w http.ResponseWriter, r *http.Request) {
+	body, c, grace, ok := s.decodeCancel(w, r)
+	if !ok {
+		return
+	}
+	sel, err := parseLabelSelector(body.Selector)
+	if err != nil || len(sel) == 0 {
+		writeErr(w, http.StatusBadRequest, "a label selector is required, e.g. sweep=lr")
+		return
+	}
+	metas, err := s.selectExecs(sel)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list execs")
+		return
+	}
+	results := []map[string]any{}
+	canceled := 0
+	for _, meta := range metas {
+		if isTerminalStatus(meta.Status) {
+			continue
+		}
+		out, err := s.cancelExec(meta.ExecID, c, grace)
+		if err != nil {
+			out = map[string]any{"canceled": false, "error": err.Error()}
+		}
+		if out["canceled"] == true {
+			canceled++
+		}
+		out["exec_id"] = meta.ExecID
+		results = append(results, out)
+	}
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"selector": body.Selector,
+		"canceled": canceled,
+		"execs":    results,
+	})
+}
+
+// cancelExec cancels an exec and returns the answer to a cancel request for
+// it; the error wraps os.ErrNotExist for an unknown exec.
+func (s *Service) cancelExec(id string, c execCancel, grace time.Duration) (map[string]any, error) {
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	if ticket := s.queue.cancel(id); ticket != nil {
+		if meta, err := readMeta(execDir); err == nil {
+			finalizeMeta(execDir, c.apply(meta), statusCanceled, 1, errQueueCanceled)
+		}
+		ticket.abort()
+		return map[string]any{
+			"canceled":    true,
+			"status":      statusCanceled,
+			"canceled_at": c.CanceledAt,
+			"reason":      "removed from queue",
+		}, nil
+	}
+	meta, err := readMeta(execDir)
+	if err != nil {
+		return nil, fmt.Errorf("exec %s: %w", id, os.ErrNotExist)
+	}
+	if isTerminalStatus(meta.Status) {
+		return map[string]any{
+			"canceled": false,
+			"status":   meta.Status,
+			"reason":   "already finished",
+		}, nil
+	}
+	c, err = recordCancel(execDir, c)
+	if err != nil {
+		return nil, err
+	}
+	go stopCanceled(execDir, grace)
+	return map[string]any{
+		"canceled":    true,
+		"status":      "canceling",
+		"canceled_at": c.CanceledAt,
+		"grace":       grace.String(),
+	}, nil
+}
+
+// stopCanceled stops the process group of a canceled exec once it has one. An
+// exec whose supervisor has not started yet never starts its process.
+func stopCanceled(execDir string, grace time.Duration) {
+	for {
+		meta, err := readMeta(execDir)
+		if err != nil || isTerminalStatus(meta.Status) {
+			return
+		}
+		if pid, err := readPID(execDir); err == nil {
+			// stopExecGroup continues it if it was paused.
+			_ = stopExecGroup(pid, grace)
+			_ = os.Remove(pausedPath(execDir))
+			return
+		}
+		time.Sleep(cancelPoll)
+	}
+}
+
+// canceler identifies the caller of a cancel request: the name the client gave,
+// the token it authenticated with, and where the request came from.
+func canceler(r *http.Request, authToken, caller string) *execCanceler {
+	by := &execCanceler{Caller: strings.TrimSpace(caller), RemoteAddr: r.RemoteAddr}
+	if authToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		sum := sha256.Sum256([]byte(token))
+		by.Token = "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	return by
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+)
+
+// outputCapture is the supervisor's end of an exec's stdout and stderr. It
+// appends the output to stdout.log and stderr.log and indexes every line in
+// logs.idx as it arrives, numbering lines across both streams. The logs are
+// capped and rotated as the exec's log limits say. The index rotates with them,
+// and a segment of it goes once the logs have dropped all its lines.
+type outputCapture struct {
+	mu      sync.Mutex
+	index   *logWriter
+	entries []byte    // index entries not written yet
+	pending []int64   // where each log was indexed to before entries
+	from    [][]int64 // the same for the first entry of each index tail segment left
+	seq     int64
+	streams []*capturedStream
+
+	pipes   []*os.File // read ends, closed by finish
+	copying sync.WaitGroup
+	closed  sync.Once
+}
+
+// capturedStream is one log of an outputCapture; writes to it are output.
+type capturedStream struct {
+	c       *outputCapture
+	name    string
+	log     *logWriter
+	size    int64 // bytes written to the log
+	indexed int64 // offset just past the last indexed line
+}
+
+func openCapture(execDir string, limits config.LogLimits) (*outputCapture, error) {
+	logLimits := newLogFileLimits(limits)
+	// Index segments hold whole entries, so they can be read on their own.
+	indexLimits := logFileLimits{head: logLimits.rotate, rotate: logLimits.rotate, whole: true}
+	index, err := openLogWriter(logIndexPath(execDir), indexLimits)
+	if err != nil {
+		return nil, err
+	}
+	c := &outputCapture{index: index}
+	for _, name := range []string{"stdout", "stderr"} {
+		log, err := openLogWriter(filepath.Join(execDir, name+".log"), logLimits)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		c.streams = append(c.streams, &capturedStream{c: c, name: name, log: log, size: log.size, indexed: log.size})
+	}
+	return c, nil
+}
+
+// dropped returns the bytes dropped from each log that hit its limit, or nil.
+func (c *outputCapture) dropped() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out map[string]int64
+	for _, s := range c.streams {
+		if n := s.log.dropped(); n > 0 {
+			if out == nil {
+				out = map[string]int64{}
+			}
+			out[s.name] = n
+		}
+	}
+	return out
+}
+
+func (c *outputCapture) stream(name string) *capturedStream {
+	for _, s := range c.streams {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// pipe returns the write end of a pipe into the named stream. Give it to the
+// child and close it once the child has started.
+func (c *outputCapture) pipe(name string) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c.pipes = append(c.pipes, r)
+	c.copying.Add(1)
+	go func() {
+		defer c.copying.Done()
+		_, _ = io.Copy(c.stream(name), r)
+	}()
+	return w, nil
+}
+
+func (s *capturedStream) Write(p []byte) (int, error) {
+	c := s.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := s.log.Write(p)
+	start := s.size
+	s.size += int64(n)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.record(s, start+int64(i)+1)
+		}
+	}
+	c.writeIndex()
+	return n, err
+}
+
+// record indexes the line of s that ends at end. The caller holds c.mu.
+func (c *outputCapture) record(s *capturedStream, end int64) {
+	c.seq++
+	entry := fmt.Appendf(nil, "%d %s %d %d\n", c.seq, s.name, end, time.Now().UnixMilli())
+	if len(c.entries) > 0 && int64(len(c.entries)+len(entry)) > c.index.room() {
+		// The index segment is full: the entry goes in the next one.
+		c.writeIndex()
+	}
+	if len(c.entries) == 0 {
+		c.pending = c.indexed()
+	}
+	c.entries = append(c.entries, entry...)
+	s.indexed = end
+}
+
+// indexed returns how far each log is indexed. The caller holds c.mu.
+func (c *outputCapture) indexed() []int64 {
+	out := make([]int64, len(c.streams))
+	for i, s := range c.streams {
+		out[i] = s.indexed
+	}
+	return out
+}
+
+// writeIndex writes the recorded entries and trims the index. The caller holds
+// c.mu.
+func (c *outputCapture) writeIndex() {
+	if len(c.entries) == 0 {
+		return
+	}
+	segs := len(c.index.segs)
+	_, _ = c.index.Write(c.entries)
+	c.entries = c.entries[:0]
+	if len(c.index.segs) > segs {
+		c.from = append(c.from, c.pending)
+	}
+	for i := 0; i+1 < len(c.from); {
+		if c.linesDropped(c.from[i], c.from[i+1]) && c.index.remove(i) {
+			c.from = append(c.from[:i], c.from[i+1:]...)
+		} else {
+			i++
+		}
+	}
+}
+
+// linesDropped reports whether the logs have dropped every line indexed from
+// from up to next. The caller holds c.mu.
+func (c *outputCapture) linesDropped(from, next []int64) bool {
+	for i, s := range c.streams {
+		if from[i] == next[i] {
+			continue
+		}
+		if len(s.log.segs) == 0 || from[i] < s.log.limits.head || next[i] > s.log.segs[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// finish is called once the exec has exited. It waits for the output still in
+// the pipes, giving background children that keep them open execStopGrace, and
+// then closes the logs.
+func (c *outputCapture) finish() {
+	drained := make(chan struct{})
+	go func() {
+		c.copying.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(execStopGrace):
+	}
+	for _, r := range c.pipes {
+		r.Close()
+	}
+	<-drained
+	c.close()
+}
+
+// close indexes a trailing line that had no newline and closes the files. It is
+// safe to call more than once.
+func (c *outputCapture) close() {
+	c.closed.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, s := range c.streams {
+			if s.size > s.indexed {
+				c.record(s, s.size)
+				c.writeIndex()
+			}
+			s.log.Close()
+		}
+		c.index.Close()
+	})
+}
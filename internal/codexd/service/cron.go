@@ -0,0 +1,168 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronHorizon bounds how far ahead cronExpr.next looks for a match.
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// cronExpr is a parsed cron expression: five fields, minute hour day-of-month
+// month day-of-week, each *, a number or name, a range a-b, a step */n or a-b/n,
+// or a comma-separated list of those. Day-of-week 0 and 7 are Sunday. As in
+// cron, a time matches if either day field does when both are restricted.
+// "@hourly", "@daily" ("@midnight"), "@weekly", "@monthly" and "@yearly"
+// ("@annually") stand for the usual expressions, and "@every <duration>" is
+// due every duration from the last run.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64 // bit i set if value i matches
+	domAny, dowAny                bool
+	every                         time.Duration
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseCron(s string) (cronExpr, error) {
+	s = strings.TrimSpace(s)
+	if d, ok := strings.CutPrefix(s, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every < time.Second {
+			return cronExpr{}, errors.New("@every needs a duration of at least 1s, e.g. @every 6h")
+		}
+		return cronExpr{every: every}, nil
+	}
+	if m, ok := cronMacros[strings.ToLower(s)]; ok {
+		s = m
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return cronExpr{}, errors.New("cron expression must have 5 fields: minute hour day-of-month month day-of-week")
+	}
+	var c cronExpr
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return cronExpr{}, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return cronExpr{}, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return cronExpr{}, fmt.Errorf("day-of-month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return cronExpr{}, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return cronExpr{}, fmt.Errorf("day-of-week: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField parses one field into a bit set over [lo, hi]. names, if set,
+// are accepted in place of the values from 0 on.
+func parseCronField(field string, lo, hi int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+		first, last := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if first, err = parseCronValue(a, lo, hi, names); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = parseCronValue(b, lo, hi, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				last = hi
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, lo, hi int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%q is not in %d-%d", s, lo, hi)
+	}
+	return n, nil
+}
+
+// next returns the first time after t that matches, in t's location, or the
+// zero time if there is none within cronHorizon.
+func (c cronExpr) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	for end := t.Add(cronHorizon); t.Before(end); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c cronExpr) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
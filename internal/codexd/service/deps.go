@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// What the depends_on of an exec must have ended as for it to run.
+const (
+	dependsOnSuccess = "success" // all finished with exit code 0 (the default)
+	dependsOnAny     = "any"     // all ended, however
+	dependsOnFailure = "failure" // all ended and at least one did not succeed
+)
+
+// dependencyPollInterval is how often a waiting exec checks its not_before and
+// dependencies.
+const dependencyPollInterval = 250 * time.Millisecond
+
+// checkDependencies validates the depends_on of a request: the execs must
+// exist, so the dependencies cannot form a cycle.
+func (s *Service) checkDependencies(req *execRequest) error {
+	if len(req.DependsOn) == 0 {
+		if req.DependsOnPolicy != "" {
+			return errors.New("depends_on_policy needs depends_on")
+		}
+		return nil
+	}
+	switch req.DependsOnPolicy {
+	case "":
+		req.DependsOnPolicy = dependsOnSuccess
+	case dependsOnSuccess, dependsOnAny, dependsOnFailure:
+	default:
+		return errors.New("depends_on_policy must be success, any or failure")
+	}
+	for _, id := range req.DependsOn {
+		if id == "" || filepath.Base(id) != id {
+			return fmt.Errorf("depends_on: invalid exec_id %q", id)
+		}
+		if _, err := os.Stat(metaPath(filepath.Join(s.cfg.DataDir, "exec", id))); err != nil {
+			return fmt.Errorf("depends_on: exec_id %q not found", id)
+		}
+	}
+	return nil
+}
+
+// awaitStart holds a waiting exec until its not_before has passed and its
+// dependencies have ended. It returns true with the meta to go on with if the
+// exec is to run; otherwise the exec has ended as skipped, or canceled.
+func (s *Service) awaitStart(execDir string, meta execMeta, req execRequest) (execMeta, bool) {
+	for {
+		if c, ok := readCancel(execDir); ok {
+			return finalizeMeta(execDir, c.apply(meta), statusCanceled, 1, errors.New("canceled while waiting to start")), false
+		}
+		if wait := time.Until(req.notBefore); wait > 0 {
+			time.Sleep(min(wait, dependencyPollInterval))
+			continue
+		}
+		done, why := s.dependenciesDone(req.DependsOn, req.DependsOnPolicy)
+		switch {
+		case !done:
+			time.Sleep(dependencyPollInterval)
+			continue
+		case why != "":
+			meta.Status = statusSkipped
+			meta.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+			meta.Error = why
+			_ = writeMeta(execDir, meta)
+			return meta, false
+		}
+		meta.Status = statusRunning
+		meta.StartedAt = time.Now().UTC().Format(time.RFC3339Nano)
+		_ = writeMeta(execDir, meta)
+		return meta, true
+	}
+}
+
+// dependenciesDone reports whether the dependencies have resolved under policy
+// and, if the exec is not to run, why. With policy success that is as soon as
+// one of them fails.
+func (s *Service) dependenciesDone(ids []string, policy string) (bool, string) {
+	pending := false
+	failed := ""
+	for _, id := range ids {
+		meta, err := readMeta(filepath.Join(s.cfg.DataDir, "exec", id))
+		if err != nil {
+			// Pruned by retention: it ended, but how is unknown.
+			if policy == dependsOnAny {
+				continue
+			}
+			return true, fmt.Sprintf("dependency %s is gone; its outcome is unknown", id)
+		}
+		switch {
+		case !isTerminalStatus(meta.Status):
+			pending = true
+		case meta.Status == statusFinished && meta.ExitCode != nil && *meta.ExitCode == 0:
+		case policy == dependsOnSuccess:
+			return true, "dependency " + id + " " + outcome(meta)
+		case failed == "":
+			failed = id
+		}
+	}
+	switch {
+	case pending:
+		return false, ""
+	case policy == dependsOnFailure && failed == "":
+		return true, "no dependency failed"
+	}
+	return true, ""
+}
+
+// outcome describes how an exec that did not succeed ended.
+func outcome(meta execMeta) string {
+	if meta.Status == statusFinished && meta.ExitCode != nil {
+		return fmt.Sprintf("finished with exit code %d", *meta.ExitCode)
+	}
+	return "ended " + meta.Status
+}
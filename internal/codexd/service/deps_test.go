@@ -0,0 +1,79 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecDependsOn(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	firstID := startExec(t, h, "sleep 0.5")
+	thenID := startExecWithBody(t, h, map[string]any{"cmd": "echo then", "depends_on": []string{firstID}})
+	var meta map[string]any
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+thenID, nil), &meta); err != nil {
+		t.Fatalf("invalid meta: %v", err)
+	}
+	if meta["status"] != "waiting" || meta["depends_on_policy"] != "success" {
+		t.Fatalf("dependent exec = %v, want waiting on success", meta)
+	}
+	first := waitFinished(t, h, firstID, 5*time.Second)
+	then := waitFinished(t, h, thenID, 5*time.Second)
+	if then["exit_code"] != float64(0) || then["started_at"].(string) < first["finished_at"].(string) {
+		t.Fatalf("dependent exec = %v, want it run after %v", then, first)
+	}
+
+	failedID := startExec(t, h, "exit 3")
+	waitFinished(t, h, failedID, 5*time.Second)
+	skipped := waitStatus(t, h, startExecWithBody(t, h, map[string]any{"cmd": "echo no", "depends_on": []string{failedID}}), "skipped", 5*time.Second)
+	if errMsg, _ := skipped["error"].(string); !strings.Contains(errMsg, "exit code 3") || skipped["started_at"] != nil {
+		t.Fatalf("skipped exec = %v, want it not started and the failure explained", skipped)
+	}
+	for policy, deps := range map[string][]string{"failure": {failedID}, "any": {failedID, thenID}} {
+		execID := startExecWithBody(t, h, map[string]any{"cmd": "true", "depends_on": deps, "depends_on_policy": policy})
+		if meta := waitFinished(t, h, execID, 5*time.Second); meta["exit_code"] != float64(0) {
+			t.Fatalf("policy %s: exec = %v, want it run", policy, meta)
+		}
+	}
+	if meta := waitStatus(t, h, startExecWithBody(t, h, map[string]any{"cmd": "true", "depends_on": []string{thenID}, "depends_on_policy": "failure"}), "skipped", 5*time.Second); meta["error"] != "no dependency failed" {
+		t.Fatalf("policy failure after success: exec = %v, want skipped", meta)
+	}
+
+	for name, body := range map[string]string{
+		"unknown exec": `{"cmd":"true","depends_on":["nope"]}`,
+		"bad policy":   `{"cmd":"true","depends_on":["` + firstID + `"],"depends_on_policy":"sometimes"}`,
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s => %d, want 400", name, rr.Code)
+		}
+	}
+}
+
+func TestExecCancelWaiting(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	blockerID := startExec(t, h, "sleep 30")
+	t.Cleanup(func() { cancelExec(t, h, blockerID) })
+	waitingID := startExecWithBody(t, h, map[string]any{"cmd": "echo never", "depends_on": []string{blockerID}})
+	cancelExec(t, h, waitingID)
+	var meta map[string]any
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+waitingID, nil), &meta); err != nil {
+		t.Fatalf("invalid meta: %v", err)
+	}
+	if meta["started_at"] != nil || meta["pid"] != nil {
+		t.Fatalf("canceled waiting exec was started: %v", meta)
+	}
+}
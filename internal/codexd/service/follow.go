@@ -0,0 +1,340 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// followPollInterval is how often a streamed exec's log files are checked for output.
+const followPollInterval = 100 * time.Millisecond
+
+// streamExec runs the exec and relays its output as log events, in the order it
+// was written, until it finishes. If the client goes away, the exec is stopped
+// like a foreground command if leave says so, and left to run otherwise.
+func (s *Service) streamExec(ctx context.Context, execDir string, req execRequest, meta execMeta, ew *eventWriter, leave func() bool) {
+	done := make(chan execMeta, 1)
+	go func() { done <- s.runExec(execDir, req, meta) }()
+	s.followRun(ctx, execDir, done, ew, leave)
+}
+
+// followRun relays an exec's output as log events until done delivers its final
+// meta, then ends with its finished event. If the client goes away first, it
+// stops the exec if stop, when set, reports true, and waits for done; with stop
+// nil, it returns right away.
+func (s *Service) followRun(ctx context.Context, execDir string, done <-chan execMeta, ew *eventWriter, stop func() bool) {
+	index := openLogIndex(execDir)
+	index.expect()
+	m := newLogMerger(execDir, index, map[string]int64{"stdout": 0, "stderr": 0}, 1)
+	emit := func(l logLine) error { return ew.Write(l.event()) }
+	announce := func(ev map[string]any) error { return ew.Write(ev) }
+	attempt := 0
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	stopping := false
+	for {
+		select {
+		case final := <-done:
+			if !stopping {
+				var err error
+				for ; err == nil && attempt < len(final.Attempts); attempt++ {
+					m, err = nextAttempt(execDir, m, attempt, final, emit, announce)
+				}
+				if err == nil {
+					_ = m.flush(emit)
+				}
+				_ = ew.Write(finishedEvent(final))
+			}
+			return
+		case <-ticker.C:
+		}
+		if stopping {
+			continue
+		}
+		var err error
+		if meta, metaErr := readMeta(execDir); metaErr == nil {
+			for ; err == nil && attempt < len(meta.Attempts); attempt++ {
+				m, err = nextAttempt(execDir, m, attempt, meta, emit, announce)
+			}
+		}
+		if err == nil {
+			err = m.poll(emit)
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			continue
+		}
+		if stop == nil {
+			return
+		}
+		if !stop() {
+			<-done
+			return
+		}
+		// The client is gone. Stop the exec once its process exists.
+		if pid, pidErr := readPID(execDir); pidErr == nil {
+			stopping = true
+			go func() { _ = stopExecGroup(pid, s.cfg.StopGrace()) }()
+		}
+	}
+}
+
+// followLogs serves GET /v1/exec/{id}/logs?follow=true: it streams the logs as
+// they grow and returns once the exec has finished, ending jsonl output with a
+// finished event that carries the cursor to resume from: next_offset for one
+// stream, next_seq for both. m reads the logs of the exec's attempt, if it has a
+// retry policy; the logs of the attempts after it follow, each announced by an
+// attempt event, and the cursor is one into the last.
+func followLogs(w http.ResponseWriter, r *http.Request, execDir string, attempt int, m *logMerger, q logQuery) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErr(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	var ew *eventWriter
+	if q.jsonl {
+		ew, _ = newEventWriter(w)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	emit := func(l logLine) error {
+		if !q.filter.keep(l) {
+			return nil
+		}
+		if ew != nil {
+			return ew.Write(l.event())
+		}
+		if _, err := w.Write(l.display()); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte{'\n'})
+		return err
+	}
+	announce := func(ev map[string]any) error {
+		if ew != nil {
+			return ew.Write(ev)
+		}
+		_, err := w.Write(attemptLine(ev))
+		return err
+	}
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		// Read meta first: once it is terminal, the logs are complete, and so
+		// are those of every attempt but the last.
+		meta, err := readMeta(execDir)
+		for ; err == nil && attempt < len(meta.Attempts); attempt++ {
+			if m, err = nextAttempt(execDir, m, attempt, meta, emit, announce); err != nil {
+				return
+			}
+		}
+		if err != nil || isTerminalStatus(meta.Status) {
+			if m.flush(emit) == nil && ew != nil {
+				ev := finishedEvent(meta)
+				if q.stream == "both" {
+					ev["next_seq"] = m.nextSeq
+				} else {
+					ev["next_offset"] = m.followers[0].next
+				}
+				_ = ew.Write(ev)
+			}
+			flusher.Flush()
+			return
+		}
+		if err := m.poll(emit); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// logMerger reads one or both logs of an exec from given offsets and passes the
+// lines on in the order they were written, holding at most one line per log. A
+// poll only reads lines the index already covers, and the index is written in
+// order, so every poll returns a gapless run of seqs.
+type logMerger struct {
+	index     *logIndex
+	followers []*logFollower
+	nextSeq   int64 // seq after the last line passed on
+}
+
+func newLogMerger(execDir string, index *logIndex, starts map[string]int64, nextSeq int64) *logMerger {
+	m := &logMerger{index: index, nextSeq: nextSeq}
+	for _, stream := range []string{"stdout", "stderr"} {
+		if start, ok := starts[stream]; ok {
+			m.followers = append(m.followers, &logFollower{
+				stream: stream,
+				log:    &logReader{path: filepath.Join(execDir, stream+".log")},
+				offset: start,
+				next:   start,
+			})
+		}
+	}
+	return m
+}
+
+// poll passes on the indexed lines written since the last call.
+func (m *logMerger) poll(emit func(logLine) error) error {
+	m.index.refresh()
+	bounds := map[string]int64{}
+	for _, f := range m.followers {
+		bounds[f.stream] = m.index.indexed(f.stream)
+	}
+	return m.read(bounds, false, emit)
+}
+
+// flush passes on the rest of logs that are complete, indexed or not. Lines
+// without a seq go last.
+func (m *logMerger) flush(emit func(logLine) error) error {
+	m.index.refresh()
+	return m.read(nil, true, emit)
+}
+
+// read passes on the lines of each log up to its bound, or to its end if it
+// has none. With final, a last line without a newline is passed on too.
+func (m *logMerger) read(bounds map[string]int64, final bool, emit func(logLine) error) error {
+	for _, f := range m.followers {
+		if err := f.log.refresh(); err != nil {
+			return err
+		}
+	}
+	pending := make([]*logLine, len(m.followers))
+	done := make([]bool, len(m.followers))
+	for {
+		best := -1
+		for i, f := range m.followers {
+			if pending[i] == nil && !done[i] {
+				bound, ok := bounds[f.stream]
+				if !ok {
+					bound = -1
+				}
+				l, ok, err := f.line(bound, final)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					done[i] = true
+					continue
+				}
+				l = m.index.annotate(l)
+				pending[i] = &l
+			}
+			if pending[i] != nil && (best < 0 || m.order(*pending[i]) < m.order(*pending[best])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return nil
+		}
+		l := *pending[best]
+		pending[best] = nil
+		if err := emit(l); err != nil {
+			return err
+		}
+		if l.seq > 0 {
+			m.nextSeq = l.seq + 1
+		}
+	}
+}
+
+// order is where a line goes in the merged output. A line without a seq inside
+// the indexed part of its log, such as a truncation marker, goes before the
+// next indexed line of its log; one past it was never indexed and goes last.
+func (m *logMerger) order(l logLine) int64 {
+	switch {
+	case l.seq > 0:
+		return l.seq
+	case l.next <= m.index.indexed(l.stream):
+		return 0
+	default:
+		return math.MaxInt64
+	}
+}
+
+const (
+	// logChunkBytes is how much of a log is read at a time.
+	logChunkBytes = 64 << 10
+	// maxLogLineBytes caps a line held in memory; longer lines are passed on
+	// in pieces.
+	maxLogLineBytes = 1 << 20
+)
+
+// logFollower reads what is appended to a log, one line at a time.
+type logFollower struct {
+	stream string
+	log    *logReader
+	offset int64  // how far the log has been read
+	next   int64  // offset just past the last line passed on
+	buf    []byte // read past next
+	chunk  []byte
+}
+
+// line returns the next line of the log that ends by bound, or by the end of
+// the log if bound is negative. With final, the bytes before that without a
+// newline make a last line. Dropped output comes as a marker line. ok is false
+// when there is no line (yet).
+func (f *logFollower) line(bound int64, final bool) (logLine, bool, error) {
+	for {
+		if i := bytes.IndexByte(f.buf, '\n'); i >= 0 {
+			return f.take(i, false), true, nil
+		}
+		if len(f.buf) >= maxLogLineBytes {
+			return f.take(len(f.buf), true), true, nil
+		}
+		want := int64(logChunkBytes)
+		if bound >= 0 {
+			want = min(want, bound-f.offset)
+		}
+		if want <= 0 {
+			break
+		}
+		if f.chunk == nil {
+			f.chunk = make([]byte, logChunkBytes)
+		}
+		n, resume, err := f.log.readAt(f.chunk[:want], f.offset)
+		if err != nil {
+			return logLine{}, false, err
+		}
+		if n > 0 {
+			f.buf = append(f.buf, f.chunk[:n]...)
+			f.offset += int64(n)
+			continue
+		}
+		if resume == f.offset {
+			break
+		}
+		// Output was dropped here: the line it cut off comes first.
+		if len(f.buf) > 0 {
+			return f.take(len(f.buf), true), true, nil
+		}
+		l := logLine{stream: f.stream, next: resume, dropped: resume - f.offset}
+		f.offset, f.next = resume, resume
+		return l, true, nil
+	}
+	if final && len(f.buf) > 0 {
+		return f.take(len(f.buf), true), true, nil
+	}
+	return logLine{}, false, nil
+}
+
+// take passes on the first n bytes of buf as a line, and its newline unless cut.
+func (f *logFollower) take(n int, cut bool) logLine {
+	text := f.buf[:n]
+	if !cut {
+		n++
+	}
+	f.buf = f.buf[n:]
+	f.next += int64(n)
+	return logLine{stream: f.stream, text: text, cut: cut, next: f.next}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	maxIdempotencyKey = 255
+)
+
+var (
+	// errIdempotentReplay is returned by initExec with the exec that an earlier
+	// request with the same Idempotency-Key created.
+	errIdempotentReplay = errors.New("exec already created for this Idempotency-Key")
+	// errIdempotencyMismatch is returned by initExec for a key that was first
+	// sent with a different request.
+	errIdempotencyMismatch = errors.New("the Idempotency-Key was already used with a different request")
+)
+
+// idempotencyRecord ties an Idempotency-Key of POST /v1/exec or /v1/exec/run to
+// the exec it created. Records are kept in <data_dir>/idempotency/, named after
+// a hash of the key, for as long as their exec is; a key whose exec has been
+// removed creates a new one.
+type idempotencyRecord struct {
+	Key         string `json:"key"`
+	ExecID      string `json:"exec_id"`
+	RequestHash string `json:"request_hash"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// idempotencyKey reads the Idempotency-Key header of r, answering 400 for one
+// that is too long or not printable ASCII. It is "" if r has none.
+func idempotencyKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := strings.TrimSpace(r.Header.Get(idempotencyHeader))
+	if len(key) > maxIdempotencyKey || strings.ContainsFunc(key, func(c rune) bool { return c < 0x20 || c > 0x7e }) {
+		writeErr(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 printable ASCII characters")
+		return "", false
+	}
+	return key, true
+}
+
+func (s *Service) idempotencyDir() string { return filepath.Join(s.cfg.DataDir, "idempotency") }
+
+func (s *Service) idempotencyPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.idempotencyDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+func requestHash(req execRequest) string {
+	b, _ := json.Marshal(req)
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// replayedExec returns the exec that req's Idempotency-Key already created, if
+// any, with errIdempotentReplay, or errIdempotencyMismatch if the key came with
+// another request. The caller holds s.idempotencyMu.
+func (s *Service) replayedExec(req execRequest) (string, execMeta, error) {
+	b, err := os.ReadFile(s.idempotencyPath(req.idempotencyKey))
+	if err != nil {
+		return "", execMeta{}, nil
+	}
+	var rec idempotencyRecord
+	if err := json.Unmarshal(b, &rec); err != nil || rec.Key != req.idempotencyKey {
+		return "", execMeta{}, nil
+	}
+	execDir := filepath.Join(s.cfg.DataDir, "exec", rec.ExecID)
+	meta, err := readMeta(execDir)
+	if err != nil {
+		// Removed by retention or exec rm: the key is free again.
+		return "", execMeta{}, nil
+	}
+	if rec.RequestHash != requestHash(req) {
+		return "", execMeta{}, errIdempotencyMismatch
+	}
+	return execDir, meta, errIdempotentReplay
+}
+
+// recordIdempotencyKey remembers that req's Idempotency-Key created execID.
+func (s *Service) recordIdempotencyKey(req execRequest, execID string) error {
+	if err := os.MkdirAll(s.idempotencyDir(), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(idempotencyRecord{
+		Key:         req.idempotencyKey,
+		ExecID:      execID,
+		RequestHash: requestHash(req),
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.idempotencyPath(req.idempotencyKey), b)
+}
+
+// pruneIdempotencyKeys removes the records of keys whose exec is gone.
+func (s *Service) pruneIdempotencyKeys() {
+	entries, err := os.ReadDir(s.idempotencyDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		path := filepath.Join(s.idempotencyDir(), e.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var rec idempotencyRecord
+		if json.Unmarshal(b, &rec) == nil && rec.ExecID != "" {
+			if _, err := os.Stat(filepath.Join(s.cfg.DataDir, "exec", rec.ExecID)); err == nil {
+				continue
+			}
+		}
+		_ = os.Remove(path)
+	}
+}
+
+// replayRun answers a replayed POST /v1/exec/run: after a replayed event it
+// streams the exec's logs from the start and its finished event, as the first
+// request did. Unlike the first request, it leaves the exec running when the
+// client goes away.
+func (s *Service) replayRun(ctx context.Context, execDir string, meta execMeta, ew *eventWriter) {
+	if err := ew.Write(map[string]any{
+		"type":              "replayed",
+		"exec_id":           meta.ExecID,
+		"status":            meta.Status,
+		"idempotent_replay": true,
+	}); err != nil {
+		return
+	}
+	done := make(chan execMeta, 1)
+	go func() {
+		ticker := time.NewTicker(followPollInterval)
+		defer ticker.Stop()
+		for {
+			if meta, err := readMeta(execDir); err == nil && isTerminalStatus(meta.Status) {
+				done <- meta
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	s.followRun(ctx, execDir, done, ew, nil)
+}
+
+// attachReplay registers a replayed exec run of req that follows execID, unless
+// execID no longer holds req's Idempotency-Key, see releaseRun. detachReplay
+// ends it.
+func (s *Service) attachReplay(req execRequest, execID string) bool {
+	s.idempotencyMu.Lock()
+	defer s.idempotencyMu.Unlock()
+	if s.idempotencyExec(req.idempotencyKey) != execID {
+		return false
+	}
+	if s.replays == nil {
+		s.replays = map[string]int{}
+	}
+	s.replays[execID]++
+	return true
+}
+
+func (s *Service) detachReplay(execID string) {
+	s.idempotencyMu.Lock()
+	defer s.idempotencyMu.Unlock()
+	if s.replays[execID]--; s.replays[execID] <= 0 {
+		delete(s.replays, execID)
+	}
+}
+
+// releaseRun decides the fate of an exec run whose client went away: it
+// reports false if a replay of the request follows the exec, which then keeps
+// running for it. Otherwise the exec is to be stopped, and its Idempotency-Key
+// is freed so that a retry of the request runs it again.
+func (s *Service) releaseRun(req execRequest, execID string) bool {
+	if req.idempotencyKey == "" {
+		return true
+	}
+	s.idempotencyMu.Lock()
+	defer s.idempotencyMu.Unlock()
+	if s.replays[execID] > 0 {
+		return false
+	}
+	if s.idempotencyExec(req.idempotencyKey) == execID {
+		_ = os.Remove(s.idempotencyPath(req.idempotencyKey))
+	}
+	return true
+}
+
+// idempotencyExec returns the exec recorded for key, or "".
+func (s *Service) idempotencyExec(key string) string {
+	b, err := os.ReadFile(s.idempotencyPath(key))
+	if err != nil {
+		return ""
+	}
+	var rec idempotencyRecord
+	if err := json.Unmarshal(b, &rec); err != nil || rec.Key != key {
+		return ""
+	}
+	return rec.ExecID
+}
@@ -0,0 +1,177 @@
+package service_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+// postWithKey sends body to path with an Idempotency-Key header.
+func postWithKey(t *testing.T, h http.Handler, path, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "http://example"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestExecStartIdempotencyKey(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	body := `{"cmd":"echo once"}`
+	var first, again map[string]any
+	rr := postWithKey(t, h, "/v1/exec", "start-1", body)
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &first) != nil {
+		t.Fatalf("first POST => %d: %s", rr.Code, rr.Body)
+	}
+	execID, _ := first["exec_id"].(string)
+	waitFinished(t, h, execID, 5*time.Second)
+
+	rr = postWithKey(t, h, "/v1/exec", "start-1", body)
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &again) != nil {
+		t.Fatalf("replayed POST => %d: %s", rr.Code, rr.Body)
+	}
+	if again["exec_id"] != execID || again["idempotent_replay"] != true || again["status"] != "finished" {
+		t.Fatalf("replay = %v, want exec %s as it is now", again, execID)
+	}
+	if page := listExecs(t, h, ""); len(page.Execs) != 1 || page.Execs[0]["idempotency_key"] != "start-1" {
+		t.Fatalf("execs = %v, want only the first, with its key", page.Execs)
+	}
+
+	// The key is tied to the request it came with.
+	if rr := postWithKey(t, h, "/v1/exec", "start-1", `{"cmd":"echo twice"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST with the key and another request => %d, want 422", rr.Code)
+	}
+	if rr := postWithKey(t, h, "/v1/exec", strings.Repeat("k", 256), body); rr.Code != http.StatusBadRequest {
+		t.Fatalf("POST with a 256-byte key => %d, want 400", rr.Code)
+	}
+
+	// Once its exec is removed, the key creates a new one.
+	do(t, h, "DELETE", "/v1/exec/"+execID, nil)
+	again = nil
+	rr = postWithKey(t, h, "/v1/exec", "start-1", body)
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &again) != nil {
+		t.Fatalf("POST after exec rm => %d: %s", rr.Code, rr.Body)
+	}
+	if again["exec_id"] == execID || again["idempotent_replay"] != nil {
+		t.Fatalf("POST after exec rm = %v, want a new exec", again)
+	}
+	waitFinished(t, h, again["exec_id"].(string), 5*time.Second)
+}
+
+func TestExecRunIdempotencyKey(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	body := `{"cmd":"echo one; echo two"}`
+	first := parseJSONLLines(t, postWithKey(t, h, "/v1/exec/run", "run-1", body).Body.Bytes())
+	execID, _ := first[0]["exec_id"].(string)
+
+	// A replay streams the exec's output again instead of running it twice.
+	events := parseJSONLLines(t, postWithKey(t, h, "/v1/exec/run", "run-1", body).Body.Bytes())
+	var lines []string
+	for _, ev := range events {
+		if ev["type"] == "log" {
+			lines = append(lines, ev["line"].(string))
+		}
+	}
+	if events[0]["type"] != "replayed" || events[0]["exec_id"] != execID {
+		t.Fatalf("first event = %v, want a replay of %s", events[0], execID)
+	}
+	if end := events[len(events)-1]; end["type"] != "finished" || end["exit_code"] != float64(0) {
+		t.Fatalf("last event = %v, want the finished event", end)
+	}
+	if strings.Join(lines, ",") != "one,two" {
+		t.Fatalf("replayed lines = %v, want the exec's output", lines)
+	}
+	if page := listExecs(t, h, ""); len(page.Execs) != 1 {
+		t.Fatalf("execs = %v, want one", page.Execs)
+	}
+}
+
+func TestExecRunIdempotencyKeyAfterDroppedConnection(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	srv := httptest.NewServer(service.New(cfg).Handler())
+	defer srv.Close()
+
+	// run posts an exec run with key and returns its first event and the rest
+	// of its event stream; cancel drops the connection.
+	run := func(ctx context.Context, key, body string) (map[string]any, *bufio.Scanner) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, "POST", srv.URL+"/v1/exec/run", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /v1/exec/run: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		events := bufio.NewScanner(resp.Body)
+		if !events.Scan() {
+			t.Fatalf("no event: %v", events.Err())
+		}
+		var ev map[string]any
+		if err := json.Unmarshal(events.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", events.Text(), err)
+		}
+		return ev, events
+	}
+	last := func(events *bufio.Scanner) (map[string]any, []string) {
+		t.Helper()
+		var ev map[string]any
+		var lines []string
+		for events.Scan() {
+			ev = nil
+			if err := json.Unmarshal(events.Bytes(), &ev); err != nil {
+				t.Fatalf("invalid event %q: %v", events.Text(), err)
+			}
+			if ev["type"] == "log" {
+				lines = append(lines, ev["line"].(string))
+			}
+		}
+		return ev, lines
+	}
+	body := `{"cmd":"sleep 0.5; echo done"}`
+
+	// Dropped with nobody following: the exec is stopped and a retry runs the
+	// command again.
+	ctx, drop := context.WithCancel(context.Background())
+	first, _ := run(ctx, "dropped", body)
+	drop()
+	stopped := waitFinished(t, srv.Config.Handler, first["exec_id"].(string), 10*time.Second)
+	if stopped["exit_code"] == float64(0) {
+		t.Fatalf("meta = %v, want the exec stopped with its client", stopped)
+	}
+	retry, events := run(context.Background(), "dropped", body)
+	if retry["type"] != "started" || retry["exec_id"] == first["exec_id"] {
+		t.Fatalf("retry's first event = %v, want a new exec started", retry)
+	}
+	if end, lines := last(events); end["exit_code"] != float64(0) || strings.Join(lines, ",") != "done" {
+		t.Fatalf("retry ended with %v after %v, want the command run to the end", end, lines)
+	}
+
+	// Dropped while a retry follows it: the exec runs on for the retry.
+	ctx, drop = context.WithCancel(context.Background())
+	first, _ = run(ctx, "followed", body)
+	retry, events = run(context.Background(), "followed", body)
+	if retry["type"] != "replayed" || retry["exec_id"] != first["exec_id"] {
+		t.Fatalf("retry's first event = %v, want a replay of %v", retry, first["exec_id"])
+	}
+	drop()
+	if end, lines := last(events); end["exit_code"] != float64(0) || strings.Join(lines, ",") != "done" {
+		t.Fatalf("replay ended with %v after %v, want the exec run to the end", end, lines)
+	}
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"codex-runner/internal/shared/jsonutil"
+)
+
+const (
+	maxExecLabels   = 64
+	maxLabelValue   = 256
+	maxExecName     = 256
+	maxExecNote     = 4096
+	maxExecNotes    = 100
+	annotationsFile = "annotations.json"
+)
+
+// labelKeyRe is what a label key may look like: a letter or digit, then up to
+// 62 letters, digits and ._/- (e.g. "sweep", "team/owner").
+var labelKeyRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+
+func checkLabels(labels map[string]string) error {
+	if len(labels) > maxExecLabels {
+		return fmt.Errorf("labels: at most %d", maxExecLabels)
+	}
+	for k, v := range labels {
+		if !labelKeyRe.MatchString(k) {
+			return fmt.Errorf("labels: invalid key %q: want letters, digits and ._/- (at most 63)", k)
+		}
+		if len(v) > maxLabelValue || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("labels: value of %q must be one line of at most %d bytes", k, maxLabelValue)
+		}
+	}
+	return nil
+}
+
+func checkExecName(name string) error {
+	if len(name) > maxExecName || strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("name must be one line of at most %d bytes", maxExecName)
+	}
+	return nil
+}
+
+// execNote is a free-form note added to an exec after the fact.
+type execNote struct {
+	Text    string `json:"text"`
+	AddedAt string `json:"added_at"`
+	AddedBy string `json:"added_by,omitempty"` // as the client named itself, e.g. user@host
+}
+
+// execAnnotations are the name, labels and notes of an exec once changed by
+// PATCH /v1/exec/{id}. They are kept in annotations.json rather than in
+// meta.json, which the supervisor rewrites from its own copy, and readMeta lays
+// them over the meta.
+type execAnnotations struct {
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Notes  []execNote        `json:"notes,omitempty"`
+}
+
+func annotationsPath(execDir string) string { return filepath.Join(execDir, annotationsFile) }
+
+func readAnnotations(execDir string) (execAnnotations, bool) {
+	b, err := os.ReadFile(annotationsPath(execDir))
+	if err != nil {
+		return execAnnotations{}, false
+	}
+	var a execAnnotations
+	if err := json.Unmarshal(b, &a); err != nil {
+		return execAnnotations{}, false
+	}
+	return a, true
+}
+
+func (a execAnnotations) apply(meta execMeta) execMeta {
+	meta.Name, meta.Labels, meta.Notes = a.Name, a.Labels, a.Notes
+	return meta
+}
+
+// handleExecPatch serves PATCH /v1/exec/{id} with body
+// {"name": "...", "labels": {"k": "v", "gone": null}, "note": "...",
+// "caller": "user@host"}, all optional: it renames the exec, sets labels (null
+// removes one), and appends a note. It works on execs in any status and answers
+// with the exec's meta.
+func (s *Service) handleExecPatch(w http.ResponseWriter, r *http.Request) {
+	execDir := filepath.Join(s.cfg.DataDir, "exec", r.PathValue("id"))
+	var body struct {
+		Name   *string            `json:"name"`
+		Labels map[string]*string `json:"labels"`
+		Note   string             `json:"note"`
+		Caller string             `json:"caller"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	s.annotateMu.Lock()
+	defer s.annotateMu.Unlock()
+	meta, err := readMeta(execDir)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	a := execAnnotations{Name: meta.Name, Labels: maps.Clone(meta.Labels), Notes: meta.Notes}
+	if body.Name != nil {
+		a.Name = strings.TrimSpace(*body.Name)
+		if err := checkExecName(a.Name); err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	for k, v := range body.Labels {
+		if v == nil {
+			delete(a.Labels, k)
+			continue
+		}
+		if a.Labels == nil {
+			a.Labels = map[string]string{}
+		}
+		a.Labels[k] = *v
+	}
+	if err := checkLabels(a.Labels); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if note := strings.TrimSpace(body.Note); note != "" {
+		if len(note) > maxExecNote {
+			writeErr(w, http.StatusBadRequest, fmt.Sprintf("note must be at most %d bytes", maxExecNote))
+			return
+		}
+		if len(a.Notes) >= maxExecNotes {
+			writeErr(w, http.StatusBadRequest, fmt.Sprintf("an exec holds at most %d notes", maxExecNotes))
+			return
+		}
+		a.Notes = append(a.Notes, execNote{
+			Text:    note,
+			AddedAt: time.Now().UTC().Format(time.RFC3339Nano),
+			AddedBy: strings.TrimSpace(body.Caller),
+		})
+	}
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(annotationsPath(execDir), b)
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to write annotations")
+		return
+	}
+	_ = jsonutil.WriteJSON(w, a.apply(meta))
+}
+
+// labelSelector selects execs by their labels: every requirement must hold.
+type labelSelector []labelRequirement
+
+// labelRequirement is one term of a selector: "key=value", "key!=value",
+// "key" (has the label) or "!key" (does not).
+type labelRequirement struct {
+	key, value string
+	op         string // "=", "!=", "exists" or "!exists"
+}
+
+// parseLabelSelector parses a comma-separated list of requirements, e.g.
+// "sweep=lr,!baseline".
+func parseLabelSelector(s string) (labelSelector, error) {
+	var sel labelSelector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			k, v, _ := strings.Cut(term, "!=")
+			req = labelRequirement{key: strings.TrimSpace(k), value: strings.TrimSpace(v), op: "!="}
+		case strings.Contains(term, "="):
+			k, v, _ := strings.Cut(strings.Replace(term, "==", "=", 1), "=")
+			req = labelRequirement{key: strings.TrimSpace(k), value: strings.TrimSpace(v), op: "="}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: strings.TrimSpace(term[1:]), op: "!exists"}
+		default:
+			req = labelRequirement{key: term, op: "exists"}
+		}
+		if !labelKeyRe.MatchString(req.key) {
+			return nil, fmt.Errorf("selector: invalid term %q", term)
+		}
+		sel = append(sel, req)
+	}
+	if s != "" && len(sel) == 0 {
+		return nil, errors.New("selector: no terms")
+	}
+	return sel, nil
+}
+
+func (sel labelSelector) matches(labels map[string]string) bool {
+	for _, req := range sel {
+		v, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || v != req.value {
+				return false
+			}
+		case "!=":
+			if ok && v == req.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// selectExecs returns the execs whose labels match sel, newest first.
+func (s *Service) selectExecs(sel labelSelector) ([]execMeta, error) {
+	metas, err := s.listExecMetas()
+	if err != nil {
+		return nil, err
+	}
+	out := metas[:0]
+	for _, meta := range metas {
+		if sel.matches(meta.Labels) {
+			out = append(out, meta)
+		}
+	}
+	return out, nil
+}
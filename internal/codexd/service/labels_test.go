@@ -0,0 +1,137 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecLabelsAndSelectors(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	lr1 := startExecWithBody(t, h, map[string]any{"cmd": "true", "name": "lr-1", "labels": map[string]string{"sweep": "lr", "lr": "1e-3"}})
+	lr2 := startExecWithBody(t, h, map[string]any{"cmd": "sleep 0.3", "labels": map[string]string{"sweep": "lr", "lr": "1e-4"}})
+	other := startExecWithBody(t, h, map[string]any{"cmd": "true", "labels": map[string]string{"sweep": "bs"}})
+	meta := waitFinished(t, h, lr1, 5*time.Second)
+	if meta["name"] != "lr-1" || meta["labels"].(map[string]any)["lr"] != "1e-3" {
+		t.Fatalf("meta = %v, want the name and labels of the request", meta)
+	}
+
+	for selector, want := range map[string][]string{
+		"sweep=lr":           {lr2, lr1},
+		"sweep=lr,lr!=1e-3":  {lr2},
+		"sweep,!lr":          {other},
+		"sweep==bs":          {other},
+		"team":               nil,
+		"sweep=lr, lr=1e-4 ": {lr2},
+	} {
+		page := listExecs(t, h, "?selector="+url.QueryEscape(selector))
+		var got []string
+		for _, m := range page.Execs {
+			got = append(got, m["exec_id"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("selector %q = %v, want %v", selector, got, want)
+		}
+	}
+
+	// A PATCH while the exec runs outlives the supervisor's last write of its meta.
+	b := do(t, h, "PATCH", "/v1/exec/"+lr2, []byte(`{"name":"lr-2","labels":{"lr":null,"best":"yes"},"note":"loss plateaus at 2k steps","caller":"me@laptop"}`))
+	if err := json.Unmarshal(b, &meta); err != nil {
+		t.Fatalf("invalid patch response: %v", err)
+	}
+	meta = waitFinished(t, h, lr2, 5*time.Second)
+	labels, _ := meta["labels"].(map[string]any)
+	if meta["name"] != "lr-2" || len(labels) != 2 || labels["best"] != "yes" || labels["sweep"] != "lr" {
+		t.Fatalf("meta = %v, want the patched name and labels", meta)
+	}
+	notes, _ := meta["notes"].([]any)
+	if len(notes) != 1 {
+		t.Fatalf("notes = %v, want one", meta["notes"])
+	}
+	if n := notes[0].(map[string]any); n["text"] != "loss plateaus at 2k steps" || n["added_by"] != "me@laptop" || n["added_at"] == nil {
+		t.Fatalf("note = %v", n)
+	}
+
+	for _, tc := range []struct{ method, path, body string }{
+		{"POST", "/v1/exec", `{"cmd":"true","labels":{"-bad":"x"}}`},
+		{"POST", "/v1/exec", `{"cmd":"true","name":"two\nlines"}`},
+		{"PATCH", "/v1/exec/" + lr1, `{"labels":{"a b":"x"}}`},
+		{"GET", "/v1/exec?selector=%3D%3Dx", ""},
+		{"POST", "/v1/exec/cancel", `{"reason":"all of them"}`},
+		{"DELETE", "/v1/exec", ""},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tc.method, "http://example"+tc.path, strings.NewReader(tc.body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s %s %s => %d, want 400", tc.method, tc.path, tc.body, rr.Code)
+		}
+	}
+}
+
+func TestExecCancelAndDeleteBySelector(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	a := startExecWithBody(t, h, map[string]any{"cmd": "sleep 30", "labels": map[string]string{"sweep": "lr"}})
+	b := startExecWithBody(t, h, map[string]any{"cmd": "sleep 30", "labels": map[string]string{"sweep": "lr"}})
+	keep := startExecWithBody(t, h, map[string]any{"cmd": "sleep 30", "labels": map[string]string{"sweep": "bs"}})
+	t.Cleanup(func() { cancelExec(t, h, keep) })
+	waitStarted(t, h, a, 5*time.Second)
+	waitStarted(t, h, b, 5*time.Second)
+
+	// An exec that has not ended cannot be deleted.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("DELETE", "http://example/v1/exec/"+a, nil))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("DELETE of a running exec => %d, want 409", rr.Code)
+	}
+
+	var out struct {
+		Canceled int              `json:"canceled"`
+		Execs    []map[string]any `json:"execs"`
+	}
+	if err := json.Unmarshal(do(t, h, "POST", "/v1/exec/cancel", []byte(`{"selector":"sweep=lr","reason":"bad sweep","grace":"1s"}`)), &out); err != nil {
+		t.Fatalf("invalid cancel response: %v", err)
+	}
+	if out.Canceled != 2 || len(out.Execs) != 2 {
+		t.Fatalf("cancel = %+v, want both lr execs", out)
+	}
+	for _, id := range []string{a, b} {
+		if meta := waitStatus(t, h, id, "canceled", 5*time.Second); meta["cancel_reason"] != "bad sweep" {
+			t.Fatalf("meta = %v, want the reason of the bulk cancel", meta)
+		}
+	}
+	if meta := waitStatus(t, h, keep, "running", time.Second); meta["status"] != "running" {
+		t.Fatalf("exec outside the selector = %v, want it running", meta)
+	}
+
+	do(t, h, "DELETE", "/v1/exec/"+a, nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example/v1/exec/"+a, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("GET of a deleted exec => %d, want 404", rr.Code)
+	}
+
+	var deleted struct {
+		Deleted  int      `json:"deleted"`
+		ExecIDs  []string `json:"exec_ids"`
+		NotEnded int      `json:"not_ended"`
+	}
+	if err := json.Unmarshal(do(t, h, "DELETE", "/v1/exec?selector=sweep", nil), &deleted); err != nil {
+		t.Fatalf("invalid delete response: %v", err)
+	}
+	if deleted.Deleted != 1 || deleted.ExecIDs[0] != b || deleted.NotEnded != 1 {
+		t.Fatalf("delete = %+v, want %s deleted and the running exec kept", deleted, b)
+	}
+}
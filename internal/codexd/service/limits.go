@@ -0,0 +1,81 @@
+package service
+
+import "codex-runner/internal/codexd/config"
+
+// appliedLimits is what actually got enforced for an exec, recorded in meta.json.
+type appliedLimits struct {
+	MemoryMax int64   `json:"memory_max,omitempty"`
+	CPUQuota  float64 `json:"cpu_quota,omitempty"`
+	PidsMax   int     `json:"pids_max,omitempty"`
+	Nice      int     `json:"nice,omitempty"`
+	IONice    string  `json:"ionice,omitempty"`
+	Cgroup    string  `json:"cgroup,omitempty"`
+	// Via maps each applied limit to its mechanism (cgroup, rlimit, setpriority, ioprio).
+	Via map[string]string `json:"via,omitempty"`
+	// Skipped maps each requested but unenforced limit to the reason.
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+func (a *appliedLimits) apply(name, via string) {
+	if a.Via == nil {
+		a.Via = map[string]string{}
+	}
+	a.Via[name] = via
+}
+
+func (a *appliedLimits) skip(name, reason string) {
+	if a.Skipped == nil {
+		a.Skipped = map[string]string{}
+	}
+	a.Skipped[name] = reason
+}
+
+// resolveLimits merges the per-request limits over the daemon defaults.
+func (s *Service) resolveLimits(requested *config.ExecLimits) config.ExecLimits {
+	if requested == nil {
+		return s.cfg.Limits
+	}
+	return requested.Merge(s.cfg.Limits)
+}
+
+// resolveLogLimits merges the per-request log limits over the daemon defaults.
+func (s *Service) resolveLogLimits(requested *config.LogLimits) config.LogLimits {
+	if requested == nil {
+		return s.cfg.LogLimits
+	}
+	return requested.Merge(s.cfg.LogLimits)
+}
+
+// result returns the limits recorded for meta.json, or nil when none were requested.
+func (l *execLimiter) result() *appliedLimits {
+	if l == nil {
+		return nil
+	}
+	return &l.applied
+}
+
+// requestedLimitNames lists the limits that are set, using their meta.json names.
+func requestedLimitNames(l config.ExecLimits) []string {
+	var names []string
+	if l.MemoryMax != "" {
+		names = append(names, "memory_max")
+	}
+	if l.CPUQuota > 0 {
+		names = append(names, "cpu_quota")
+	}
+	if l.PidsMax > 0 {
+		names = append(names, "pids_max")
+	}
+	if l.Nice != 0 {
+		names = append(names, "nice")
+	}
+	if l.IONice != "" {
+		names = append(names, "ionice")
+	}
+	return names
+}
+
+// cgroupLimitsRequested reports whether any limit needs a cgroup to be enforced.
+func cgroupLimitsRequested(l config.ExecLimits) bool {
+	return l.MemoryMax != "" || l.CPUQuota > 0 || l.PidsMax > 0
+}
@@ -0,0 +1,404 @@
+//go:build linux
+
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"codex-runner/internal/codexd/config"
+)
+
+const (
+	cgroupRoot        = "/sys/fs/cgroup"
+	cgroup2SuperMagic = 0x63677270
+	cpuPeriodUsec     = 100000
+	ioprioWhoProcess  = 1
+	ioprioClassShift  = 13
+)
+
+type execLimiter struct {
+	limits    config.ExecLimits
+	memoryMax int64
+	cgroupDir string
+	cgroupFD  *os.File
+	applied   appliedLimits
+	// report reads what limit-exec applied, see configure.
+	report, reportW *os.File
+}
+
+// newExecLimiter prepares the limits for one exec. cgroupParent is the directory
+// resolved by the daemon (see resolveCgroupParent), or cgroupErr why there is none.
+func newExecLimiter(execID string, limits config.ExecLimits, cgroupParent string, cgroupErr error) *execLimiter {
+	if limits.IsZero() {
+		return nil
+	}
+	l := &execLimiter{limits: limits}
+	if limits.MemoryMax != "" {
+		l.memoryMax, _ = config.ParseByteSize(limits.MemoryMax)
+	}
+	if cgroupLimitsRequested(limits) {
+		err := cgroupErr
+		if err == nil {
+			err = l.setupCgroup(execID, cgroupParent)
+		}
+		if err != nil {
+			reason := "cgroup v2 unavailable: " + err.Error()
+			if limits.CPUQuota > 0 {
+				l.applied.skip("cpu_quota", reason)
+			}
+			if limits.PidsMax > 0 {
+				l.applied.skip("pids_max", reason)
+			}
+		}
+	}
+	return l
+}
+
+// setupCgroup creates a child cgroup for the exec and writes the cgroup-backed limits.
+func (l *execLimiter) setupCgroup(execID, parent string) error {
+	if !isCgroup2(parent) {
+		return fmt.Errorf("%q is not a cgroup v2 directory", parent)
+	}
+	dir := filepath.Join(parent, "codexd-"+execID)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+	used := false
+	if l.memoryMax > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(l.memoryMax, 10)); err == nil {
+			l.applied.MemoryMax = l.memoryMax
+			l.applied.apply("memory_max", "cgroup")
+			used = true
+		}
+	}
+	if l.limits.CPUQuota > 0 {
+		quota := int64(l.limits.CPUQuota * cpuPeriodUsec)
+		if err := writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriodUsec)); err == nil {
+			l.applied.CPUQuota = l.limits.CPUQuota
+			l.applied.apply("cpu_quota", "cgroup")
+			used = true
+		} else {
+			l.applied.skip("cpu_quota", "cgroup cpu controller unavailable: "+err.Error())
+		}
+	}
+	if l.limits.PidsMax > 0 {
+		if err := writeCgroupFile(dir, "pids.max", strconv.Itoa(l.limits.PidsMax)); err == nil {
+			l.applied.PidsMax = l.limits.PidsMax
+			l.applied.apply("pids_max", "cgroup")
+			used = true
+		} else {
+			l.applied.skip("pids_max", "cgroup pids controller unavailable: "+err.Error())
+		}
+	}
+	if !used {
+		_ = os.Remove(dir)
+		return nil
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		_ = os.Remove(dir)
+		l.applied = appliedLimits{}
+		return err
+	}
+	l.cgroupDir = dir
+	l.cgroupFD = fd
+	l.applied.Cgroup = dir
+	return nil
+}
+
+// configure places the new process group directly into the exec's cgroup, and
+// starts it under `codexd limit-exec` when it has per-process limits, so that
+// they hold from its first instruction on, see LimitExec.
+func (l *execLimiter) configure(cmd *exec.Cmd) {
+	if l == nil {
+		return
+	}
+	if l.cgroupFD != nil {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(l.cgroupFD.Fd())
+	}
+	var memoryMax int64
+	if l.memoryMax > 0 && l.applied.Via["memory_max"] != "cgroup" {
+		memoryMax = l.memoryMax
+	}
+	if memoryMax == 0 && l.limits.Nice == 0 && l.limits.IONice == "" || cmd.Err != nil {
+		return
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		l.report, l.reportW, err = os.Pipe()
+	}
+	if err != nil {
+		l.skipProcessLimits(memoryMax, "limit-exec unavailable: "+err.Error())
+		return
+	}
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, l.reportW)
+	cmd.Args = append([]string{exe, "limit-exec",
+		strconv.FormatInt(memoryMax, 10), strconv.Itoa(l.limits.Nice), l.limits.IONice, strconv.Itoa(fd),
+		"--", cmd.Path}, cmd.Args...)
+	cmd.Path = exe
+}
+
+// started records the per-process limits that limit-exec applied to the exec's
+// process, which children inherit from it.
+func (l *execLimiter) started(pid int) {
+	if l == nil || l.report == nil {
+		return
+	}
+	l.reportW.Close()
+	applied := map[string]string{}
+	sc := bufio.NewScanner(l.report)
+	for sc.Scan() {
+		if name, result, ok := strings.Cut(sc.Text(), " "); ok {
+			applied[name] = result
+		}
+	}
+	l.report.Close()
+	l.report, l.reportW = nil, nil
+	record := func(name, via string, set func()) {
+		switch result, ok := applied[name]; {
+		case result == "ok":
+			set()
+			l.applied.apply(name, via)
+		case ok:
+			l.applied.skip(name, result)
+		default:
+			l.applied.skip(name, "limit-exec did not apply it")
+		}
+	}
+	if l.memoryMax > 0 && l.applied.Via["memory_max"] != "cgroup" {
+		record("memory_max", "rlimit", func() { l.applied.MemoryMax = l.memoryMax })
+	}
+	if l.limits.Nice != 0 {
+		record("nice", "setpriority", func() { l.applied.Nice = l.limits.Nice })
+	}
+	if l.limits.IONice != "" {
+		record("ionice", "ioprio", func() { l.applied.IONice = l.limits.IONice })
+	}
+}
+
+func (l *execLimiter) skipProcessLimits(memoryMax int64, reason string) {
+	if memoryMax > 0 {
+		l.applied.skip("memory_max", reason)
+	}
+	if l.limits.Nice != 0 {
+		l.applied.skip("nice", reason)
+	}
+	if l.limits.IONice != "" {
+		l.applied.skip("ionice", reason)
+	}
+}
+
+// release removes the exec's cgroup once its processes are gone.
+func (l *execLimiter) release() {
+	if l == nil {
+		return
+	}
+	if l.report != nil {
+		// The last cmd did not start.
+		l.report.Close()
+		l.reportW.Close()
+	}
+	if l.cgroupFD == nil {
+		return
+	}
+	_ = l.cgroupFD.Close()
+	for i := 0; i < 20; i++ {
+		if err := os.Remove(l.cgroupDir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// LimitExec is the body of `codexd limit-exec <memory_max> <nice> <ionice>
+// <report_fd> -- <path> <argv>...`, which the supervisor starts an exec's shell
+// under: it applies the limits to its own process, writes "<name> ok" or
+// "<name> <reason>" for each to report_fd, and execs path in its place, so that
+// the shell and everything it starts run with them. It returns only if the
+// exec fails.
+func LimitExec(args []string) int {
+	// Nice and I/O priority are per thread on Linux; the thread that execs
+	// becomes the process.
+	runtime.LockOSThread()
+	if len(args) < 7 || args[4] != "--" {
+		fmt.Fprintln(os.Stderr, "limit-exec: usage: limit-exec <memory_max> <nice> <ionice> <report_fd> -- <path> <argv>...")
+		return 127
+	}
+	memoryMax, _ := strconv.ParseInt(args[0], 10, 64)
+	nice, _ := strconv.Atoi(args[1])
+	fd, _ := strconv.Atoi(args[3])
+	syscall.CloseOnExec(fd)
+	var report strings.Builder
+	if nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice); err != nil {
+			fmt.Fprintf(&report, "nice setpriority failed: %v\n", err)
+		} else {
+			report.WriteString("nice ok\n")
+		}
+	}
+	if args[2] != "" {
+		class, level, _ := config.ParseIONice(args[2])
+		prio := uintptr(class<<ioprioClassShift | level)
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, prio); errno != 0 {
+			fmt.Fprintf(&report, "ionice ioprio_set failed: %v\n", errno)
+		} else {
+			report.WriteString("ionice ok\n")
+		}
+	}
+	if memoryMax > 0 {
+		// RLIMIT_DATA rather than RLIMIT_AS: CUDA reserves huge PROT_NONE ranges.
+		lim := syscall.Rlimit{Cur: uint64(memoryMax), Max: uint64(memoryMax)}
+		if err := prlimit(0, syscall.RLIMIT_DATA, &lim); err != nil {
+			fmt.Fprintf(&report, "memory_max setrlimit failed: %v\n", err)
+		} else {
+			report.WriteString("memory_max ok\n")
+		}
+	}
+	_, _ = syscall.Write(fd, []byte(report.String()))
+	err := syscall.Exec(args[5], args[6:], os.Environ())
+	fmt.Fprintln(os.Stderr, "limit-exec:", err)
+	return 127
+}
+
+func prlimit(pid int, resource int, lim *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(lim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+var (
+	cgroupAutoOnce   sync.Once
+	cgroupAutoParent string
+	cgroupAutoErr    error
+)
+
+// resolveCgroupParent returns the cgroup v2 directory exec groups are created
+// under. It runs in the daemon, which may move itself into a leaf group.
+func resolveCgroupParent(configured string) (string, error) {
+	if configured != "" {
+		if !isCgroup2(configured) {
+			return "", fmt.Errorf("%s is not a cgroup v2 directory", configured)
+		}
+		return configured, enableCgroupControllers(configured)
+	}
+	cgroupAutoOnce.Do(func() {
+		cgroupAutoParent, cgroupAutoErr = detectCgroupParent()
+	})
+	return cgroupAutoParent, cgroupAutoErr
+}
+
+// detectCgroupParent uses codexd's own cgroup. Controllers can only be delegated to
+// children of a group without member processes, so codexd moves itself into a leaf.
+func detectCgroupParent() (string, error) {
+	if !isCgroup2(cgroupRoot) {
+		return "", fmt.Errorf("%s is not a cgroup v2 mount", cgroupRoot)
+	}
+	own, err := ownCgroupPath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cgroupRoot, own)
+	err = enableCgroupControllers(dir)
+	if errors.Is(err, syscall.EBUSY) {
+		leaf := filepath.Join(dir, "codexd-daemon")
+		if mkErr := os.Mkdir(leaf, 0o755); mkErr != nil && !os.IsExist(mkErr) {
+			return "", mkErr
+		}
+		if mvErr := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); mvErr != nil {
+			return "", mvErr
+		}
+		err = enableCgroupControllers(dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func ownCgroupPath() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if path, ok := strings.CutPrefix(sc.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("no cgroup v2 entry in /proc/self/cgroup")
+}
+
+// enableCgroupControllers delegates the memory, cpu and pids controllers to children of dir.
+func enableCgroupControllers(dir string) error {
+	b, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := strings.Fields(string(b))
+	var lastErr error
+	enabled := 0
+	for _, name := range []string{"memory", "cpu", "pids"} {
+		if !containsString(available, name) {
+			continue
+		}
+		if err := writeCgroupFile(dir, "cgroup.subtree_control", "+"+name); err != nil {
+			lastErr = err
+			continue
+		}
+		enabled++
+	}
+	if enabled == 0 && lastErr != nil {
+		return lastErr
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func isCgroup2(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+func containsString(list []string, v string) bool {
+	for _, it := range list {
+		if it == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"codex-runner/internal/codexd/config"
+)
+
+type execLimiter struct {
+	applied appliedLimits
+}
+
+func newExecLimiter(execID string, limits config.ExecLimits, cgroupParent string, cgroupErr error) *execLimiter {
+	if limits.IsZero() {
+		return nil
+	}
+	l := &execLimiter{}
+	for _, name := range requestedLimitNames(limits) {
+		l.applied.skip(name, "not supported on "+runtime.GOOS)
+	}
+	return l
+}
+
+func (l *execLimiter) configure(cmd *exec.Cmd) {}
+
+func (l *execLimiter) started(pid int) {}
+
+func (l *execLimiter) release() {}
+
+// LimitExec is the body of `codexd limit-exec`, which only Linux uses.
+func LimitExec(args []string) int {
+	fmt.Fprintln(os.Stderr, "limit-exec: not supported on "+runtime.GOOS)
+	return 127
+}
+
+func resolveCgroupParent(configured string) (string, error) {
+	return "", errors.New("cgroups are not supported on " + runtime.GOOS)
+}
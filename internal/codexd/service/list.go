@@ -0,0 +1,245 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"codex-runner/internal/shared/jsonutil"
+)
+
+const (
+	defaultExecListLimit = 50
+	maxExecListLimit     = 500
+)
+
+type execListFilter struct {
+	Statuses   map[string]bool
+	ProjectID  string
+	ScheduleID string
+	Since      *time.Time
+	Until      *time.Time
+	ExitCode   *int
+	Selector   labelSelector
+}
+
+func (f execListFilter) match(meta execMeta) bool {
+	if len(f.Statuses) > 0 && !f.Statuses[meta.Status] {
+		return false
+	}
+	if f.ProjectID != "" && meta.ProjectID != f.ProjectID {
+		return false
+	}
+	if f.ScheduleID != "" && meta.ScheduleID != f.ScheduleID {
+		return false
+	}
+	if !f.Selector.matches(meta.Labels) {
+		return false
+	}
+	if f.Since != nil || f.Until != nil {
+		started, err := time.Parse(time.RFC3339Nano, meta.listTime())
+		if err != nil {
+			return false
+		}
+		if f.Since != nil && started.Before(*f.Since) {
+			return false
+		}
+		if f.Until != nil && started.After(*f.Until) {
+			return false
+		}
+	}
+	if f.ExitCode != nil {
+		if meta.ExitCode == nil || *meta.ExitCode != *f.ExitCode {
+			return false
+		}
+	}
+	return true
+}
+
+// parseExecListFilter reads the exec filters of a list request: status,
+// project_id, schedule_id, selector (see parseLabelSelector), since, until and
+// exit_code.
+func parseExecListFilter(q url.Values) (execListFilter, error) {
+	var filter execListFilter
+	if v := strings.TrimSpace(q.Get("status")); v != "" {
+		filter.Statuses = map[string]bool{}
+		for _, st := range strings.Split(v, ",") {
+			if st = strings.TrimSpace(st); st != "" {
+				filter.Statuses[st] = true
+			}
+		}
+	}
+	filter.ProjectID = strings.TrimSpace(q.Get("project_id"))
+	filter.ScheduleID = strings.TrimSpace(q.Get("schedule_id"))
+	sel, err := parseLabelSelector(q.Get("selector"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Selector = sel
+	since, err := parseRFC3339(q.Get("since"))
+	if err != nil {
+		return filter, errors.New("since must be RFC3339")
+	}
+	filter.Since = since
+	until, err := parseRFC3339(q.Get("until"))
+	if err != nil {
+		return filter, errors.New("until must be RFC3339")
+	}
+	filter.Until = until
+	if v := strings.TrimSpace(q.Get("exit_code")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, errors.New("exit_code must be an integer")
+		}
+		filter.ExitCode = &n
+	}
+	return filter, nil
+}
+
+func (s *Service) handleExecList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseExecListFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := defaultExecListLimit
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, "limit must be > 0")
+			return
+		}
+		limit = n
+	}
+	if limit > maxExecListLimit {
+		limit = maxExecListLimit
+	}
+	var after *execCursor
+	if v := strings.TrimSpace(q.Get("cursor")); v != "" {
+		c, err := decodeExecCursor(v)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		after = &c
+	}
+
+	metas, err := s.listExecMetas()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list execs")
+		return
+	}
+	page := make([]execMeta, 0, limit)
+	nextCursor := ""
+	for _, meta := range metas {
+		if after != nil && !after.before(meta) {
+			continue
+		}
+		if !filter.match(meta) {
+			continue
+		}
+		if len(page) == limit {
+			last := page[len(page)-1]
+			nextCursor = encodeExecCursor(execCursor{StartedAt: last.listTime(), ExecID: last.ExecID})
+			break
+		}
+		if meta.Status == statusQueued {
+			meta.QueuePosition = s.queue.position(meta.ExecID)
+		}
+		page = append(page, meta)
+	}
+	out := map[string]any{
+		"execs": page,
+	}
+	if nextCursor != "" {
+		out["next_cursor"] = nextCursor
+	}
+	_ = jsonutil.WriteJSON(w, out)
+}
+
+// listExecMetas returns the metadata of every exec under data_dir, newest first.
+func (s *Service) listExecMetas() ([]execMeta, error) {
+	execRoot := filepath.Join(s.cfg.DataDir, "exec")
+	entries, err := os.ReadDir(execRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	metas := make([]execMeta, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		meta, err := readMeta(filepath.Join(execRoot, e.Name()))
+		if err != nil {
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return execNewer(metas[i].listTime(), metas[i].ExecID, metas[j].listTime(), metas[j].ExecID)
+	})
+	return metas, nil
+}
+
+// listTime is the time an exec is listed and filtered by: started_at, or
+// queued_at or waiting_since while it has not started yet.
+func (m execMeta) listTime() string {
+	switch {
+	case m.StartedAt != "":
+		return m.StartedAt
+	case m.QueuedAt != "":
+		return m.QueuedAt
+	}
+	return m.WaitingSince
+}
+
+// execNewer orders execs newest first by started_at, breaking ties by exec_id.
+func execNewer(aStarted, aID, bStarted, bID string) bool {
+	at, aErr := time.Parse(time.RFC3339Nano, aStarted)
+	bt, bErr := time.Parse(time.RFC3339Nano, bStarted)
+	if aErr == nil && bErr == nil && !at.Equal(bt) {
+		return at.After(bt)
+	}
+	if (aErr == nil) != (bErr == nil) {
+		return aErr == nil
+	}
+	return aID > bID
+}
+
+// execCursor marks the last exec of a page; the next page starts right after it.
+type execCursor struct {
+	StartedAt string
+	ExecID    string
+}
+
+// before reports whether the cursor position sorts ahead of meta.
+func (c execCursor) before(meta execMeta) bool {
+	return execNewer(c.StartedAt, c.ExecID, meta.listTime(), meta.ExecID)
+}
+
+func encodeExecCursor(c execCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.StartedAt + "|" + c.ExecID))
+}
+
+func decodeExecCursor(v string) (execCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return execCursor{}, err
+	}
+	startedAt, execID, ok := strings.Cut(string(b), "|")
+	if !ok || execID == "" {
+		return execCursor{}, errors.New("malformed cursor")
+	}
+	return execCursor{StartedAt: startedAt, ExecID: execID}, nil
+}
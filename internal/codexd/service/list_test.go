@@ -0,0 +1,73 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecListFiltersAndPaginates(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	svc := service.New(cfg)
+	h := svc.Handler()
+
+	okID := startExec(t, h, `echo ok`)
+	failID := startExec(t, h, `exit 3`)
+	_ = waitFinished(t, h, okID, 5*time.Second)
+	_ = waitFinished(t, h, failID, 5*time.Second)
+	runningID := startExec(t, h, `sleep 30`)
+	t.Cleanup(func() { cancelExec(t, h, runningID) })
+
+	page := listExecs(t, h, "?exit_code=3")
+	if len(page.Execs) != 1 || page.Execs[0]["exec_id"] != failID {
+		t.Fatalf("exit_code filter = %#v, want only %s", page.Execs, failID)
+	}
+	page = listExecs(t, h, "?status=running")
+	if len(page.Execs) != 1 || page.Execs[0]["exec_id"] != runningID {
+		t.Fatalf("status filter = %#v, want only %s", page.Execs, runningID)
+	}
+
+	seen := map[string]bool{}
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		q := "?limit=1"
+		if cursor != "" {
+			q += "&cursor=" + cursor
+		}
+		page = listExecs(t, h, q)
+		for _, m := range page.Execs {
+			id, _ := m["exec_id"].(string)
+			if seen[id] {
+				t.Fatalf("exec %s returned twice", id)
+			}
+			seen[id] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(seen) != 3 || !seen[okID] || !seen[failID] || !seen[runningID] {
+		t.Fatalf("paginated ids = %v, want all three execs", seen)
+	}
+}
+
+type execListPage struct {
+	Execs      []map[string]any `json:"execs"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+func listExecs(t *testing.T, h http.Handler, query string) execListPage {
+	t.Helper()
+	var page execListPage
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec"+query, nil), &page); err != nil {
+		t.Fatalf("invalid list response: %v", err)
+	}
+	return page
+}
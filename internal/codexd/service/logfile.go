@@ -0,0 +1,492 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"codex-runner/internal/codexd/config"
+)
+
+// A log is stored as its head, the file at its path, followed by the segments
+// of its tail, "<path>.<offset>", each named after the offset of its first
+// byte in the log. Offsets are those of the log as written: once a capped log
+// drops old tail segments, the output between the head and the first segment
+// left is gone, but every offset after it stays valid.
+
+// logFileLimits are config.LogLimits resolved for one log.
+type logFileLimits struct {
+	head   int64 // size of the head file once the log is rotated
+	tail   int64 // bytes of tail kept; 0 keeps all
+	rotate int64 // size of a tail segment; 0 keeps the log in one file
+	whole  bool  // rotate between writes only, so no write is split
+}
+
+func newLogFileLimits(l config.LogLimits) logFileLimits {
+	maxBytes, head, rotate, err := l.Sizes()
+	if err != nil || rotate == 0 {
+		return logFileLimits{}
+	}
+	if maxBytes == 0 {
+		return logFileLimits{head: rotate, rotate: rotate}
+	}
+	return logFileLimits{head: head, tail: maxBytes - head, rotate: rotate}
+}
+
+// logWriter appends to a log, rotating and trimming its tail as the limits say.
+type logWriter struct {
+	path   string
+	limits logFileLimits
+	f      *os.File // the head or the last segment
+	fStart int64    // offset of f's first byte
+	size   int64    // bytes written to the log
+	segs   []int64  // offsets of the tail segments on disk, oldest first
+}
+
+func openLogWriter(path string, limits logFileLimits) (*logWriter, error) {
+	w := &logWriter{path: path, limits: limits}
+	segs, err := listLogSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	name := path
+	if n := len(segs); n > 1 {
+		last := segs[n-1]
+		name, w.fStart, w.size = last.path, last.start, last.start+last.size
+		for _, s := range segs[1:] {
+			w.segs = append(w.segs, s.start)
+		}
+	} else if n == 1 {
+		w.size = segs[0].size
+	}
+	if w.f, err = os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if w.limits.rotate > 0 {
+			room := w.limits.rotate
+			if len(w.segs) == 0 {
+				room = w.limits.head
+			}
+			if w.size-w.fStart >= room {
+				if err := w.rotate(); err != nil {
+					return written, err
+				}
+				room = w.limits.rotate
+			}
+			if !w.limits.whole {
+				chunk = p[:min(int64(len(p)), room-(w.size-w.fStart))]
+			}
+		}
+		n, err := w.f.Write(chunk)
+		w.size += int64(n)
+		written += n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	w.trim()
+	return written, nil
+}
+
+// room returns how much more fits in the file being written, or a negative
+// number if the next write starts a new segment.
+func (w *logWriter) room() int64 {
+	if w.limits.rotate == 0 {
+		return math.MaxInt64
+	}
+	limit := w.limits.rotate
+	if len(w.segs) == 0 {
+		limit = w.limits.head
+	}
+	return limit - (w.size - w.fStart)
+}
+
+// rotate starts a new tail segment at the current end of the log.
+func (w *logWriter) rotate() error {
+	f, err := os.OpenFile(logSegmentPath(w.path, w.size), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_ = w.f.Sync()
+	w.f.Close()
+	w.f, w.fStart = f, w.size
+	w.segs = append(w.segs, w.size)
+	return nil
+}
+
+// trim removes the oldest tail segments while the tail is over its limit. A
+// segment that cannot be removed yet is tried again next time.
+func (w *logWriter) trim() {
+	for w.limits.tail > 0 && len(w.segs) > 1 && w.size-w.segs[0] > w.limits.tail {
+		if !w.remove(0) {
+			return
+		}
+	}
+}
+
+// remove removes tail segment i, unless it is the one being written.
+func (w *logWriter) remove(i int) bool {
+	if i >= len(w.segs)-1 {
+		return false
+	}
+	if err := os.Remove(logSegmentPath(w.path, w.segs[i])); err != nil && !os.IsNotExist(err) {
+		return false
+	}
+	w.segs = append(w.segs[:i], w.segs[i+1:]...)
+	return true
+}
+
+// dropped returns how many bytes of the log were dropped between its head and
+// its tail. Only trim removes segments of a log.
+func (w *logWriter) dropped() int64 {
+	if len(w.segs) == 0 {
+		return 0
+	}
+	return w.segs[0] - w.limits.head
+}
+
+func (w *logWriter) Close() error {
+	_ = w.f.Sync()
+	return w.f.Close()
+}
+
+// logSegment is one file of a log.
+type logSegment struct {
+	path    string
+	start   int64 // offset of its first byte in the log
+	size    int64 // uncompressed
+	zipped  bool  // the file is gzipped (see logzip.go)
+	members []logZipMember
+}
+
+func (s logSegment) end() int64 { return s.start + s.size }
+
+// zipSize returns the size of a compressed segment's file.
+func (s logSegment) zipSize() int64 {
+	if len(s.members) == 0 {
+		return 0
+	}
+	last := s.members[len(s.members)-1]
+	return last.off + last.size
+}
+
+func logSegmentPath(path string, start int64) string {
+	return path + "." + strconv.FormatInt(start, 10)
+}
+
+// listLogSegments returns the files of the log at path in offset order, or none
+// if the log does not exist. A file being compressed is read as it was until
+// it has been replaced.
+func listLogSegments(path string) ([]logSegment, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	base := filepath.Base(path)
+	byStart := map[int64]logSegment{}
+	for _, e := range entries {
+		name, zipped := strings.CutSuffix(e.Name(), ".gz")
+		var start int64
+		if name != base {
+			suffix, ok := strings.CutPrefix(name, base+".")
+			if !ok {
+				continue
+			}
+			if start, err = strconv.ParseInt(suffix, 10, 64); err != nil || start <= 0 {
+				continue
+			}
+		}
+		if prev, ok := byStart[start]; ok && !prev.zipped {
+			continue
+		}
+		seg := logSegment{path: filepath.Join(filepath.Dir(path), e.Name()), start: start, zipped: zipped}
+		if zipped {
+			if seg.members, err = readLogZipMembers(seg.path); err != nil {
+				// Removed since the directory was read.
+				continue
+			}
+			last := seg.members[len(seg.members)-1]
+			seg.size = last.start + last.n
+		} else {
+			info, err := e.Info()
+			if err != nil {
+				// Trimmed or compressed since the directory was read.
+				continue
+			}
+			seg.size = info.Size()
+		}
+		byStart[start] = seg
+	}
+	segs := make([]logSegment, 0, len(byStart))
+	for _, seg := range byStart {
+		segs = append(segs, seg)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].start < segs[j].start })
+	return segs, nil
+}
+
+// logReader reads a log by offset across its segments. refresh picks up what
+// the supervisor has written, rotated and trimmed since the last call.
+type logReader struct {
+	path string
+	segs []logSegment
+	// member is the last gzip member read, decompressed.
+	member struct {
+		path  string
+		start int64
+		data  []byte
+	}
+}
+
+func openLogReader(path string) (*logReader, error) {
+	r := &logReader{path: path}
+	return r, r.refresh()
+}
+
+func (r *logReader) refresh() error {
+	segs, err := listLogSegments(r.path)
+	if err != nil {
+		return err
+	}
+	r.segs = segs
+	return nil
+}
+
+// end returns the offset just past the last byte of the log.
+func (r *logReader) end() int64 {
+	if len(r.segs) == 0 {
+		return 0
+	}
+	return r.segs[len(r.segs)-1].end()
+}
+
+// readAt reads from the log at off into p. If off has been dropped, it reads
+// nothing and returns where the log resumes; at the end of the log, it reads
+// nothing and returns off.
+func (r *logReader) readAt(p []byte, off int64) (int, int64, error) {
+	for i := 0; i < 2; i++ {
+		s, ok := r.segmentAt(off)
+		if !ok {
+			for _, s := range r.segs {
+				if s.start > off {
+					return 0, s.start, nil
+				}
+			}
+			return 0, off, nil
+		}
+		n, err := r.readSegment(s, p, off)
+		if os.IsNotExist(err) && i == 0 {
+			// Trimmed since the last refresh.
+			if err := r.refresh(); err != nil {
+				return 0, off, err
+			}
+			continue
+		}
+		return n, off, err
+	}
+	return 0, off, nil
+}
+
+// readSegment reads from segment s at log offset off into p, up to the end of
+// s. A segment compressed since the last refresh is read from its .gz file.
+func (r *logReader) readSegment(s logSegment, p []byte, off int64) (int, error) {
+	p = p[:min(int64(len(p)), s.end()-off)]
+	if !s.zipped {
+		f, err := os.Open(s.path)
+		if os.IsNotExist(err) {
+			members, zerr := readLogZipMembers(s.path + ".gz")
+			if zerr != nil {
+				return 0, err
+			}
+			s.path, s.zipped, s.members = s.path+".gz", true, members
+			return r.readSegment(s, p, off)
+		}
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		n, err := f.ReadAt(p, off-s.start)
+		if err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n) - s.start
+		i := sort.Search(len(s.members), func(i int) bool { return s.members[i].start+s.members[i].n > pos })
+		if i == len(s.members) {
+			break
+		}
+		m := s.members[i]
+		if r.member.path != s.path || r.member.start != m.start || r.member.data == nil {
+			data, err := m.read(s.path)
+			if err != nil {
+				return n, err
+			}
+			r.member.path, r.member.start, r.member.data = s.path, m.start, data
+		}
+		n += copy(p[n:], r.member.data[pos-m.start:])
+	}
+	return n, nil
+}
+
+// segmentAt returns the segment holding the byte at off.
+func (r *logReader) segmentAt(off int64) (logSegment, bool) {
+	i := sort.Search(len(r.segs), func(i int) bool { return r.segs[i].end() > off })
+	if i < len(r.segs) && r.segs[i].start <= off {
+		return r.segs[i], true
+	}
+	return logSegment{}, false
+}
+
+// gapBefore reports whether output was dropped just before segment i.
+func (r *logReader) gapBefore(i int) bool {
+	if i == 0 {
+		return r.segs[0].start > 0
+	}
+	return r.segs[i-1].end() < r.segs[i].start
+}
+
+// lineEnd returns the last offset in (floor, off] where a line ends: after a
+// newline, or at either edge of dropped output. An off inside dropped output
+// moves past it first, as dropped bytes take no room in a read. It returns
+// floor if no line ends there.
+func (r *logReader) lineEnd(floor, off int64) (int64, error) {
+	for i, s := range r.segs {
+		if off < s.start {
+			if r.gapBefore(i) {
+				off = s.start
+			}
+			break
+		}
+		if off <= s.end() {
+			break
+		}
+	}
+	buf := make([]byte, logChunkBytes)
+	pos := off
+	for i := len(r.segs) - 1; i >= 0 && pos > floor; i-- {
+		s := r.segs[i]
+		if s.start >= pos {
+			continue
+		}
+		if s.end() < pos || (s.end() == pos && i+1 < len(r.segs) && r.gapBefore(i+1)) {
+			return pos, nil
+		}
+		lo := max(s.start, floor)
+		for pos > lo {
+			n := min(int64(len(buf)), pos-lo)
+			if _, err := r.readSegment(s, buf[:n], pos-n); err != nil {
+				return floor, err
+			}
+			if j := bytes.LastIndexByte(buf[:n], '\n'); j >= 0 {
+				return pos - n + int64(j) + 1, nil
+			}
+			pos -= n
+		}
+	}
+	return floor, nil
+}
+
+// tailBytesStart returns where the last n bytes of the log start, moved forward
+// to the next line start so a reader from there sees whole lines. If that is
+// in dropped output, it is where the output was dropped, so the read starts
+// with the truncation marker.
+func (r *logReader) tailBytesStart(n int64) (int64, error) {
+	end := r.end()
+	off := end - n
+	if off <= 0 {
+		return 0, nil
+	}
+	for i, s := range r.segs {
+		if off < s.start || (off == s.start && r.gapBefore(i)) {
+			if off == s.start {
+				return off, nil
+			}
+			if i == 0 {
+				return 0, nil
+			}
+			return r.segs[i-1].end(), nil
+		}
+		if off < s.end() {
+			break
+		}
+	}
+	buf := make([]byte, logChunkBytes)
+	pos := off - 1
+	for pos < end {
+		n, resume, err := r.readAt(buf, pos)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			if resume == pos {
+				break
+			}
+			// The line runs into dropped output.
+			return pos, nil
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		pos += int64(n)
+	}
+	return end, nil
+}
+
+// tailLinesStart returns where the last n lines of the log start. Dropped
+// output ends a line; if it is where the last n lines start, so does the read,
+// with the truncation marker.
+func (r *logReader) tailLinesStart(n int) (int64, error) {
+	end := r.end()
+	if n <= 0 {
+		return end, nil
+	}
+	// Scan backwards counting newlines; a trailing newline does not start a line.
+	newlines := 0
+	buf := make([]byte, logChunkBytes)
+	for i := len(r.segs) - 1; i >= 0; i-- {
+		s := r.segs[i]
+		pos := s.end()
+		for pos > s.start {
+			size := min(int64(len(buf)), pos-s.start)
+			pos -= size
+			if _, err := r.readSegment(s, buf[:size], pos); err != nil {
+				return 0, err
+			}
+			for j := size - 1; j >= 0; j-- {
+				if buf[j] != '\n' || pos+j == end-1 {
+					continue
+				}
+				newlines++
+				if newlines == n {
+					return pos + j + 1, nil
+				}
+			}
+		}
+		if i > 0 && r.gapBefore(i) {
+			newlines++
+			if newlines == n {
+				return r.segs[i-1].end(), nil
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,507 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// logIndexPath is the index the supervisor keeps of an exec's output. Each line
+// of it is "<seq> <stream> <offset> <unix_ms>": output line number seq, counted
+// across stdout and stderr, ended at offset in <stream>.log and was captured at
+// unix_ms. Entries are in seq order, and each is written after its line.
+func logIndexPath(execDir string) string {
+	return filepath.Join(execDir, "logs.idx")
+}
+
+// logIndex reads the output index of an exec. The index is rotated along with
+// the logs and loses the entries of the lines they drop (see outputCapture), so
+// seqs may be missing between its head and its tail. It can grow to millions
+// of entries, so it is never held in memory: refresh reads only what has been
+// appended, to keep track of where it ends, and entries are looked up by
+// searching the file.
+type logIndex struct {
+	path    string
+	r       *logReader // nil until the index file is found
+	found   bool       // the index file exists
+	base    int64      // where the index ended when it was found
+	read    int64      // offset in the index read up to
+	head    *logIndexScanner
+	last    map[string]int64 // end of the last indexed line, per stream, once known
+	lastSeq int64
+	cursors map[string]*logIndexScanner // where annotate is, per stream
+}
+
+type logIndexEntry struct {
+	seq    int64
+	stream string
+	end    int64
+	at     time.Time
+}
+
+// openLogIndex opens the index of an exec. Execs from before codexd kept one
+// have an empty index.
+func openLogIndex(execDir string) *logIndex {
+	x := &logIndex{path: logIndexPath(execDir), last: map[string]int64{}, cursors: map[string]*logIndexScanner{}}
+	x.refresh()
+	return x
+}
+
+// refresh reads the entries appended since the last call. The first time the
+// index is found, it only looks up its last entry.
+func (x *logIndex) refresh() {
+	if x.r == nil {
+		r, err := openLogReader(x.path)
+		if err != nil || len(r.segs) == 0 {
+			return
+		}
+		end, err := r.lineEnd(r.segs[0].start, r.end())
+		if err != nil {
+			return
+		}
+		x.r, x.found, x.base, x.read = r, true, end, end
+		x.head = x.scanner(end)
+		_ = x.back(end, func(e logIndexEntry, _ int64) bool {
+			x.lastSeq = e.seq
+			return false
+		})
+		return
+	}
+	if err := x.r.refresh(); err != nil {
+		return
+	}
+	for _, c := range x.cursors {
+		c.r.segs = x.r.segs
+	}
+	for {
+		e, _, ok, err := x.head.next()
+		if err != nil || !ok {
+			break
+		}
+		x.last[e.stream], x.lastSeq = e.end, e.seq
+	}
+	x.read = x.head.pos
+}
+
+// indexed returns how much of a log is indexed, or -1 if the exec has no index,
+// so its logs are as complete as they will get.
+func (x *logIndex) indexed(stream string) int64 {
+	if !x.found {
+		return -1
+	}
+	end, ok := x.last[stream]
+	if !ok && x.r != nil {
+		// Not indexed since the index was found: look back from there.
+		end, _ = x.endBefore(x.base, stream)
+		x.last[stream] = end
+	}
+	return end
+}
+
+// expect marks the index of an exec whose supervisor may not have created it
+// yet, so that its output is read only as it gets indexed.
+func (x *logIndex) expect() { x.found = true }
+
+// empty reports whether nothing has been indexed (yet).
+func (x *logIndex) empty() bool { return x.lastSeq == 0 }
+
+// nextSeq is the seq the next indexed line will get.
+func (x *logIndex) nextSeq() int64 { return x.lastSeq + 1 }
+
+// annotate adds the seq and capture time of l from the index, if it has them.
+// The lines of a stream are annotated in order: its cursor only moves forward.
+func (x *logIndex) annotate(l logLine) logLine {
+	if l.dropped > 0 || l.next > x.indexed(l.stream) {
+		return l
+	}
+	c := x.cursors[l.stream]
+	if c == nil {
+		pos, err := x.search(func(e logIndexEntry) (bool, bool) { return e.end < l.next, e.stream == l.stream })
+		if err != nil {
+			return l
+		}
+		c = x.scanner(pos)
+		// Its own reader, not to take turns with the others decompressing.
+		c.r = &logReader{path: x.path, segs: x.r.segs}
+		x.cursors[l.stream] = c
+	}
+	for !c.held || c.entry.stream != l.stream || c.entry.end < l.next {
+		e, _, ok, err := c.next()
+		if err != nil || !ok {
+			return l
+		}
+		c.entry, c.held = e, true
+	}
+	if c.entry.end == l.next {
+		l.seq, l.at = c.entry.seq, c.entry.at
+	}
+	return l
+}
+
+// tailLinesStart returns where the entries of the last n lines start.
+func (x *logIndex) tailLinesStart(n int) (int64, error) {
+	p := x.read
+	err := x.back(x.read, func(_ logIndexEntry, start int64) bool {
+		p = start
+		n--
+		return n > 0
+	})
+	return p, err
+}
+
+// tailBytesStart returns where the entries of the last lines that add up to at
+// most n bytes start. The size of a line is known once the entry of the line
+// before it in its log is.
+func (x *logIndex) tailBytesStart(n int64) (int64, error) {
+	var (
+		starts, ends, sizes []int64 // of the entries passed, last first; sizes -1 until known
+		// pending is the entry passed last of each log, whose size is not known.
+		pending = map[string]int{}
+		known   int64
+	)
+	err := x.back(x.read, func(e logIndexEntry, start int64) bool {
+		if i, ok := pending[e.stream]; ok {
+			sizes[i] = ends[i] - e.end
+			known += sizes[i]
+			delete(pending, e.stream)
+		}
+		if known > n {
+			// Nothing from here on fits; only the sizes pending are needed.
+			return len(pending) > 0
+		}
+		pending[e.stream] = len(sizes)
+		starts, ends, sizes = append(starts, start), append(ends, e.end), append(sizes, -1)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, i := range pending {
+		// The first line indexed of its log.
+		sizes[i] = ends[i]
+	}
+	p, used := x.read, int64(0)
+	for i, size := range sizes {
+		if used+size > n {
+			break
+		}
+		used += size
+		p = starts[i]
+	}
+	return p, nil
+}
+
+// endBefore returns where the last line of stream indexed before offset pos of
+// the index ends, or 0 if there is none.
+func (x *logIndex) endBefore(pos int64, stream string) (int64, error) {
+	var end int64
+	err := x.back(pos, func(e logIndexEntry, _ int64) bool {
+		if e.stream != stream {
+			return true
+		}
+		end = e.end
+		return false
+	})
+	return end, err
+}
+
+// search returns where the first entry at or after a target starts, or x.read
+// if there is none up to there. before tells the entries before the target
+// from the others, which must come after them; it skips entries it has no
+// answer for (ok false).
+func (x *logIndex) search(before func(e logIndexEntry) (before, ok bool)) (int64, error) {
+	if x.r == nil || len(x.r.segs) == 0 {
+		return x.read, nil
+	}
+	lo, hi := x.r.segs[0].start, x.read
+	s := x.scanner(lo)
+	for hi-lo > logChunkBytes {
+		mid := lo + (hi-lo)/2
+		pos, err := x.entryStart(mid)
+		if err != nil {
+			return 0, err
+		}
+		s.reset(pos)
+		decided := false
+		for !decided && s.pos < hi {
+			e, _, ok, err := s.next()
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				break
+			}
+			if b, ok := before(e); ok {
+				if b {
+					lo = s.pos
+				} else {
+					hi = mid
+				}
+				decided = true
+			}
+		}
+		if !decided {
+			// The target is before mid, or past hi.
+			hi = mid
+		}
+	}
+	s.reset(lo)
+	for s.pos < x.read {
+		e, start, ok, err := s.next()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		if b, ok := before(e); ok && !b {
+			return start, nil
+		}
+	}
+	return x.read, nil
+}
+
+// entryStart returns where the first entry at or after offset off of the
+// index starts.
+func (x *logIndex) entryStart(off int64) (int64, error) {
+	var buf [128]byte
+	pos := off - 1
+	for {
+		n, resume, err := x.r.readAt(buf[:], pos)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			// Dropped entries end where the index resumes.
+			return max(resume, off), nil
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		pos += int64(n)
+	}
+}
+
+// entryAt returns the entry at offset pos of the index, if it is whole and
+// before x.read.
+func (x *logIndex) entryAt(pos int64) (logIndexEntry, bool) {
+	if x.r == nil || pos >= x.read {
+		return logIndexEntry{}, false
+	}
+	e, _, ok, err := x.scanner(pos).next()
+	return e, ok && err == nil
+}
+
+// back passes the entries that end by offset to of the index, which is where
+// one ends, to fn in reverse order along with where each starts, until fn
+// returns false.
+func (x *logIndex) back(to int64, fn func(e logIndexEntry, start int64) bool) error {
+	if x.r == nil {
+		return nil
+	}
+	for i := len(x.r.segs) - 1; i >= 0; i-- {
+		s := x.r.segs[i]
+		if s.start >= to {
+			continue
+		}
+		// data runs from pos to the end of the next entry to pass on, and
+		// segments hold whole entries.
+		pos := min(s.end(), to)
+		var data []byte
+		for pos > s.start {
+			n := min(logChunkBytes, pos-s.start)
+			pos -= n
+			chunk := make([]byte, n+int64(len(data)))
+			if _, err := x.r.readSegment(s, chunk[:n], pos); err != nil {
+				if os.IsNotExist(err) {
+					// Trimmed: there is nothing before it either.
+					return nil
+				}
+				return err
+			}
+			copy(chunk[n:], data)
+			data = chunk
+			for len(data) > 0 {
+				j := bytes.LastIndexByte(data[:len(data)-1], '\n')
+				if j < 0 && pos > s.start {
+					break
+				}
+				if e, ok := parseLogIndexEntry(data[j+1 : len(data)-1]); ok && !fn(e, pos+int64(j)+1) {
+					return nil
+				}
+				data = data[:j+1]
+			}
+		}
+	}
+	return nil
+}
+
+func (x *logIndex) scanner(pos int64) *logIndexScanner {
+	return &logIndexScanner{r: x.r, pos: pos}
+}
+
+// logIndexScanner reads the entries of an index in order.
+type logIndexScanner struct {
+	r     *logReader
+	pos   int64  // where the next entry starts
+	buf   []byte // read from pos
+	chunk []byte
+	// entry is the last entry read, if held, for annotate.
+	entry logIndexEntry
+	held  bool
+}
+
+func (s *logIndexScanner) reset(pos int64) {
+	s.pos, s.buf, s.held = pos, s.buf[:0], false
+}
+
+// next returns the next whole entry and where it starts. ok is false at the
+// end of the index, or at an entry still being written.
+func (s *logIndexScanner) next() (e logIndexEntry, start int64, ok bool, err error) {
+	for {
+		if i := bytes.IndexByte(s.buf, '\n'); i >= 0 {
+			line, start := s.buf[:i], s.pos
+			s.buf = s.buf[i+1:]
+			s.pos += int64(i) + 1
+			if e, ok := parseLogIndexEntry(line); ok {
+				return e, start, true, nil
+			}
+			continue
+		}
+		if s.chunk == nil {
+			s.chunk = make([]byte, logChunkBytes)
+		}
+		n, resume, err := s.r.readAt(s.chunk, s.pos+int64(len(s.buf)))
+		if err != nil {
+			return logIndexEntry{}, 0, false, err
+		}
+		if n == 0 {
+			if resume == s.pos+int64(len(s.buf)) {
+				return logIndexEntry{}, 0, false, nil
+			}
+			// Entries were dropped; segments hold whole entries.
+			s.pos, s.buf = resume, s.buf[:0]
+			continue
+		}
+		s.buf = append(s.buf, s.chunk[:n]...)
+	}
+}
+
+// parseLogIndexEntry parses a line of the index, without allocating for the
+// usual streams.
+func parseLogIndexEntry(line []byte) (logIndexEntry, bool) {
+	var fields [4][]byte
+	for i := range fields {
+		f, rest, _ := bytes.Cut(line, []byte{' '})
+		if len(f) == 0 || (i < 3) != (len(rest) > 0) {
+			return logIndexEntry{}, false
+		}
+		fields[i], line = f, rest
+	}
+	seq, ok1 := parseIndexNumber(fields[0])
+	end, ok2 := parseIndexNumber(fields[2])
+	ms, ok3 := parseIndexNumber(fields[3])
+	if !ok1 || !ok2 || !ok3 {
+		return logIndexEntry{}, false
+	}
+	e := logIndexEntry{seq: seq, end: end, at: time.UnixMilli(ms).UTC()}
+	switch string(fields[1]) {
+	case "stdout":
+		e.stream = "stdout"
+	case "stderr":
+		e.stream = "stderr"
+	default:
+		e.stream = string(fields[1])
+	}
+	return e, true
+}
+
+func parseIndexNumber(b []byte) (int64, bool) {
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' || n > (1<<62)/10 {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, len(b) > 0
+}
+
+// logLine is one line of exec output, or a marker for output that was dropped.
+type logLine struct {
+	stream  string
+	text    []byte
+	cut     bool      // the line does not end in a newline
+	next    int64     // offset just past the line in its log
+	seq     int64     // 0 if the line is not indexed
+	at      time.Time // capture time; zero if the line is not indexed
+	dropped int64     // bytes dropped before next, for a marker
+}
+
+// display is the line as text output shows it.
+func (l logLine) display() []byte {
+	if l.dropped > 0 {
+		return []byte(fmt.Sprintf("[codexd: %d bytes of %s dropped]", l.dropped, l.stream))
+	}
+	return l.text
+}
+
+// event is the jsonl log event of the line, or the truncated event of a marker.
+// next_offset is where a reader of the line's stream resumes to get the lines
+// after it.
+func (l logLine) event() map[string]any {
+	if l.dropped > 0 {
+		return map[string]any{
+			"type":          "truncated",
+			"stream":        l.stream,
+			"dropped_bytes": l.dropped,
+			"next_offset":   l.next,
+		}
+	}
+	ev := map[string]any{
+		"type":        "log",
+		"stream":      l.stream,
+		"line":        strings.TrimSuffix(string(l.text), "\r"),
+		"next_offset": l.next,
+	}
+	if l.seq > 0 {
+		ev["seq"] = l.seq
+		ev["ts"] = l.at.Format(time.RFC3339Nano)
+	}
+	return ev
+}
+
+// logTimeFilter keeps the log lines written between since and until. A line's
+// time is the one it carries itself (see extractLogTime), else the time codexd
+// captured it; lines with neither are kept.
+type logTimeFilter struct {
+	since, until *time.Time
+}
+
+func (f logTimeFilter) active() bool { return f.since != nil || f.until != nil }
+
+func (f logTimeFilter) keep(l logLine) bool {
+	if !f.active() || l.dropped > 0 {
+		return true
+	}
+	if len(l.text) == 0 {
+		return false
+	}
+	ts, ok := extractLogTime(l.text)
+	if !ok {
+		if l.at.IsZero() {
+			return true
+		}
+		ts = l.at
+	}
+	if f.since != nil && ts.Before(*f.since) {
+		return false
+	}
+	if f.until != nil && ts.After(*f.until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"codex-runner/internal/shared/jsonutil"
+)
+
+// logQuery is a parsed GET /v1/exec/{id}/logs request.
+type logQuery struct {
+	stream    string // stdout, stderr or both
+	tailBytes int64
+	tailSet   bool // tail was given, so it wins over a time filter
+	tailLines int
+	full      bool
+	offset    int64 // -1 if not given
+	seq       int64 // -1 if not given
+	limit     int64
+	filter    logTimeFilter
+	jsonl     bool
+	follow    bool
+	attempt   int // of an exec with a retry policy; 0 for the last
+}
+
+func parseLogQuery(r *http.Request) (logQuery, error) {
+	v := r.URL.Query()
+	q := logQuery{
+		stream:    v.Get("stream"),
+		tailBytes: 2000,
+		full:      v.Get("full") == "true",
+		offset:    -1,
+		seq:       -1,
+		jsonl:     v.Get("format") == "jsonl",
+		follow:    v.Get("follow") == "true",
+	}
+	if q.stream == "" {
+		q.stream = "stdout"
+	}
+	if q.stream != "stdout" && q.stream != "stderr" && q.stream != "both" {
+		return q, errors.New("stream must be stdout, stderr or both")
+	}
+	if s := v.Get("tail"); s != "" {
+		q.tailSet = true
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+			q.tailBytes = n
+		}
+	}
+	if s := v.Get("tail_lines"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, errors.New("tail_lines must be >= 0")
+		}
+		q.tailLines = n
+	}
+	var err error
+	if q.filter.since, err = parseRFC3339(v.Get("since")); err != nil {
+		return q, errors.New("since must be RFC3339")
+	}
+	if q.filter.until, err = parseRFC3339(v.Get("until")); err != nil {
+		return q, errors.New("until must be RFC3339")
+	}
+	if s := v.Get("offset"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return q, errors.New("offset must be >= 0")
+		}
+		if q.stream == "both" {
+			return q, errors.New("offset needs stream stdout or stderr; use seq with stream=both")
+		}
+		q.offset = n
+	}
+	if s := v.Get("seq"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return q, errors.New("seq must be >= 0")
+		}
+		if q.stream != "both" {
+			return q, errors.New("seq needs stream=both; use offset with one stream")
+		}
+		q.seq = n
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return q, errors.New("limit must be > 0")
+		}
+		q.limit = n
+	}
+	if s := v.Get("attempt"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return q, errors.New("attempt must be > 0")
+		}
+		if q.follow {
+			return q, errors.New("attempt cannot be combined with follow, which reads the current attempt")
+		}
+		q.attempt = n
+	}
+	return q, nil
+}
+
+// errLogLimit stops a read that has used up its limit.
+var errLogLimit = errors.New("log read limit reached")
+
+func (s *Service) handleExecLogs(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	if _, err := os.Stat(execDir); err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	q, err := parseLogQuery(r)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Read meta before the logs: once it is terminal, they are complete.
+	meta, err := readMeta(execDir)
+	finished := err != nil || isTerminalStatus(meta.Status)
+	dir := logDir(execDir, meta)
+	if q.attempt > 0 {
+		if q.attempt > len(meta.Attempts) {
+			writeErr(w, http.StatusNotFound, "attempt not found")
+			return
+		}
+		dir = attemptDir(execDir, q.attempt)
+		finished = finished || q.attempt < len(meta.Attempts)
+	}
+	index := openLogIndex(dir)
+	if !finished {
+		index.expect()
+	}
+	starts, nextSeq, err := logStarts(dir, index, q)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read logs")
+		return
+	}
+	m := newLogMerger(dir, index, starts, nextSeq)
+	if q.follow {
+		followLogs(w, r, execDir, len(meta.Attempts), m, q)
+		return
+	}
+	if q.stream == "both" {
+		writeMergedLogs(w, m, q, finished)
+		return
+	}
+
+	// An incremental reader of a running exec gets whole lines only, so the
+	// line being written is not split across two reads.
+	f := m.followers[0]
+	if err := f.log.refresh(); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read logs")
+		return
+	}
+	stop, err := logStop(f.log, f.offset, q.limit, q.offset >= 0 && !finished)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read logs")
+		return
+	}
+	w.Header().Set("X-Next-Offset", strconv.FormatInt(stop, 10))
+	if q.jsonl {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	raw := !q.jsonl && !q.filter.active()
+	_ = m.read(map[string]int64{q.stream: stop}, true, func(l logLine) error {
+		if raw && l.cut && l.next == stop {
+			// Plain output is the log as written, up to where the read stops.
+			_, err := w.Write(l.text)
+			return err
+		}
+		writeLogLine(w, l, q)
+		return nil
+	})
+	if q.jsonl {
+		_ = jsonutil.WriteJSON(w, map[string]any{
+			"type":        "end",
+			"stream":      q.stream,
+			"next_offset": stop,
+		})
+	}
+}
+
+// logStop returns where a read of one log from start stops: at its end, or
+// after the last whole line in limit bytes. A limit that holds no whole line
+// cuts the first one. With wholeLines, a last line without a newline is left
+// out.
+func logStop(log *logReader, start, limit int64, wholeLines bool) (int64, error) {
+	end := log.end()
+	stop, cut := end, false
+	if limit > 0 && start+limit < end {
+		stop, cut = start+limit, true
+	}
+	if !cut && !wholeLines {
+		return max(stop, start), nil
+	}
+	lineEnd, err := log.lineEnd(start, stop)
+	if err != nil || lineEnd > start || !cut {
+		return lineEnd, err
+	}
+	return stop, nil
+}
+
+// writeMergedLogs answers a stream=both read: the lines of both logs in the
+// order they were written, and next_seq to resume from. The index says up
+// front where a limited read stops.
+func writeMergedLogs(w http.ResponseWriter, m *logMerger, q logQuery, finished bool) {
+	var bounds map[string]int64
+	if !finished {
+		bounds = map[string]int64{}
+		for _, f := range m.followers {
+			bounds[f.stream] = m.index.indexed(f.stream)
+		}
+	}
+	nextSeq := m.index.nextSeq()
+	indexed := !m.index.empty()
+	if q.limit > 0 && indexed {
+		limited := map[string]int64{}
+		for _, f := range m.followers {
+			limited[f.stream] = f.offset
+		}
+		pos, err := m.index.search(func(e logIndexEntry) (bool, bool) { return e.seq < m.nextSeq, true })
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "failed to read logs")
+			return
+		}
+		var used int64
+		for s := m.index.scanner(pos); s.pos < m.index.read; {
+			e, _, ok, err := s.next()
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "failed to read logs")
+				return
+			}
+			if !ok {
+				break
+			}
+			size := e.end - limited[e.stream]
+			if used > 0 && used+size > q.limit {
+				bounds, finished, nextSeq = limited, false, e.seq
+				break
+			}
+			used += size
+			limited[e.stream] = e.end
+		}
+	}
+	w.Header().Set("X-Next-Seq", strconv.FormatInt(nextSeq, 10))
+	if q.jsonl {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	var used int64
+	_ = m.read(bounds, finished, func(l logLine) error {
+		if q.limit > 0 && !indexed {
+			// Logs from before the index: limit by what is read.
+			n := int64(len(l.text)) + 1
+			if used > 0 && used+n > q.limit {
+				return errLogLimit
+			}
+			used += n
+		}
+		writeLogLine(w, l, q)
+		return nil
+	})
+	if q.jsonl {
+		_ = jsonutil.WriteJSON(w, map[string]any{
+			"type":     "end",
+			"stream":   "both",
+			"next_seq": nextSeq,
+		})
+	}
+}
+
+func writeLogLine(w http.ResponseWriter, l logLine, q logQuery) {
+	if !q.filter.keep(l) {
+		return
+	}
+	if q.jsonl {
+		_ = jsonutil.WriteJSON(w, l.event())
+		return
+	}
+	_, _ = w.Write(l.display())
+	_, _ = w.Write([]byte{'\n'})
+}
+
+// logStarts returns where a read starts in each log it covers, and for
+// stream=both the seq of its first line.
+func logStarts(execDir string, index *logIndex, q logQuery) (map[string]int64, int64, error) {
+	if q.stream != "both" || index.empty() {
+		streams := []string{q.stream}
+		if q.stream == "both" {
+			// Nothing indexed (yet): each log on its own.
+			streams = []string{"stdout", "stderr"}
+		}
+		starts := map[string]int64{}
+		for _, stream := range streams {
+			start, err := logStart(filepath.Join(execDir, stream+".log"), q)
+			if err != nil {
+				return nil, 0, err
+			}
+			starts[stream] = start
+		}
+		return starts, index.nextSeq(), nil
+	}
+
+	// Pick the first line in seq order, then where each log has its next line.
+	var p int64 // where its entry is in the index
+	var err error
+	switch {
+	case q.seq >= 0:
+		p, err = index.search(func(e logIndexEntry) (bool, bool) { return e.seq < q.seq, true })
+	case q.full:
+		p = 0
+	case q.tailLines > 0:
+		p, err = index.tailLinesStart(q.tailLines)
+	case !q.tailSet && q.filter.active():
+		p = 0
+	default:
+		p, err = index.tailBytesStart(q.tailBytes)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	starts := map[string]int64{}
+	for _, stream := range []string{"stdout", "stderr"} {
+		if starts[stream], err = index.endBefore(p, stream); err != nil {
+			return nil, 0, err
+		}
+	}
+	nextSeq := index.nextSeq()
+	if e, ok := index.entryAt(p); ok {
+		nextSeq = e.seq
+	}
+	return starts, nextSeq, nil
+}
+
+// logStart returns where a read of one log starts: at the requested offset, or
+// on the line boundary the tail options select.
+func logStart(path string, q logQuery) (int64, error) {
+	switch {
+	case q.offset >= 0:
+		return q.offset, nil
+	case q.full, q.seq >= 0, q.tailLines == 0 && !q.tailSet && q.filter.active():
+		return 0, nil
+	}
+	log, err := openLogReader(path)
+	if err != nil {
+		return 0, err
+	}
+	if q.tailLines > 0 {
+		return log.tailLinesStart(q.tailLines)
+	}
+	return log.tailBytesStart(q.tailBytes)
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Once an exec has finished (and compress_logs_after has passed), each file of
+// its logs and index, head or tail segment, is replaced by "<file>.gz". The
+// gzip stream is a series of members, each holding up to logZipMemberBytes of
+// the file, and each member header carries an extra field "CX" with the
+// member's size and the size of the data in it. A read at an offset then
+// decompresses one member instead of the file up to it, and the file is still
+// plain gzip to any other tool.
+
+const (
+	logZipMemberBytes = 1 << 20
+	// logCompressInterval is how often codexd looks for logs due for compression.
+	logCompressInterval = time.Minute
+)
+
+// logZipMember is one gzip member of a compressed log file.
+type logZipMember struct {
+	off, size int64 // where the member is in the .gz file
+	start, n  int64 // where its data is in the uncompressed file
+}
+
+// logFileSize is what a log takes on disk before and after compression.
+type logFileSize struct {
+	Size           int64 `json:"size"`
+	CompressedSize int64 `json:"compressed_size"`
+}
+
+// compressLogsLoop gzips the logs of finished execs once they are due, checking
+// every logCompressInterval and whenever an exec finishes.
+func (s *Service) compressLogsLoop(after time.Duration) {
+	ticker := time.NewTicker(logCompressInterval)
+	defer ticker.Stop()
+	for {
+		s.compressDueLogs(after)
+		select {
+		case <-s.compressKick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// execFinished tells the compression loop, if any, that an exec has finished.
+func (s *Service) execFinished() {
+	select {
+	case s.compressKick <- struct{}{}:
+	default:
+	}
+}
+
+// compressDueLogs compresses the logs of the execs that finished at least after
+// ago and have not been compressed yet.
+func (s *Service) compressDueLogs(after time.Duration) {
+	execRoot := filepath.Join(s.cfg.DataDir, "exec")
+	entries, err := os.ReadDir(execRoot)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		execDir := filepath.Join(execRoot, e.Name())
+		meta, err := readMeta(execDir)
+		if err != nil || !isTerminalStatus(meta.Status) || meta.LogSizes != nil {
+			continue
+		}
+		finished, err := time.Parse(time.RFC3339Nano, meta.FinishedAt)
+		if err != nil || time.Since(finished) < after {
+			continue
+		}
+		// The sizes are those of the last attempt of an exec with a retry
+		// policy, whose logs are the ones read by default.
+		for n := 1; n < len(meta.Attempts); n++ {
+			_, _ = compressExecLogs(attemptDir(execDir, n))
+		}
+		sizes, err := compressExecLogs(logDir(execDir, meta))
+		if err != nil {
+			// Removed by retention meanwhile, or tried again next time.
+			continue
+		}
+		// Nothing else writes the meta of a finished exec.
+		meta.LogSizes = sizes
+		_ = writeMeta(execDir, meta)
+	}
+}
+
+// compressExecLogs gzips the files of an exec's logs and index that are not yet
+// compressed, and returns the sizes of each before and after.
+func compressExecLogs(execDir string) (map[string]logFileSize, error) {
+	sizes := map[string]logFileSize{}
+	for name, path := range map[string]string{
+		"stdout": filepath.Join(execDir, "stdout.log"),
+		"stderr": filepath.Join(execDir, "stderr.log"),
+		"index":  logIndexPath(execDir),
+	} {
+		segs, err := listLogSegments(path)
+		if err != nil {
+			return nil, err
+		}
+		var total logFileSize
+		for _, seg := range segs {
+			if !seg.zipped {
+				if seg, err = gzipLogFile(seg.path); err != nil {
+					return nil, err
+				}
+			}
+			total.Size += seg.size
+			total.CompressedSize += seg.zipSize()
+		}
+		if len(segs) > 0 {
+			sizes[name] = total
+		}
+	}
+	return sizes, nil
+}
+
+// gzipLogFile replaces the file at path with path.gz and returns its segment.
+func gzipLogFile(path string) (logSegment, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return logSegment{}, err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return logSegment{}, err
+	}
+	defer os.Remove(tmp)
+	defer dst.Close()
+
+	seg := logSegment{path: path + ".gz", zipped: true}
+	buf := make([]byte, logZipMemberBytes)
+	var member bytes.Buffer
+	for {
+		n, err := io.ReadFull(src, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return logSegment{}, err
+		}
+		if n == 0 && len(seg.members) > 0 {
+			break
+		}
+		member.Reset()
+		zw := gzip.NewWriter(&member)
+		zw.Header.Extra = []byte{'C', 'X', 8, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		if _, err := zw.Write(buf[:n]); err != nil {
+			return logSegment{}, err
+		}
+		if err := zw.Close(); err != nil {
+			return logSegment{}, err
+		}
+		// The extra field follows the 10-byte header and its 2-byte length.
+		b := member.Bytes()
+		binary.LittleEndian.PutUint32(b[16:], uint32(len(b)))
+		binary.LittleEndian.PutUint32(b[20:], uint32(n))
+		if _, err := dst.Write(b); err != nil {
+			return logSegment{}, err
+		}
+		seg.members = append(seg.members, logZipMember{
+			off: seg.zipSize(), size: int64(len(b)), start: seg.size, n: int64(n),
+		})
+		seg.size += int64(n)
+		if n < len(buf) {
+			break
+		}
+	}
+	if err := dst.Sync(); err != nil {
+		return logSegment{}, err
+	}
+	if err := dst.Close(); err != nil {
+		return logSegment{}, err
+	}
+	if err := os.Rename(tmp, seg.path); err != nil {
+		return logSegment{}, err
+	}
+	if err := os.Remove(path); err != nil {
+		return logSegment{}, err
+	}
+	return seg, nil
+}
+
+// readLogZipMembers returns the members of the compressed file at path. A file
+// gzipped by something else is taken as one member, sized by decompressing it.
+func readLogZipMembers(path string) ([]logZipMember, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var members []logZipMember
+	var start int64
+	hdr := make([]byte, 24)
+	for off := int64(0); off < st.Size(); {
+		if _, err := f.ReadAt(hdr, off); err != nil ||
+			hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[3]&4 == 0 ||
+			binary.LittleEndian.Uint16(hdr[10:]) < 12 || string(hdr[12:16]) != "CX\x08\x00" {
+			return readForeignZip(f, st.Size())
+		}
+		m := logZipMember{
+			off:   off,
+			size:  int64(binary.LittleEndian.Uint32(hdr[16:])),
+			start: start,
+			n:     int64(binary.LittleEndian.Uint32(hdr[20:])),
+		}
+		if m.size < int64(len(hdr)) {
+			return readForeignZip(f, st.Size())
+		}
+		members = append(members, m)
+		off += m.size
+		start += m.n
+	}
+	if len(members) == 0 {
+		return readForeignZip(f, st.Size())
+	}
+	return members, nil
+}
+
+func readForeignZip(f *os.File, size int64) ([]logZipMember, error) {
+	zr, err := gzip.NewReader(io.NewSectionReader(f, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(io.Discard, zr)
+	if err != nil {
+		return nil, err
+	}
+	return []logZipMember{{size: size, n: n}}, nil
+}
+
+// read returns the decompressed data of the member.
+func (m logZipMember) read(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(io.NewSectionReader(f, m.off, m.size))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, m.n)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -8,13 +8,61 @@ import (
 	"syscall"
 )
 
+// execSignals are the signals POST /v1/exec/{id}/signal sends, by name without
+// "SIG". Stopping and continuing go through pause and resume instead.
+var execSignals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"ALRM":  syscall.SIGALRM,
+	"TERM":  syscall.SIGTERM,
+	"WINCH": syscall.SIGWINCH,
+}
+
 func configureCmd(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 }
 
+// configureSupervisorCmd puts the supervisor in its own session, so it survives
+// codexd exiting and signals aimed at codexd's process group.
+func configureSupervisorCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
 func gracefulStopExec(pid int) error {
 	// Negative pid targets the process group on unix.
-	return syscall.Kill(-pid, syscall.SIGTERM)
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	// A paused exec only gets the SIGTERM once it is continued.
+	_ = syscall.Kill(-pid, syscall.SIGCONT)
+	return nil
+}
+
+func checkExecSignal(name string) error {
+	if _, ok := execSignals[name]; !ok {
+		return errUnknownSignal
+	}
+	return nil
+}
+
+// signalExec sends the signal named (see execSignals) to the exec's process group.
+func signalExec(pid int, name string) error {
+	if err := checkExecSignal(name); err != nil {
+		return err
+	}
+	return syscall.Kill(-pid, execSignals[name])
+}
+
+func pauseExec(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGSTOP)
+}
+
+func resumeExec(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGCONT)
 }
 
 func forceStopExec(pid int) error {
@@ -11,6 +11,10 @@ import (
 
 func configureCmd(cmd *exec.Cmd) {}
 
+func configureSupervisorCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
 func gracefulStopExec(pid int) error {
 	p, err := os.FindProcess(pid)
 	if err != nil {
@@ -22,6 +26,14 @@ func gracefulStopExec(pid int) error {
 	return p.Kill()
 }
 
+func checkExecSignal(name string) error { return errSignalsUnsupported }
+
+func signalExec(pid int, name string) error { return errSignalsUnsupported }
+
+func pauseExec(pid int) error { return errSignalsUnsupported }
+
+func resumeExec(pid int) error { return errSignalsUnsupported }
+
 func forceStopExec(pid int) error {
 	p, err := os.FindProcess(pid)
 	if err != nil {
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"codex-runner/internal/shared/attach"
+)
+
+// ptyWriteTimeout drops an attached client that stops reading, so a stuck
+// terminal cannot stall the exec's output.
+const ptyWriteTimeout = 5 * time.Second
+
+// The default size of a PTY until a client attaches and sends its own.
+const (
+	ptyDefaultRows = 24
+	ptyDefaultCols = 80
+)
+
+// maxSocketPath is the longest unix socket path that binds everywhere: sun_path
+// holds 104 bytes on macOS and 108 on Linux, the trailing NUL included.
+const maxSocketPath = 103
+
+// ptySocketPath is where the supervisor of a PTY exec accepts attach
+// connections: pty.sock in the exec dir or, when that path is too long for a
+// unix socket, a socket named by a hash of the exec dir under $XDG_RUNTIME_DIR
+// or the temp dir.
+func ptySocketPath(execDir string) string {
+	path := filepath.Join(execDir, "pty.sock")
+	if len(path) <= maxSocketPath {
+		return path
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(execDir))
+	return filepath.Join(dir, "codexd-pty-"+hex.EncodeToString(sum[:8])+".sock")
+}
+
+// ptyHub runs in the supervisor. It copies PTY output to stdout.log and to every
+// attached client, and client input and resizes to the PTY.
+type ptyHub struct {
+	execDir string
+	master  *os.File
+	log     io.Writer
+	ln      net.Listener
+	drained chan struct{} // closed when the PTY has no more output
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func startPTYHub(execDir string, master *os.File, log io.Writer) *ptyHub {
+	h := &ptyHub{
+		execDir: execDir,
+		master:  master,
+		log:     log,
+		drained: make(chan struct{}),
+		conns:   map[net.Conn]struct{}{},
+	}
+	ln, err := net.Listen("unix", ptySocketPath(execDir))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "supervise: attach disabled:", err)
+	} else {
+		_ = os.Chmod(ptySocketPath(execDir), 0o600)
+		h.ln = ln
+		go h.accept()
+	}
+	go h.pump()
+	return h
+}
+
+func (h *ptyHub) pump() {
+	defer close(h.drained)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := h.master.Read(buf)
+		if n > 0 {
+			_, _ = h.log.Write(buf[:n])
+			h.broadcast(attach.FrameData, buf[:n])
+		}
+		if err != nil {
+			// EIO once the exec and everything it started have closed the terminal.
+			return
+		}
+	}
+}
+
+func (h *ptyHub) accept() {
+	for {
+		conn, err := h.ln.Accept()
+		if err != nil {
+			return
+		}
+		h.mu.Lock()
+		h.conns[conn] = struct{}{}
+		h.mu.Unlock()
+		go h.serve(conn)
+	}
+}
+
+func (h *ptyHub) serve(conn net.Conn) {
+	defer h.drop(conn)
+	for {
+		typ, payload, err := attach.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case attach.FrameData:
+			if _, err := h.master.Write(payload); err != nil {
+				return
+			}
+		case attach.FrameResize:
+			if rows, cols, err := attach.ParseResize(payload); err == nil {
+				_ = setPTYSize(h.master, rows, cols)
+			}
+		}
+	}
+}
+
+func (h *ptyHub) broadcast(typ byte, payload []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.conns {
+		_ = conn.SetWriteDeadline(time.Now().Add(ptyWriteTimeout))
+		if err := attach.WriteFrame(conn, typ, payload); err != nil {
+			conn.Close()
+			delete(h.conns, conn)
+		}
+	}
+}
+
+func (h *ptyHub) drop(conn net.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conn.Close()
+	delete(h.conns, conn)
+}
+
+// finish waits for the remaining output, tells attached clients the exit code,
+// and closes the PTY. Background children that keep the terminal open get
+// execStopGrace before their output is cut off.
+func (h *ptyHub) finish(exitCode int) {
+	select {
+	case <-h.drained:
+	case <-time.After(execStopGrace):
+	}
+	if h.ln != nil {
+		h.ln.Close()
+		_ = os.Remove(ptySocketPath(h.execDir))
+	}
+	h.broadcast(attach.FrameExit, []byte(strconv.Itoa(exitCode)))
+	h.mu.Lock()
+	for conn := range h.conns {
+		conn.Close()
+		delete(h.conns, conn)
+	}
+	h.mu.Unlock()
+	h.master.Close()
+}
+
+// handleExecAttach upgrades the connection and relays attach frames between the
+// client and the exec's supervisor. Closing the connection detaches; the exec
+// keeps running.
+func (s *Service) handleExecAttach(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	meta, err := readMeta(execDir)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	switch {
+	case !meta.PTY:
+		writeErr(w, http.StatusConflict, "exec was not started with a pty")
+		return
+	case isTerminalStatus(meta.Status):
+		writeErr(w, http.StatusConflict, "exec has already finished")
+		return
+	case !strings.EqualFold(r.Header.Get("Upgrade"), attach.Upgrade):
+		writeErr(w, http.StatusBadRequest, "attach needs Upgrade: "+attach.Upgrade)
+		return
+	}
+	sock, err := net.Dial("unix", ptySocketPath(execDir))
+	if err != nil {
+		writeErr(w, http.StatusConflict, "exec is not attachable (still queued or starting?)")
+		return
+	}
+	defer sock.Close()
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "attach not supported")
+		return
+	}
+	defer conn.Close()
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: " + attach.Upgrade + "\r\nConnection: Upgrade\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(sock, brw.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, sock)
+		done <- struct{}{}
+	}()
+	<-done
+	conn.Close()
+	sock.Close()
+	<-done
+}
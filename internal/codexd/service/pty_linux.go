@@ -0,0 +1,67 @@
+//go:build linux
+
+package service
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const ptySupported = true
+
+// openPTY allocates a pseudo-terminal pair from /dev/ptmx.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	if err := ptyIoctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ptyIoctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(n), 10), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// setPTYSize sets the terminal size; the foreground process gets SIGWINCH.
+func setPTYSize(master *os.File, rows, cols int) error {
+	ws := struct{ Row, Col, Xpixel, Ypixel uint16 }{Row: uint16(rows), Col: uint16(cols)}
+	return ptyIoctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// configurePTYCmd makes the exec a session leader with the PTY slave (its
+// stdin) as controlling terminal. The session is also its process group.
+func configurePTYCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+}
+
+// ptyIoctl goes through SyscallConn so the file stays in non-blocking mode.
+func ptyIoctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package service
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+const ptySupported = false
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("pty is not supported on " + runtime.GOOS)
+}
+
+func setPTYSize(master *os.File, rows, cols int) error { return nil }
+
+func configurePTYCmd(cmd *exec.Cmd) {}
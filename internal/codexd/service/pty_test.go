@@ -0,0 +1,106 @@
+//go:build linux
+
+package service_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+	"codex-runner/internal/shared/attach"
+)
+
+func TestExecPTYAttach(t *testing.T) {
+	if _, err := os.Stat("/dev/ptmx"); err != nil {
+		t.Skip("no /dev/ptmx")
+	}
+	t.Run("short", func(t *testing.T) { testPTYAttach(t, t.TempDir()) })
+	// The exec dir is too deep for a unix socket in it.
+	t.Run("deep", func(t *testing.T) { testPTYAttach(t, filepath.Join(t.TempDir(), strings.Repeat("d", 100))) })
+}
+
+func testPTYAttach(t *testing.T, dir string) {
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	execID := startExecWithBody(t, h, map[string]any{"cmd": `read line; echo "got $line"; stty size; test -t 1 && echo tty`, "pty": true})
+	waitStarted(t, h, execID, 5*time.Second)
+
+	var conn net.Conn
+	var br *bufio.Reader
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var err error
+		conn, err = net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		req, _ := http.NewRequest("GET", srv.URL+"/v1/exec/"+execID+"/attach", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", attach.Upgrade)
+		if err := req.Write(conn); err != nil {
+			t.Fatalf("write attach request: %v", err)
+		}
+		br = bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatalf("read attach response: %v", err)
+		}
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+		// The supervisor may not be listening yet.
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("attach => %s", resp.Status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	defer conn.Close()
+
+	if err := attach.WriteFrame(conn, attach.FrameResize, attach.ResizePayload(40, 120)); err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	if err := attach.WriteFrame(conn, attach.FrameData, []byte("hello\r")); err != nil {
+		t.Fatalf("input: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var out strings.Builder
+	exitCode := ""
+	for exitCode == "" {
+		typ, payload, err := attach.ReadFrame(br)
+		if err != nil {
+			t.Fatalf("read frame: %v (output so far %q)", err, out.String())
+		}
+		switch typ {
+		case attach.FrameData:
+			out.Write(payload)
+		case attach.FrameExit:
+			exitCode = string(payload)
+		}
+	}
+	for _, want := range []string{"got hello", "40 120", "tty"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("attach output %q lacks %q", out.String(), want)
+		}
+	}
+	if exitCode != "0" {
+		t.Fatalf("exit frame = %q, want 0", exitCode)
+	}
+	waitFinished(t, h, execID, 5*time.Second)
+	logged, _ := os.ReadFile(filepath.Join(dir, "exec", execID, "stdout.log"))
+	if !strings.Contains(string(logged), "got hello") {
+		t.Fatalf("stdout.log = %q, want the PTY output", logged)
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errQueueCanceled = errors.New("canceled while queued")
+
+// execQueue bounds how many execs run at once and hands out resource slots.
+// Waiting execs are dispatched by priority (higher first), FIFO within the same
+// priority. An exec whose resources are not free holds on to its place: the
+// execs behind it only go first if they take none of the pools it waits for,
+// and leave it a run slot.
+type execQueue struct {
+	mu      sync.Mutex
+	max     int // <= 0 means unlimited
+	running int
+	pools   map[string][]string // pool -> slot names, in config order
+	inUse   map[string][]bool   // pool -> slot index -> reserved
+	waiting []*queueTicket
+}
+
+type queueTicket struct {
+	execID   string
+	priority int
+	demand   map[string]int
+	slots    map[string][]string // reserved on dispatch
+	ready    chan error          // receives nil once dispatched, errQueueCanceled if removed
+}
+
+func newExecQueue(max int, pools map[string][]string) *execQueue {
+	q := &execQueue{max: max, pools: pools, inUse: map[string][]bool{}}
+	for pool, slots := range pools {
+		q.inUse[pool] = make([]bool, len(slots))
+	}
+	return q
+}
+
+// enqueue adds an exec and dispatches it at once if a run slot and its resources
+// are free. queued reports whether the caller has to wait for dispatch.
+func (q *execQueue) enqueue(execID string, priority int, demand map[string]int) (t *queueTicket, queued bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t = &queueTicket{
+		execID:   execID,
+		priority: priority,
+		demand:   demand,
+		ready:    make(chan error, 1),
+	}
+	i := len(q.waiting)
+	for i > 0 && q.waiting[i-1].priority < priority {
+		i--
+	}
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = t
+	q.dispatchLocked()
+	return t, t.slots == nil
+}
+
+// wait blocks until the ticket is dispatched. On error the caller holds no slot.
+func (q *execQueue) wait(ctx context.Context, t *queueTicket) error {
+	select {
+	case err := <-t.ready:
+		return err
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.removeLocked(t.execID) != nil {
+		return ctx.Err()
+	}
+	// Dispatched concurrently with the cancellation: give the slots back.
+	if err := <-t.ready; err != nil {
+		return err
+	}
+	q.releaseLocked(t)
+	return ctx.Err()
+}
+
+// release frees the run slot and resources of a finished exec and dispatches
+// whatever can run next.
+func (q *execQueue) release(t *queueTicket) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(t)
+}
+
+func (q *execQueue) releaseLocked(t *queueTicket) {
+	if q.running > 0 {
+		q.running--
+	}
+	for pool, slots := range t.slots {
+		for _, slot := range slots {
+			for i, name := range q.pools[pool] {
+				if name == slot {
+					q.inUse[pool][i] = false
+				}
+			}
+		}
+	}
+	t.slots = nil
+	q.dispatchLocked()
+}
+
+func (q *execQueue) dispatchLocked() {
+	held := map[string]bool{} // pools the execs passed over want
+	passed := 0
+	for i := 0; i < len(q.waiting); {
+		if q.max > 0 && q.running+passed >= q.max {
+			return
+		}
+		t := q.waiting[i]
+		if !q.fitsLocked(t.demand) || wantsAny(t.demand, held) {
+			for pool := range t.demand {
+				held[pool] = true
+			}
+			passed++
+			i++
+			continue
+		}
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		t.slots = q.reserveLocked(t.demand)
+		q.running++
+		t.ready <- nil
+	}
+}
+
+func wantsAny(demand map[string]int, pools map[string]bool) bool {
+	for pool := range demand {
+		if pools[pool] {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *execQueue) fitsLocked(demand map[string]int) bool {
+	for pool, n := range demand {
+		free := 0
+		for _, used := range q.inUse[pool] {
+			if !used {
+				free++
+			}
+		}
+		if free < n {
+			return false
+		}
+	}
+	return true
+}
+
+// reserveLocked takes the lowest free slots of each pool. The result is never nil.
+func (q *execQueue) reserveLocked(demand map[string]int) map[string][]string {
+	out := map[string][]string{}
+	for pool, n := range demand {
+		for i, used := range q.inUse[pool] {
+			if len(out[pool]) == n {
+				break
+			}
+			if !used {
+				q.inUse[pool][i] = true
+				out[pool] = append(out[pool], q.pools[pool][i])
+			}
+		}
+	}
+	return out
+}
+
+// adopt counts an exec that is already running, e.g. one that outlived a previous
+// codexd, against max_concurrent_execs and marks its slots in use.
+func (q *execQueue) adopt(execID string, slots map[string][]string) *queueTicket {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running++
+	for pool, names := range slots {
+		for _, slot := range names {
+			for i, name := range q.pools[pool] {
+				if name == slot {
+					q.inUse[pool][i] = true
+				}
+			}
+		}
+	}
+	return &queueTicket{execID: execID, slots: slots}
+}
+
+// cancel removes a waiting exec and returns its ticket, or nil if execID is not queued.
+// The waiter stays blocked until the caller calls abort, so it can finalize the exec first.
+func (q *execQueue) cancel(execID string) *queueTicket {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.removeLocked(execID)
+}
+
+// abort wakes the waiter of a ticket removed by cancel.
+func (t *queueTicket) abort() {
+	t.ready <- errQueueCanceled
+}
+
+func (q *execQueue) removeLocked(execID string) *queueTicket {
+	for i, t := range q.waiting {
+		if t.execID == execID {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return t
+		}
+	}
+	return nil
+}
+
+// position returns the 1-based queue position of execID, or 0 if it is not waiting.
+func (q *execQueue) position(execID string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, t := range q.waiting {
+		if t.execID == execID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// checkDemand validates a resource request against the configured pools.
+func (q *execQueue) checkDemand(demand map[string]int) error {
+	for pool, n := range demand {
+		slots, ok := q.pools[pool]
+		if !ok {
+			return fmt.Errorf("unknown resource pool %q", pool)
+		}
+		if n <= 0 || n > len(slots) {
+			return fmt.Errorf("resources.%s must be between 1 and %d", pool, len(slots))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecQueueDispatchesByPriority(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	cfg.MaxConcurrentExecs = 1
+	svc := service.New(cfg)
+	h := svc.Handler()
+
+	orderPath := filepath.Join(dir, "order.txt")
+	record := func(name string) string { return "echo " + name + " >> " + orderPath }
+
+	blockerID := startExec(t, h, "sleep 30")
+	waitStarted(t, h, blockerID, 5*time.Second)
+	lowID := startExecWithBody(t, h, map[string]any{"cmd": record("low")})
+	droppedID := startExecWithBody(t, h, map[string]any{"cmd": record("dropped")})
+	highID := startExecWithBody(t, h, map[string]any{"cmd": record("high"), "priority": 5})
+
+	var meta map[string]any
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+highID, nil), &meta); err != nil {
+		t.Fatalf("invalid meta: %v", err)
+	}
+	if meta["status"] != "queued" || meta["queue_position"] != float64(1) {
+		t.Fatalf("high priority exec = %v at position %v, want queued at 1", meta["status"], meta["queue_position"])
+	}
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+lowID, nil), &meta); err != nil {
+		t.Fatalf("invalid meta: %v", err)
+	}
+	if meta["queue_position"] != float64(2) {
+		t.Fatalf("low priority exec position = %v, want 2", meta["queue_position"])
+	}
+
+	do(t, h, "POST", "/v1/exec/"+droppedID+"/cancel", nil)
+	dropped := waitStatus(t, h, droppedID, "canceled", 5*time.Second)
+	if dropped["pid"] != nil || dropped["started_at"] != nil {
+		t.Fatalf("canceled queued exec was started: %v", dropped)
+	}
+	if errMsg, _ := dropped["error"].(string); !strings.Contains(errMsg, "canceled while queued") {
+		t.Fatalf("error = %q, want queue cancellation", errMsg)
+	}
+
+	do(t, h, "POST", "/v1/exec/"+blockerID+"/cancel", nil)
+	waitFinished(t, h, lowID, 10*time.Second)
+	waitFinished(t, h, highID, 5*time.Second)
+
+	order, err := os.ReadFile(orderPath)
+	if err != nil {
+		t.Fatalf("read order: %v", err)
+	}
+	if got := strings.Fields(string(order)); strings.Join(got, ",") != "high,low" {
+		t.Fatalf("dispatch order = %v, want [high low]", got)
+	}
+}
+
+func TestExecRunCanceledWhenClientLeavesQueue(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.MaxConcurrentExecs = 1
+	h := service.New(cfg).Handler()
+
+	blockerID := startExec(t, h, "sleep 30")
+	waitStarted(t, h, blockerID, 5*time.Second)
+	defer cancelExec(t, h, blockerID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec/run", strings.NewReader(`{"cmd":"echo never"}`)).WithContext(ctx))
+	events := parseJSONLLines(t, rr.Body.Bytes())
+	if len(events) != 2 || events[0]["type"] != "queued" {
+		t.Fatalf("events = %v, want queued, then finished", events)
+	}
+	meta := waitStatus(t, h, eventExecID(events), "canceled", 5*time.Second)
+	if meta["canceled_at"] == nil || meta["cancel_reason"] != "client disconnected while queued" || meta["started_at"] != nil {
+		t.Fatalf("meta = %v, want it canceled without starting", meta)
+	}
+	if end := events[1]; end["type"] != "finished" || end["status"] != "canceled" {
+		t.Fatalf("last event = %v, want it finished as canceled", end)
+	}
+}
+
+func TestExecQueueKeepsResourcesForTheHead(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	cfg.Resources = map[string][]string{"gpu": {"0", "1"}}
+	h := service.New(cfg).Handler()
+
+	orderPath := filepath.Join(dir, "order.txt")
+	gpus := func(cmd string, n int) map[string]any {
+		return map[string]any{"cmd": cmd, "resources": map[string]int{"gpu": n}}
+	}
+	firstID := startExecWithBody(t, h, gpus("sleep 30", 1))
+	t.Cleanup(func() { cancelExec(t, h, firstID) })
+	waitStarted(t, h, firstID, 5*time.Second)
+	bigID := startExecWithBody(t, h, gpus("echo big >> "+orderPath, 2))
+
+	// One GPU is free, but small execs that come later do not take it from the
+	// big one, or they could keep it waiting forever.
+	var smallIDs []string
+	for i := 0; i < 3; i++ {
+		smallIDs = append(smallIDs, startExecWithBody(t, h, gpus("echo small >> "+orderPath, 1)))
+	}
+	var meta map[string]any
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+smallIDs[0], nil), &meta); err != nil {
+		t.Fatalf("invalid meta: %v", err)
+	}
+	if meta["status"] != "queued" || meta["queue_position"] != float64(2) {
+		t.Fatalf("small exec = %v at position %v, want queued behind the big one", meta["status"], meta["queue_position"])
+	}
+	// An exec that wants no GPU does not hold it up.
+	if meta := waitFinished(t, h, startExec(t, h, "true"), 5*time.Second); meta["exit_code"] != float64(0) {
+		t.Fatalf("exec without resources = %v, want it run", meta)
+	}
+
+	cancelExec(t, h, firstID)
+	for _, id := range append([]string{bigID}, smallIDs...) {
+		waitFinished(t, h, id, 10*time.Second)
+	}
+	order, err := os.ReadFile(orderPath)
+	if err != nil {
+		t.Fatalf("read order: %v", err)
+	}
+	if got := strings.Fields(string(order)); strings.Join(got, ",") != "big,small,small,small" {
+		t.Fatalf("dispatch order = %v, want the big exec first", got)
+	}
+}
+
+// waitStarted waits until the exec's process exists, so cancel reaches it.
+func waitStarted(t *testing.T, h http.Handler, execID string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		var meta map[string]any
+		if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+execID, nil), &meta); err != nil {
+			t.Fatalf("invalid meta: %v", err)
+		}
+		if meta["pid"] != nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for exec %s to start", execID)
+}
+
+func TestExecResourceSlotsAssignedAndReleased(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	cfg.Resources = map[string][]string{"gpu": {"0", "1"}}
+	svc := service.New(cfg)
+	h := svc.Handler()
+
+	gpuCmd := `echo "gpu=$CUDA_VISIBLE_DEVICES"; sleep 30`
+	firstID := startExecWithBody(t, h, map[string]any{"cmd": gpuCmd, "resources": map[string]int{"gpu": 1}})
+	secondID := startExecWithBody(t, h, map[string]any{"cmd": gpuCmd, "resources": map[string]int{"gpu": 1}})
+	waitingID := startExecWithBody(t, h, map[string]any{"cmd": `echo "gpu=$CUDA_VISIBLE_DEVICES"`, "resources": map[string]int{"gpu": 1}})
+	t.Cleanup(func() { cancelExec(t, h, secondID) })
+	waitStarted(t, h, firstID, 5*time.Second)
+	waitStarted(t, h, secondID, 5*time.Second)
+
+	var meta map[string]any
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+waitingID, nil), &meta); err != nil {
+		t.Fatalf("invalid meta: %v", err)
+	}
+	if meta["status"] != "queued" {
+		t.Fatalf("third gpu exec status = %v, want queued", meta["status"])
+	}
+
+	for id, want := range map[string]string{firstID: "0", secondID: "1"} {
+		if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+id, nil), &meta); err != nil {
+			t.Fatalf("invalid meta: %v", err)
+		}
+		if got := fmt.Sprint(meta["slots"]); got != "map[gpu:["+want+"]]" {
+			t.Fatalf("exec %s slots = %s, want gpu %s", id, got, want)
+		}
+	}
+
+	do(t, h, "POST", "/v1/exec/"+firstID+"/cancel", nil)
+	waitFinished(t, h, waitingID, 10*time.Second)
+	logs := strings.TrimSpace(string(do(t, h, "GET", "/v1/exec/"+waitingID+"/logs?stream=stdout", nil)))
+	if logs != "gpu=0" {
+		t.Fatalf("queued exec got %q, want the released slot gpu=0", logs)
+	}
+
+	b, _ := json.Marshal(map[string]any{"cmd": "true", "resources": map[string]int{"gpu": 3}})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec", bytes.NewReader(b)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("oversized resource request => %d, want 400", rr.Code)
+	}
+}
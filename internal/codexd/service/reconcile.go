@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// orphanPollInterval is how often reconcile checks on execs that outlived a previous codexd.
+const orphanPollInterval = 2 * time.Second
+
+// reconcile repairs exec state left behind by a previous codexd process. Execs
+// whose supervisor is still running are re-attached, waiting execs wait again
+// for their not_before and depends_on, queued execs are queued again in the
+// order they were, running execs whose process is gone are marked lost, execs
+// whose process survived without a supervisor are watched until it exits, and
+// stale project worktrees are pruned. Re-attached and surviving execs keep
+// their run slot and resources.
+func (s *Service) reconcile() {
+	execRoot := filepath.Join(s.cfg.DataDir, "exec")
+	entries, err := os.ReadDir(execRoot)
+	if err != nil {
+		return
+	}
+	var queued []execMeta
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		execDir := filepath.Join(execRoot, e.Name())
+		meta, err := readMeta(execDir)
+		if err != nil || isTerminalStatus(meta.Status) {
+			continue
+		}
+		pid, pidErr := readPID(execDir)
+		supervisorPID, supervisorErr := readSupervisorPID(execDir)
+		switch {
+		case meta.Status == statusWaiting:
+			go s.launchExec(execDir, meta, resumeRequest(meta), nil)
+		case meta.Status == statusQueued:
+			queued = append(queued, meta)
+		case supervisorErr == nil && processAlive(supervisorPID):
+			go s.watchSupervisor(execDir, supervisorPID, s.queue.adopt(meta.ExecID, meta.Slots))
+		case meta.RetryAt != "":
+			s.markLost(execDir, meta, "codexd restarted while the exec waited to retry")
+		case pidErr != nil:
+			s.markLost(execDir, meta, "codexd restarted before the process started")
+		case processAlive(pid):
+			ticket := s.queue.adopt(meta.ExecID, meta.Slots)
+			go func() {
+				s.watchOrphan(execDir, meta, pid)
+				s.queue.release(ticket)
+			}()
+		default:
+			s.markLost(execDir, meta, fmt.Sprintf("codexd restarted while the exec was running; process %d is gone and its exit status is unknown", pid))
+		}
+	}
+	// Once the execs still running hold their slots.
+	sort.SliceStable(queued, func(i, j int) bool {
+		if queued[i].Priority != queued[j].Priority {
+			return queued[i].Priority > queued[j].Priority
+		}
+		ti, _ := time.Parse(time.RFC3339Nano, queued[i].QueuedAt)
+		tj, _ := time.Parse(time.RFC3339Nano, queued[j].QueuedAt)
+		return ti.Before(tj)
+	})
+	for _, meta := range queued {
+		req := resumeRequest(meta)
+		ticket, _ := s.queue.enqueue(meta.ExecID, req.Priority, req.Resources)
+		go s.launchExec(filepath.Join(execRoot, meta.ExecID), meta, req, ticket)
+	}
+	s.pruneWorktrees()
+}
+
+// resumeRequest is the request of an exec that has not started yet, to go on
+// with after a restart. Execs from before codexd recorded provenance get theirs
+// rebuilt from meta, with the daemon's shell and limits.
+func resumeRequest(meta execMeta) execRequest {
+	req := rerunRequest(meta)
+	if meta.Provenance == nil {
+		req.DependsOn, req.DependsOnPolicy, req.NotBefore = meta.DependsOn, meta.DependsOnPolicy, meta.NotBefore
+	}
+	// Both were checked when the exec was submitted.
+	req.timeout, _ = time.ParseDuration(req.Timeout)
+	req.notBefore, _ = time.Parse(time.RFC3339, req.NotBefore)
+	return req
+}
+
+// watchOrphan waits for a process started by a previous codexd. It is not our
+// child, so its exit status cannot be collected.
+func (s *Service) watchOrphan(execDir string, meta execMeta, pid int) {
+	for processAlive(pid) {
+		time.Sleep(orphanPollInterval)
+	}
+	s.markLost(execDir, meta, fmt.Sprintf("process %d exited after codexd restarted; exit status unknown", pid))
+}
+
+// markLost finalizes an exec whose outcome is unknown, or that was canceled,
+// and removes its worktree.
+func (s *Service) markLost(execDir string, meta execMeta, reason string) {
+	s.removeWorktree(execDir, meta.ProjectID)
+	meta.Status = statusLost
+	if c, ok := readCancel(execDir); ok {
+		// It was canceled, only its exit status is unknown.
+		meta = c.apply(meta)
+	}
+	meta.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	meta.Error = reason
+	_ = writeMeta(execDir, meta)
+}
+
+func (s *Service) removeWorktree(execDir, projectID string) {
+	workdir := filepath.Join(execDir, "workdir")
+	if projectID == "" {
+		return
+	}
+	if _, err := os.Stat(workdir); err != nil {
+		return
+	}
+	if mirrorDir := s.projectMirrorDir(projectID); mirrorDir != "" {
+		_ = runGit(context.Background(), mirrorDir, "worktree", "remove", "--force", workdir)
+	}
+	_ = os.RemoveAll(workdir)
+}
+
+// pruneWorktrees drops worktree records whose directories no longer exist, e.g.
+// of execs removed by retention or killed together with codexd.
+func (s *Service) pruneWorktrees() {
+	for _, proj := range s.cfg.Projects {
+		mirrorDir := s.mirrorDir(proj)
+		if _, err := os.Stat(mirrorDir); err != nil {
+			continue
+		}
+		_ = runGit(context.Background(), mirrorDir, "worktree", "prune")
+	}
+}
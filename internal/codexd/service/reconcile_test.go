@@ -0,0 +1,174 @@
+package service_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestNewMarksDeadExecsLost(t *testing.T) {
+	dir := t.TempDir()
+	execRoot := filepath.Join(dir, "exec")
+
+	done := exec.Command("true")
+	if err := done.Run(); err != nil {
+		t.Fatalf("run true: %v", err)
+	}
+	alive := exec.Command("sleep", "30")
+	if err := alive.Start(); err != nil {
+		t.Fatalf("start sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = alive.Process.Kill()
+		_ = alive.Wait()
+	})
+
+	writeExecState(t, execRoot, "dead", "running", done.Process.Pid)
+	writeExecState(t, execRoot, "alive", "running", alive.Process.Pid)
+	writeExecState(t, execRoot, "done", "finished", 0)
+
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	for id, want := range map[string]string{"dead": "lost", "alive": "running", "done": "finished"} {
+		var meta map[string]any
+		if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+id, nil), &meta); err != nil {
+			t.Fatalf("invalid meta: %v", err)
+		}
+		if meta["status"] != want {
+			t.Fatalf("exec %s status = %v, want %s", id, meta["status"], want)
+		}
+		if errMsg, _ := meta["error"].(string); want == "lost" && !strings.Contains(errMsg, "codexd restarted") {
+			t.Fatalf("exec %s error = %q, want restart explanation", id, errMsg)
+		}
+	}
+}
+
+func writeExecState(t *testing.T, execRoot, id, status string, pid int) {
+	t.Helper()
+	execDir := filepath.Join(execRoot, id)
+	if err := os.MkdirAll(execDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(map[string]any{"exec_id": id, "status": status, "cmd": "sleep 30"})
+	mustWriteFile(t, filepath.Join(execDir, "meta.json"), string(b))
+	if pid > 0 {
+		mustWriteFile(t, filepath.Join(execDir, "pid"), strconv.Itoa(pid))
+	}
+}
+
+func TestReconcileResumesWaitingExecs(t *testing.T) {
+	dir := t.TempDir()
+	execRoot := filepath.Join(dir, "exec")
+	exitCode := 0
+	writeExecMeta(t, execRoot, map[string]any{"exec_id": "dep", "status": "finished", "cmd": "true", "exit_code": exitCode})
+	writeExecMeta(t, execRoot, map[string]any{
+		"exec_id": "after", "status": "waiting", "cmd": "echo after",
+		"depends_on": []string{"dep"}, "depends_on_policy": "success",
+		"provenance": map[string]any{"request": map[string]any{
+			"cmd": "echo after", "depends_on": []string{"dep"}, "depends_on_policy": "success",
+		}},
+	})
+	notBefore := time.Now().Add(2 * time.Second).UTC().Format(time.RFC3339)
+	writeExecMeta(t, execRoot, map[string]any{
+		"exec_id": "later", "status": "waiting", "cmd": "true", "not_before": notBefore,
+		"provenance": map[string]any{"request": map[string]any{"cmd": "true", "not_before": notBefore}},
+	})
+
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	// The delayed exec waits out what is left of its delay.
+	var meta map[string]any
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/later", nil), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta["status"] != "waiting" {
+		t.Fatalf("delayed exec = %v, want it still waiting", meta)
+	}
+	meta = waitFinished(t, h, "later", 5*time.Second)
+	startedAt, _ := time.Parse(time.RFC3339Nano, meta["started_at"].(string))
+	if due, _ := time.Parse(time.RFC3339, notBefore); meta["exit_code"] != float64(0) || startedAt.Before(due) {
+		t.Fatalf("delayed exec = %v, want it run from %s", meta, notBefore)
+	}
+
+	meta = waitFinished(t, h, "after", 5*time.Second)
+	if meta["status"] != "finished" || meta["exit_code"] != float64(0) {
+		t.Fatalf("dependent exec = %v, want it run once codexd is back", meta)
+	}
+	if logs := string(do(t, h, "GET", "/v1/exec/after/logs", nil)); logs != "after\n" {
+		t.Fatalf("stdout = %q, want after", logs)
+	}
+}
+
+func TestReconcileRequeuesQueuedExecs(t *testing.T) {
+	dir := t.TempDir()
+	execRoot := filepath.Join(dir, "exec")
+	order := filepath.Join(dir, "order")
+	queuedAt := time.Now().Add(-time.Minute)
+	for i, q := range []struct {
+		id       string
+		priority int
+	}{{"q1", 0}, {"q2", 0}, {"q3", 5}} {
+		cmd := "echo " + q.id + " >> " + order
+		writeExecMeta(t, execRoot, map[string]any{
+			"exec_id": q.id, "status": "queued", "cmd": cmd, "priority": q.priority,
+			"queued_at":  queuedAt.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano),
+			"provenance": map[string]any{"request": map[string]any{"cmd": cmd, "priority": q.priority}},
+		})
+	}
+
+	cfg := config.Default()
+	cfg.DataDir = dir
+	cfg.MaxConcurrentExecs = 1
+	h := service.New(cfg).Handler()
+
+	for _, id := range []string{"q1", "q2", "q3"} {
+		if meta := waitFinished(t, h, id, 5*time.Second); meta["exit_code"] != float64(0) {
+			t.Fatalf("exec %s = %v, want it run once codexd is back", id, meta)
+		}
+	}
+	// By priority, then in the order they were queued.
+	if b, _ := os.ReadFile(order); string(b) != "q3\nq1\nq2\n" {
+		t.Fatalf("execs ran in order %q, want q3, q1, q2", b)
+	}
+}
+
+// writeExecMeta leaves the meta of an exec behind as a previous codexd would.
+func writeExecMeta(t *testing.T, execRoot string, meta map[string]any) {
+	t.Helper()
+	execDir := filepath.Join(execRoot, meta["exec_id"].(string))
+	if err := os.MkdirAll(execDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(meta)
+	mustWriteFile(t, filepath.Join(execDir, "meta.json"), string(b))
+}
+
+func TestExecSurvivesServiceRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	execID := startExec(t, service.New(cfg).Handler(), "sleep 1; echo done")
+	waitStarted(t, service.New(cfg).Handler(), execID, 5*time.Second)
+
+	// A new Service on the same data_dir stands in for a restarted codexd.
+	h := service.New(cfg).Handler()
+	meta := waitFinished(t, h, execID, 10*time.Second)
+	if meta["status"] != "finished" || meta["exit_code"] != float64(0) {
+		t.Fatalf("exec = %v with exit code %v, want finished with 0", meta["status"], meta["exit_code"])
+	}
+	if logs := strings.TrimSpace(string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout", nil))); logs != "done" {
+		t.Fatalf("stdout = %q, want done", logs)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"codex-runner/internal/shared/jsonutil"
+)
+
+// handleExecDelete serves DELETE /v1/exec/{id}: it removes an exec that has
+// ended, with its logs and artifacts, ahead of retention_count.
+func (s *Service) handleExecDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	meta, err := readMeta(execDir)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	if !isTerminalStatus(meta.Status) {
+		writeErr(w, http.StatusConflict, "exec has not ended; cancel it first")
+		return
+	}
+	s.mu.Lock()
+	err = os.RemoveAll(execDir)
+	s.pruneIdempotencyKeys()
+	s.mu.Unlock()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to delete exec")
+		return
+	}
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"exec_id": id,
+		"deleted": true,
+	})
+}
+
+// handleExecDeleteSelected serves DELETE /v1/exec?selector=...: it removes the
+// execs that match the label selector and the other filters of GET /v1/exec
+// and have ended. A selector is required; execs that have not ended are kept
+// and counted in not_ended.
+func (s *Service) handleExecDeleteSelected(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseExecListFilter(r.URL.Query())
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(filter.Selector) == 0 {
+		writeErr(w, http.StatusBadRequest, "a label selector is required, e.g. selector=sweep=lr")
+		return
+	}
+	metas, err := s.listExecMetas()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list execs")
+		return
+	}
+	deleted := []string{}
+	notEnded := 0
+	s.mu.Lock()
+	for _, meta := range metas {
+		if !filter.match(meta) {
+			continue
+		}
+		if !isTerminalStatus(meta.Status) {
+			notEnded++
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.cfg.DataDir, "exec", meta.ExecID)); err == nil {
+			deleted = append(deleted, meta.ExecID)
+		}
+	}
+	s.pruneIdempotencyKeys()
+	s.mu.Unlock()
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"deleted":   len(deleted),
+		"exec_ids":  deleted,
+		"not_ended": notEnded,
+	})
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// execProvenance records what an exec ran and where, so that it can be told
+// apart from, or rerun as, another exec of the same cmd.
+type execProvenance struct {
+	Commit        string `json:"commit,omitempty"` // the ref resolved, for a project exec
+	Shell         string `json:"shell"`
+	DaemonVersion string `json:"daemon_version"`
+	Hostname      string `json:"hostname"`
+	// EnvHash is "sha256:<hex>" of the environment the cmd was started with,
+	// one sorted KEY=VAL per line. It identifies the environment without
+	// storing its values.
+	EnvHash string      `json:"env_hash,omitempty"`
+	Request execRequest `json:"request"` // as submitted
+}
+
+// envHash hashes an environment as exec would apply it: later entries for a
+// key win.
+func envHash(env []string) string {
+	vars := map[string]string{}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		vars[k] = v
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		_, _ = io.WriteString(h, k+"="+vars[k]+"\n")
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// handleExecRerun serves POST /v1/exec/{id}/rerun: it submits the request of an
+// exec again, as a new exec linked to it by parent_exec_id. A project exec runs
+// at the commit its ref resolved to then. The optional body {"env": {...}}
+// sets env vars over the ones the exec had.
+func (s *Service) handleExecRerun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	meta, err := readMeta(filepath.Join(s.cfg.DataDir, "exec", id))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	var body struct {
+		Env map[string]string `json:"env"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeErr(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	req := rerunRequest(meta)
+	if len(body.Env) > 0 {
+		env := maps.Clone(req.Env)
+		if env == nil {
+			env = map[string]string{}
+		}
+		maps.Copy(env, body.Env)
+		req.Env = env
+	}
+	req.rerunOf = id
+	if err := s.checkExecRequest(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.startExec(w, req)
+}
+
+// rerunRequest is the request to submit to rerun an exec. Execs from before
+// codexd recorded provenance get theirs rebuilt from meta, with the daemon's
+// shell and limits.
+func rerunRequest(meta execMeta) execRequest {
+	if p := meta.Provenance; p != nil {
+		req := p.Request
+		if p.Commit != "" {
+			req.Ref = p.Commit
+		}
+		// The name and labels as they are now, if changed since.
+		req.Name, req.Labels = meta.Name, meta.Labels
+		return req
+	}
+	return execRequest{
+		ProjectID: meta.ProjectID,
+		Ref:       meta.Ref,
+		Cmd:       meta.Cmd,
+		Cwd:       meta.Cwd,
+		Env:       meta.Env,
+		Timeout:   meta.Timeout,
+		Priority:  meta.Priority,
+		Resources: meta.Resources,
+		Stdin:     meta.Stdin,
+		PTY:       meta.PTY,
+		Retry:     meta.Retry,
+		Name:      meta.Name,
+		Labels:    meta.Labels,
+	}
+}
+
+// replayStdin gives a rerun the input its parent got so far. The rerun's stdin
+// is closed if the parent's was, or the parent has finished; otherwise more
+// can be sent to it.
+func replayStdin(parentDir, execDir string) error {
+	data, err := os.ReadFile(stdinPath(parentDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.WriteFile(stdinPath(execDir), data, 0o644); err != nil {
+		return err
+	}
+	meta, err := readMeta(parentDir)
+	if stdinClosed(parentDir) || err != nil || isTerminalStatus(meta.Status) {
+		return os.WriteFile(stdinClosedPath(execDir), nil, 0o644)
+	}
+	return nil
+}
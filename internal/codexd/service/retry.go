@@ -0,0 +1,239 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRetryAttempts   = 100
+	defaultRetryDelay  = 10 * time.Second
+	defaultRetryMaxGap = 10 * time.Minute
+	// retryReasonLine caps the matched output line quoted in a retry reason.
+	retryReasonLine = 200
+)
+
+// execRetry is the retry policy of an exec: after a failed attempt that is
+// retryable, the supervisor runs the cmd again in the same exec, up to
+// MaxAttempts runs in all. A failure is retryable if its exit code is one of
+// ExitCodes or a line of its output matches LogPattern; with neither set, every
+// failure is. Timed out and canceled attempts are not retried.
+type execRetry struct {
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff is the wait before the first retry, doubled before each one
+	// after it up to MaxBackoff.
+	Backoff    string `json:"backoff,omitempty"`
+	MaxBackoff string `json:"max_backoff,omitempty"`
+	ExitCodes  []int  `json:"exit_codes,omitempty"`
+	LogPattern string `json:"log_pattern,omitempty"`
+}
+
+// execAttempt is one run of an exec with a retry policy. Its logs and exit
+// code are kept in LogDir under the exec dir.
+type execAttempt struct {
+	Attempt    int              `json:"attempt"`
+	LogDir     string           `json:"log_dir"` // e.g. attempts/2
+	PID        int              `json:"pid,omitempty"`
+	StartedAt  string           `json:"started_at"`
+	FinishedAt string           `json:"finished_at,omitempty"`
+	Status     string           `json:"status,omitempty"` // finished, timed_out or canceled once it has ended
+	ExitCode   *int             `json:"exit_code,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	LogDropped map[string]int64 `json:"log_dropped,omitempty"`
+	Usage      *execUsage       `json:"usage,omitempty"`
+	// RetryReason says why the attempt was retried, e.g. "exit code 137".
+	RetryReason string `json:"retry_reason,omitempty"`
+}
+
+// checkRetry validates a retry policy; delay fills in its defaults.
+func checkRetry(r *execRetry) error {
+	if r.MaxAttempts < 1 || r.MaxAttempts > maxRetryAttempts {
+		return fmt.Errorf("retry.max_attempts must be 1-%d", maxRetryAttempts)
+	}
+	for _, d := range []*string{&r.Backoff, &r.MaxBackoff} {
+		if *d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(*d); err != nil || v < 0 {
+			return errors.New("retry.backoff and retry.max_backoff must be durations like 30s")
+		}
+	}
+	if r.LogPattern != "" {
+		if _, err := regexp.Compile(r.LogPattern); err != nil {
+			return errors.New("retry.log_pattern: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// delay is the wait before the retry that follows attempt n.
+func (r *execRetry) delay(n int) time.Duration {
+	d, maxGap := defaultRetryDelay, defaultRetryMaxGap
+	if r.Backoff != "" {
+		d, _ = time.ParseDuration(r.Backoff)
+	}
+	if r.MaxBackoff != "" {
+		maxGap, _ = time.ParseDuration(r.MaxBackoff)
+	}
+	for i := 1; i < n && d < maxGap; i++ {
+		d *= 2
+	}
+	return min(d, maxGap)
+}
+
+// retryReason returns why a failed attempt whose logs are in logDir is to be
+// retried, or "" if it is not retryable.
+func (r *execRetry) retryReason(logDir, execID string, exitCode int) string {
+	if len(r.ExitCodes) == 0 && r.LogPattern == "" {
+		return "exit code " + strconv.Itoa(exitCode)
+	}
+	if slices.Contains(r.ExitCodes, exitCode) {
+		return "exit code " + strconv.Itoa(exitCode)
+	}
+	if r.LogPattern == "" {
+		return ""
+	}
+	q := searchQuery{pattern: regexp.MustCompile(r.LogPattern), stream: "both", maxMatches: 1}
+	reason := ""
+	_, _ = searchLogs(logDir, execID, q, 1, true, func(ev any) error {
+		line, _ := ev.(map[string]any)["line"].(string)
+		if len(line) > retryReasonLine {
+			line = line[:retryReasonLine] + "..."
+		}
+		reason = fmt.Sprintf("exit code %d, %s matched %q", exitCode, ev.(map[string]any)["stream"], line)
+		return nil
+	})
+	return reason
+}
+
+// attemptDir holds the logs and exit code of attempt n of an exec.
+func attemptDir(execDir string, n int) string {
+	return filepath.Join(execDir, "attempts", strconv.Itoa(n))
+}
+
+// logDir is where the logs of an exec's current, or last, attempt are: its
+// attempt dir for an exec with a retry policy, the exec dir otherwise.
+func logDir(execDir string, meta execMeta) string {
+	if n := len(meta.Attempts); n > 0 {
+		return attemptDir(execDir, n)
+	}
+	return execDir
+}
+
+// startAttempt records the start of the next attempt of an exec and returns
+// the dir for its logs. The logs of the attempt before are complete by then.
+func startAttempt(execDir string, meta *execMeta) (string, error) {
+	n := len(meta.Attempts) + 1
+	dir := attemptDir(execDir, n)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	// A mark left by the last attempt does not hold for this one.
+	_ = os.Remove(pausedPath(execDir))
+	meta.RetryAt = ""
+	meta.Attempts = append(meta.Attempts, execAttempt{
+		Attempt:   n,
+		LogDir:    filepath.ToSlash(filepath.Join("attempts", strconv.Itoa(n))),
+		StartedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	return dir, writeMeta(execDir, *meta)
+}
+
+// endAttempt records how the current attempt of an exec ended. Its process is
+// gone, so the exec's pid file goes too.
+func endAttempt(execDir, logDir string, meta *execMeta, status string, res attemptResult, err error) {
+	_ = removePID(execDir)
+	a := &meta.Attempts[len(meta.Attempts)-1]
+	a.PID = meta.PID
+	a.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	a.Status = status
+	a.ExitCode = &res.exitCode
+	a.LogDropped = res.dropped
+	a.Usage = res.usage
+	if err != nil {
+		a.Error = err.Error()
+	}
+	_ = writeExitCode(logDir, res.exitCode)
+}
+
+// awaitRetry waits out the backoff before the next attempt of an exec, and
+// reports false if the exec was canceled meanwhile. No process runs until
+// then: the pid of the attempt that failed stays in its attempt record only.
+func awaitRetry(execDir string, meta *execMeta, delay time.Duration) bool {
+	at := time.Now().Add(delay)
+	meta.PID = 0
+	meta.RetryAt = at.UTC().Format(time.RFC3339Nano)
+	_ = writeMeta(execDir, *meta)
+	for {
+		if _, ok := readCancel(execDir); ok {
+			return false
+		}
+		wait := time.Until(at)
+		if wait <= 0 {
+			return true
+		}
+		time.Sleep(min(wait, cancelPoll))
+	}
+}
+
+// attemptEvent announces in a log stream that the exec moved on to attempt n,
+// after the one before failed as meta says.
+func attemptEvent(meta execMeta, n int) map[string]any {
+	ev := map[string]any{"type": "attempt", "attempt": n}
+	if meta.Retry != nil {
+		ev["max_attempts"] = meta.Retry.MaxAttempts
+	}
+	if n >= 2 && n-2 < len(meta.Attempts) {
+		prev := meta.Attempts[n-2]
+		if prev.ExitCode != nil {
+			ev["previous_exit_code"] = *prev.ExitCode
+		}
+		if prev.RetryReason != "" {
+			ev["retry_reason"] = prev.RetryReason
+		}
+	}
+	return ev
+}
+
+// attemptLine is attemptEvent for plain log output.
+func attemptLine(ev map[string]any) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[codexd: attempt %v", ev["attempt"])
+	if n, ok := ev["max_attempts"]; ok {
+		fmt.Fprintf(&b, " of %v", n)
+	}
+	if reason, ok := ev["retry_reason"]; ok {
+		fmt.Fprintf(&b, ", retrying after %v", reason)
+	}
+	b.WriteString("]\n")
+	return []byte(b.String())
+}
+
+// nextAttempt reads the rest of the logs of attempt n, which has ended, and
+// returns a reader of the logs of attempt n+1 from their start, announcing it
+// if it is a retry.
+func nextAttempt(execDir string, m *logMerger, n int, meta execMeta, emit func(logLine) error, announce func(map[string]any) error) (*logMerger, error) {
+	if err := m.flush(emit); err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		if err := announce(attemptEvent(meta, n+1)); err != nil {
+			return nil, err
+		}
+	}
+	starts := map[string]int64{}
+	for _, f := range m.followers {
+		starts[f.stream] = 0
+	}
+	dir := attemptDir(execDir, n+1)
+	index := openLogIndex(dir)
+	index.expect()
+	return newLogMerger(dir, index, starts, 1), nil
+}
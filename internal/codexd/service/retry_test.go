@@ -0,0 +1,157 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+// failTwiceCmd fails with exit code 1 on its first two runs and succeeds on the
+// third, counting runs in a file under dir.
+func failTwiceCmd(dir string) string {
+	counter := filepath.ToSlash(filepath.Join(dir, "runs"))
+	return `n=$(cat ` + counter + ` 2>/dev/null || echo 0); n=$((n+1)); echo $n > ` + counter + `; echo "attempt $n"; [ $n -ge 3 ]`
+}
+
+func TestExecRetry(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	retry := map[string]any{"max_attempts": 3, "backoff": "10ms"}
+	execID := startExecWithBody(t, h, map[string]any{"cmd": failTwiceCmd(t.TempDir()), "retry": retry})
+	// Following from the start reads every attempt, each retry announced.
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?follow=true&full=true&stream=stdout&format=jsonl", nil))
+	var lines []string
+	var attempts []float64
+	for _, ev := range events {
+		switch ev["type"] {
+		case "attempt":
+			attempts = append(attempts, ev["attempt"].(float64))
+			if ev["previous_exit_code"] != float64(1) || ev["retry_reason"] != "exit code 1" {
+				t.Fatalf("attempt event = %v, want the failure of the attempt before", ev)
+			}
+		case "finished":
+		default:
+			lines = append(lines, ev["line"].(string))
+		}
+	}
+	if got := strings.Join(lines, ","); got != "attempt 1,attempt 2,attempt 3" {
+		t.Fatalf("followed lines = %q, want the output of every attempt", got)
+	}
+	if len(attempts) != 2 || attempts[0] != 2 || attempts[1] != 3 {
+		t.Fatalf("attempt events = %v, want 2 and 3", attempts)
+	}
+
+	meta := waitFinished(t, h, execID, 5*time.Second)
+	if meta["exit_code"] != float64(0) {
+		t.Fatalf("exit_code = %v, want 0 from the last attempt", meta["exit_code"])
+	}
+	runs, _ := meta["attempts"].([]any)
+	if len(runs) != 3 {
+		t.Fatalf("attempts = %v, want 3", meta["attempts"])
+	}
+	for i, want := range []float64{1, 1, 0} {
+		a := runs[i].(map[string]any)
+		if a["attempt"] != float64(i+1) || a["exit_code"] != want || a["status"] != "finished" || a["started_at"] == nil || a["finished_at"] == nil {
+			t.Fatalf("attempts[%d] = %v, want a finished run with exit code %v", i, a, want)
+		}
+	}
+	if logs := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout", nil)); logs != "attempt 3\n" {
+		t.Fatalf("stdout = %q, want the last attempt's", logs)
+	}
+	if logs := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&attempt=1", nil)); logs != "attempt 1\n" {
+		t.Fatalf("stdout of attempt 1 = %q", logs)
+	}
+	for query, code := range map[string]int{
+		"attempt=4":             http.StatusNotFound,
+		"attempt=0":             http.StatusBadRequest,
+		"attempt=1&follow=true": http.StatusBadRequest,
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example/v1/exec/"+execID+"/logs?"+query, nil))
+		if rr.Code != code {
+			t.Fatalf("logs?%s => %d, want %d", query, rr.Code, code)
+		}
+	}
+
+	// exec run streams the retries too.
+	events = parseJSONLLines(t, runExec(t, h, map[string]any{"cmd": failTwiceCmd(t.TempDir()), "retry": retry}))
+	lines, attempts = nil, nil
+	for _, ev := range events {
+		switch ev["type"] {
+		case "attempt":
+			attempts = append(attempts, ev["attempt"].(float64))
+		case "log":
+			lines = append(lines, ev["line"].(string))
+		case "finished":
+			if ev["exit_code"] != float64(0) {
+				t.Fatalf("finished event = %v, want exit code 0", ev)
+			}
+		}
+	}
+	if got := strings.Join(lines, ","); got != "attempt 1,attempt 2,attempt 3" || len(attempts) != 2 {
+		t.Fatalf("exec run lines = %q, attempts = %v, want every attempt", got, attempts)
+	}
+}
+
+func TestExecRetryPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	for _, body := range []string{
+		`{"cmd":"true","retry":{"max_attempts":0}}`,
+		`{"cmd":"true","retry":{"max_attempts":101}}`,
+		`{"cmd":"true","retry":{"max_attempts":2,"backoff":"soon"}}`,
+		`{"cmd":"true","retry":{"max_attempts":2,"log_pattern":"("}}`,
+		`{"cmd":"true","pty":true,"retry":{"max_attempts":2}}`,
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("POST /v1/exec %s => %d, want 400", body, rr.Code)
+		}
+	}
+
+	// An exit code the policy does not name is not retried.
+	execID := startExecWithBody(t, h, map[string]any{
+		"cmd":   "exit 3",
+		"retry": map[string]any{"max_attempts": 3, "backoff": "10ms", "exit_codes": []int{75}},
+	})
+	meta := waitFinished(t, h, execID, 5*time.Second)
+	if runs, _ := meta["attempts"].([]any); meta["exit_code"] != float64(3) || len(runs) != 1 {
+		t.Fatalf("meta = %v, want one attempt with exit code 3", meta)
+	}
+
+	// Nor is a failure whose output does not match the pattern, once it stops
+	// matching.
+	dir := filepath.ToSlash(t.TempDir())
+	execID = startExecWithBody(t, h, map[string]any{
+		"cmd":   `if [ -e ` + dir + `/ran ]; then echo "bad config" >&2; else touch ` + dir + `/ran; echo "connection reset by peer" >&2; fi; exit 1`,
+		"retry": map[string]any{"max_attempts": 3, "backoff": "10ms", "log_pattern": "connection (reset|refused)"},
+	})
+	meta = waitFinished(t, h, execID, 5*time.Second)
+	runs, _ := meta["attempts"].([]any)
+	if meta["exit_code"] != float64(1) || len(runs) != 2 {
+		t.Fatalf("meta = %v, want two attempts", meta)
+	}
+	reason, _ := runs[0].(map[string]any)["retry_reason"].(string)
+	if !strings.Contains(reason, "connection reset by peer") {
+		t.Fatalf("retry_reason = %q, want the matched line", reason)
+	}
+	if _, ok := runs[1].(map[string]any)["retry_reason"]; ok {
+		t.Fatalf("attempts[1] = %v, want it not retried", runs[1])
+	}
+
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs/search?pattern=bad", nil))
+	if end := events[len(events)-1]; end["matches"] != float64(1) {
+		t.Fatalf("search of the last attempt = %v, want one match", events)
+	}
+}
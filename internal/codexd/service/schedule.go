@@ -0,0 +1,269 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"codex-runner/internal/shared/id"
+	"codex-runner/internal/shared/jsonutil"
+)
+
+// scheduleTick is how often codexd looks for schedules that are due.
+const scheduleTick = time.Second
+
+// schedule creates an ordinary exec from Exec each time Cron is due. Schedules
+// are kept in <data_dir>/schedules/<schedule_id>.json; runs that fell due while
+// codexd was down are not made up for.
+type schedule struct {
+	ScheduleID string      `json:"schedule_id"`
+	Cron       string      `json:"cron"`
+	Timezone   string      `json:"timezone,omitempty"` // IANA name; codexd's local time if unset
+	Exec       execRequest `json:"exec"`
+	CreatedAt  string      `json:"created_at"`
+	NextRunAt  string      `json:"next_run_at,omitempty"`
+	LastRunAt  string      `json:"last_run_at,omitempty"`
+	LastExecID string      `json:"last_exec_id,omitempty"`
+	LastError  string      `json:"last_error,omitempty"` // why the last run created no exec
+
+	expr cronExpr
+	loc  *time.Location
+	next time.Time
+}
+
+func (sc *schedule) setNext(t time.Time) {
+	sc.next = t
+	sc.NextRunAt = ""
+	if !t.IsZero() {
+		sc.NextRunAt = t.Format(time.RFC3339)
+	}
+}
+
+func (s *Service) schedulesDir() string { return filepath.Join(s.cfg.DataDir, "schedules") }
+
+func (s *Service) writeSchedule(sc *schedule) error {
+	if err := os.MkdirAll(s.schedulesDir(), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.schedulesDir(), sc.ScheduleID+".json"), b)
+}
+
+// loadSchedules reads the schedules under data_dir and works out when each is
+// next due from now.
+func (s *Service) loadSchedules() {
+	s.schedules = map[string]*schedule{}
+	entries, err := os.ReadDir(s.schedulesDir())
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.schedulesDir(), e.Name()))
+		if err != nil {
+			continue
+		}
+		sc := &schedule{}
+		if err := json.Unmarshal(b, sc); err != nil || sc.ScheduleID == "" {
+			continue
+		}
+		if sc.expr, err = parseCron(sc.Cron); err != nil {
+			continue
+		}
+		if sc.loc, err = time.LoadLocation(sc.Timezone); err != nil {
+			continue
+		}
+		sc.setNext(sc.expr.next(now.In(sc.loc)))
+		s.schedules[sc.ScheduleID] = sc
+	}
+}
+
+// scheduleLoop creates the execs of schedules as they fall due.
+func (s *Service) scheduleLoop() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.runDueSchedules(now)
+	}
+}
+
+// runDueSchedules creates the execs of the schedules due at now. schedMu is only
+// held to pick them and to record the outcome: submitting an exec can take a
+// while (e.g. retention cleanup) and must not hold up the schedule handlers.
+func (s *Service) runDueSchedules(now time.Time) {
+	type dueRun struct {
+		sc  *schedule
+		req execRequest
+	}
+	var due []dueRun
+	s.schedMu.Lock()
+	for _, sc := range s.schedules {
+		if sc.next.IsZero() || now.Before(sc.next) {
+			continue
+		}
+		req := sc.Exec
+		req.scheduleID = sc.ScheduleID
+		due = append(due, dueRun{sc, req})
+		sc.setNext(sc.expr.next(now.In(sc.loc)))
+	}
+	s.schedMu.Unlock()
+
+	for _, run := range due {
+		err := s.checkExecRequest(&run.req)
+		if err == nil {
+			err = s.queue.checkDemand(run.req.Resources)
+		}
+		var meta execMeta
+		if err == nil {
+			meta, err = s.submitExec(run.req)
+		}
+		s.schedMu.Lock()
+		sc := run.sc
+		sc.LastRunAt = now.UTC().Format(time.RFC3339Nano)
+		sc.LastError = ""
+		if err != nil {
+			sc.LastError = err.Error()
+		} else {
+			sc.LastExecID = meta.ExecID
+		}
+		// A schedule deleted meanwhile stays deleted.
+		if s.schedules[sc.ScheduleID] == sc {
+			_ = s.writeSchedule(sc)
+		}
+		s.schedMu.Unlock()
+	}
+}
+
+// handleScheduleCreate serves POST /v1/schedules with body
+// {"cron": "0 2 * * *", "timezone": "Europe/Berlin", "exec": {...}}, where exec
+// is a request as for POST /v1/exec.
+func (s *Service) handleScheduleCreate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Cron     string      `json:"cron"`
+		Timezone string      `json:"timezone"`
+		Exec     execRequest `json:"exec"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	expr, err := parseCron(body.Cron)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "cron: "+err.Error())
+		return
+	}
+	loc, err := time.LoadLocation(strings.TrimSpace(body.Timezone))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "unknown timezone")
+		return
+	}
+	if len(body.Exec.DependsOn) > 0 || body.Exec.NotBefore != "" {
+		writeErr(w, http.StatusBadRequest, "depends_on and not_before are not supported in schedules")
+		return
+	}
+	req := body.Exec
+	if err := s.checkExecRequest(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "exec: "+err.Error())
+		return
+	}
+	if err := s.queue.checkDemand(req.Resources); err != nil {
+		writeErr(w, http.StatusBadRequest, "exec: "+err.Error())
+		return
+	}
+	scheduleID, err := id.New()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to generate schedule_id")
+		return
+	}
+	now := time.Now()
+	sc := &schedule{
+		ScheduleID: scheduleID,
+		Cron:       strings.TrimSpace(body.Cron),
+		Timezone:   strings.TrimSpace(body.Timezone),
+		Exec:       req,
+		CreatedAt:  now.UTC().Format(time.RFC3339Nano),
+		expr:       expr,
+		loc:        loc,
+	}
+	sc.setNext(expr.next(now.In(loc)))
+	if sc.next.IsZero() {
+		writeErr(w, http.StatusBadRequest, "cron: expression never matches")
+		return
+	}
+
+	s.schedMu.Lock()
+	defer s.schedMu.Unlock()
+	if err := s.writeSchedule(sc); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to write schedule")
+		return
+	}
+	s.schedules[sc.ScheduleID] = sc
+	_ = jsonutil.WriteJSON(w, sc)
+}
+
+// handleScheduleList serves GET /v1/schedules, oldest first.
+func (s *Service) handleScheduleList(w http.ResponseWriter, r *http.Request) {
+	s.schedMu.Lock()
+	list := make([]schedule, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		list = append(list, *sc)
+	}
+	s.schedMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt < list[j].CreatedAt
+		}
+		return list[i].ScheduleID < list[j].ScheduleID
+	})
+	_ = jsonutil.WriteJSON(w, map[string]any{"schedules": list})
+}
+
+func (s *Service) handleScheduleGet(w http.ResponseWriter, r *http.Request) {
+	s.schedMu.Lock()
+	sc, ok := s.schedules[r.PathValue("id")]
+	var out schedule
+	if ok {
+		out = *sc
+	}
+	s.schedMu.Unlock()
+	if !ok {
+		writeErr(w, http.StatusNotFound, "schedule_id not found")
+		return
+	}
+	_ = jsonutil.WriteJSON(w, out)
+}
+
+// handleScheduleDelete serves DELETE /v1/schedules/{id}. Execs the schedule
+// created are kept.
+func (s *Service) handleScheduleDelete(w http.ResponseWriter, r *http.Request) {
+	scheduleID := r.PathValue("id")
+	s.schedMu.Lock()
+	defer s.schedMu.Unlock()
+	if _, ok := s.schedules[scheduleID]; !ok {
+		writeErr(w, http.StatusNotFound, "schedule_id not found")
+		return
+	}
+	err := os.Remove(filepath.Join(s.schedulesDir(), scheduleID+".json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeErr(w, http.StatusInternalServerError, "failed to delete schedule")
+		return
+	}
+	delete(s.schedules, scheduleID)
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"schedule_id": scheduleID,
+		"deleted":     true,
+	})
+}
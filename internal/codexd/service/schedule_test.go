@@ -0,0 +1,120 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecNotBefore(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	notBefore := time.Now().Add(time.Second).UTC().Format(time.RFC3339)
+	execID := startExecWithBody(t, h, map[string]any{"cmd": "echo later", "not_before": notBefore})
+	var meta map[string]any
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+execID, nil), &meta); err != nil {
+		t.Fatalf("invalid meta: %v", err)
+	}
+	if meta["status"] != "waiting" || meta["not_before"] != notBefore {
+		t.Fatalf("meta = %v, want waiting until %s", meta, notBefore)
+	}
+	meta = waitFinished(t, h, execID, 5*time.Second)
+	started, err := time.Parse(time.RFC3339Nano, meta["started_at"].(string))
+	if want, _ := time.Parse(time.RFC3339, notBefore); err != nil || started.Before(want) {
+		t.Fatalf("started_at = %v, want not before %s", meta["started_at"], notBefore)
+	}
+
+	for path, body := range map[string]string{
+		"/v1/exec":     `{"cmd":"true","not_before":"tomorrow"}`,
+		"/v1/exec/run": `{"cmd":"true","not_before":"` + notBefore + `"}`,
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example"+path, strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("POST %s %s => %d, want 400", path, body, rr.Code)
+		}
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	for _, body := range []string{
+		`{"cron":"0 2 * *","exec":{"cmd":"true"}}`,
+		`{"cron":"61 * * * *","exec":{"cmd":"true"}}`,
+		`{"cron":"0 0 30 2 *","exec":{"cmd":"true"}}`,
+		`{"cron":"@daily","timezone":"Mars/Olympus","exec":{"cmd":"true"}}`,
+		`{"cron":"@daily","exec":{"cmd":""}}`,
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/schedules", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("POST /v1/schedules %s => %d, want 400", body, rr.Code)
+		}
+	}
+
+	var nightly map[string]any
+	if err := json.Unmarshal(do(t, h, "POST", "/v1/schedules", []byte(`{"cron":"30 2 * * mon-fri","timezone":"UTC","exec":{"cmd":"echo nightly"}}`)), &nightly); err != nil {
+		t.Fatalf("invalid schedule: %v", err)
+	}
+	next, err := time.Parse(time.RFC3339, nightly["next_run_at"].(string))
+	if err != nil || next.Hour() != 2 || next.Minute() != 30 || next.Weekday() == time.Saturday || next.Weekday() == time.Sunday || !next.After(time.Now()) {
+		t.Fatalf("next_run_at = %v, want the next weekday at 02:30 UTC", nightly["next_run_at"])
+	}
+
+	var every map[string]any
+	if err := json.Unmarshal(do(t, h, "POST", "/v1/schedules", []byte(`{"cron":"@every 1s","exec":{"cmd":"echo scheduled"}}`)), &every); err != nil {
+		t.Fatalf("invalid schedule: %v", err)
+	}
+	everyID := every["schedule_id"].(string)
+	var execs struct {
+		Execs []map[string]any `json:"execs"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(execs.Execs) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("schedule created no exec")
+		}
+		time.Sleep(100 * time.Millisecond)
+		if err := json.Unmarshal(do(t, h, "GET", "/v1/exec?schedule_id="+everyID, nil), &execs); err != nil {
+			t.Fatalf("invalid list: %v", err)
+		}
+	}
+	do(t, h, "DELETE", "/v1/schedules/"+everyID, nil)
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/exec?schedule_id="+everyID, nil), &execs); err != nil {
+		t.Fatalf("invalid list: %v", err)
+	}
+	for _, e := range execs.Execs {
+		meta := waitFinished(t, h, e["exec_id"].(string), 5*time.Second)
+		if meta["schedule_id"] != everyID {
+			t.Fatalf("exec = %v, want schedule_id %s", meta, everyID)
+		}
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example/v1/schedules/"+everyID, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted schedule => %d, want 404", rr.Code)
+	}
+
+	// Schedules survive a restart.
+	h = service.New(cfg).Handler()
+	var list struct {
+		Schedules []map[string]any `json:"schedules"`
+	}
+	if err := json.Unmarshal(do(t, h, "GET", "/v1/schedules", nil), &list); err != nil {
+		t.Fatalf("invalid list: %v", err)
+	}
+	if len(list.Schedules) != 1 || list.Schedules[0]["schedule_id"] != nightly["schedule_id"] || list.Schedules[0]["next_run_at"] != nightly["next_run_at"] {
+		t.Fatalf("schedules after restart = %v, want only %v", list.Schedules, nightly)
+	}
+}
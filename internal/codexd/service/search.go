@@ -0,0 +1,288 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchMatches = 100
+	maxSearchMatches     = 10000
+	maxSearchContext     = 100
+)
+
+// searchQuery is a parsed logs search request.
+type searchQuery struct {
+	pattern    *regexp.Regexp
+	stream     string // stdout, stderr or both
+	context    int    // lines before and after each match
+	maxMatches int
+}
+
+func parseSearchQuery(r *http.Request) (searchQuery, error) {
+	v := r.URL.Query()
+	q := searchQuery{stream: v.Get("stream"), maxMatches: defaultSearchMatches}
+	if v.Get("pattern") == "" {
+		return q, errors.New("pattern is required")
+	}
+	re, err := regexp.Compile(v.Get("pattern"))
+	if err != nil {
+		return q, errors.New("invalid pattern: " + err.Error())
+	}
+	q.pattern = re
+	if q.stream == "" {
+		q.stream = "both"
+	}
+	if q.stream != "stdout" && q.stream != "stderr" && q.stream != "both" {
+		return q, errors.New("stream must be stdout, stderr or both")
+	}
+	if s := v.Get("context"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxSearchContext {
+			return q, errors.New("context must be 0-" + strconv.Itoa(maxSearchContext))
+		}
+		q.context = n
+	}
+	if s := v.Get("max_matches"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return q, errors.New("max_matches must be > 0")
+		}
+		q.maxMatches = min(n, maxSearchMatches)
+	}
+	return q, nil
+}
+
+// handleExecLogSearch serves GET /v1/exec/{id}/logs/search: it scans the logs
+// of one exec on disk and streams a match event per matching line, then an end
+// event with the number of matches.
+func (s *Service) handleExecLogSearch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	if _, err := os.Stat(execDir); err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ew, err := newEventWriter(w)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	n, err := searchExecLogs(execDir, id, q, q.maxMatches, ew.Write)
+	if err != nil {
+		return
+	}
+	_ = ew.Write(map[string]any{
+		"type":      "end",
+		"exec_id":   id,
+		"matches":   n,
+		"truncated": n == q.maxMatches,
+	})
+}
+
+// handleLogSearch serves GET /v1/exec/logs/search: the same search across
+// every retained exec that passes the exec list filters, newest first, up to
+// max_matches in all.
+func (s *Service) handleLogSearch(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := parseExecListFilter(r.URL.Query())
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	metas, err := s.listExecMetas()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list execs")
+		return
+	}
+	ew, err := newEventWriter(w)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	total, searched := 0, 0
+	for _, meta := range metas {
+		if total == q.maxMatches {
+			break
+		}
+		if !filter.match(meta) {
+			continue
+		}
+		execDir := filepath.Join(s.cfg.DataDir, "exec", meta.ExecID)
+		n, err := searchExecLogs(execDir, meta.ExecID, q, q.maxMatches-total, ew.Write)
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			// Removed by retention meanwhile.
+			continue
+		}
+		total += n
+		searched++
+	}
+	_ = ew.Write(map[string]any{
+		"type":           "end",
+		"matches":        total,
+		"execs_searched": searched,
+		"truncated":      total == q.maxMatches,
+	})
+}
+
+// searchExecLogs searches the logs of an exec, those of its last attempt if it
+// has a retry policy, passing on up to limit match events, and returns how many
+// it passed on.
+func searchExecLogs(execDir, execID string, q searchQuery, limit int, emit func(any) error) (int, error) {
+	// Read meta before the logs: once it is terminal, they are complete.
+	meta, err := readMeta(execDir)
+	finished := err != nil || isTerminalStatus(meta.Status)
+	return searchLogs(logDir(execDir, meta), execID, q, limit, finished, emit)
+}
+
+// searchLogs searches the logs in dir, which are complete if finished. Their
+// index is read along with the lines, so a search holds neither in memory.
+func searchLogs(dir, execID string, q searchQuery, limit int, finished bool, emit func(any) error) (int, error) {
+	index := openLogIndex(dir)
+	starts := map[string]int64{q.stream: 0}
+	if q.stream == "both" {
+		starts = map[string]int64{"stdout": 0, "stderr": 0}
+	}
+	ls := &logSearch{
+		q:       q,
+		execID:  execID,
+		limit:   limit,
+		emit:    emit,
+		numbers: map[string]int64{"stdout": 1, "stderr": 1},
+	}
+	err := newLogMerger(dir, index, starts, 1).read(nil, finished, ls.line)
+	if err == nil || errors.Is(err, errLogLimit) {
+		err = ls.flush()
+	}
+	return ls.matches, err
+}
+
+// logSearch matches the lines of one exec as they are read, in the order of
+// the logs searched, and passes on each match once its context is complete.
+type logSearch struct {
+	q       searchQuery
+	execID  string
+	limit   int
+	emit    func(any) error
+	numbers map[string]int64 // number of the next line per stream; 0 past dropped output
+	before  []map[string]any // the last lines read, up to the context
+	open    []*logSearchMatch
+	matches int
+}
+
+// logSearchMatch is a match waiting for its lines of context after it.
+type logSearchMatch struct {
+	event map[string]any
+	after []map[string]any
+}
+
+func (s *logSearch) line(l logLine) error {
+	if l.dropped > 0 {
+		// Lines are not counted, nor context taken, across dropped output.
+		s.numbers[l.stream] = 0
+		s.before = nil
+		return s.flush()
+	}
+	number := s.numbers[l.stream]
+	if number > 0 && !l.cut {
+		s.numbers[l.stream]++
+	}
+	text := strings.TrimSuffix(string(l.text), "\r")
+	var ctx map[string]any
+	if s.q.context > 0 {
+		ctx = map[string]any{"stream": l.stream, "line": text}
+		if number > 0 {
+			ctx["line_number"] = number
+		}
+		for _, m := range s.open {
+			m.after = append(m.after, ctx)
+		}
+		// Earlier matches complete first.
+		for len(s.open) > 0 && len(s.open[0].after) == s.q.context {
+			m := s.open[0]
+			s.open = s.open[1:]
+			if err := s.pass(m); err != nil {
+				return err
+			}
+		}
+	}
+	if s.matches < s.limit && s.q.pattern.MatchString(text) {
+		s.matches++
+		offset := l.next - int64(len(l.text))
+		if !l.cut {
+			offset--
+		}
+		ev := map[string]any{
+			"type":    "match",
+			"exec_id": s.execID,
+			"stream":  l.stream,
+			"offset":  offset,
+			"line":    text,
+		}
+		if number > 0 {
+			ev["line_number"] = number
+		}
+		if l.seq > 0 {
+			ev["seq"] = l.seq
+			ev["ts"] = l.at.Format(time.RFC3339Nano)
+		}
+		m := &logSearchMatch{event: ev}
+		if s.q.context > 0 {
+			ev["before"] = append([]map[string]any{}, s.before...)
+			s.open = append(s.open, m)
+		} else if err := s.pass(m); err != nil {
+			return err
+		}
+	}
+	if s.q.context > 0 {
+		s.before = append(s.before, ctx)
+		if len(s.before) > s.q.context {
+			s.before = s.before[1:]
+		}
+	}
+	if s.matches == s.limit && len(s.open) == 0 {
+		return errLogLimit
+	}
+	return nil
+}
+
+// flush passes on the open matches with the context they have.
+func (s *logSearch) flush() error {
+	for len(s.open) > 0 {
+		m := s.open[0]
+		s.open = s.open[1:]
+		if err := s.pass(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *logSearch) pass(m *logSearchMatch) error {
+	if s.q.context > 0 {
+		after := m.after
+		if after == nil {
+			after = []map[string]any{}
+		}
+		m.event["after"] = after
+	}
+	return s.emit(m.event)
+}
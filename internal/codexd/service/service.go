@@ -20,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"codex-runner/internal/codexd/config"
@@ -31,13 +32,37 @@ import (
 var Version = "dev"
 
 type Service struct {
-	cfg config.Config
+	cfg   config.Config
+	queue *execQueue
 
 	mu sync.Mutex
+	// compressKick wakes compressLogsLoop when an exec finishes.
+	compressKick chan struct{}
+
+	schedMu   sync.Mutex
+	schedules map[string]*schedule
+
+	// annotateMu serializes changes to the annotations of execs.
+	annotateMu sync.Mutex
+	// idempotencyMu serializes the creation of execs with an Idempotency-Key,
+	// and guards replays, the number of replayed exec runs following each exec.
+	idempotencyMu sync.Mutex
+	replays       map[string]int
 }
 
 func New(cfg config.Config) *Service {
-	return &Service{cfg: cfg}
+	s := &Service{
+		cfg:          cfg,
+		queue:        newExecQueue(cfg.MaxConcurrentExecs, cfg.Resources),
+		compressKick: make(chan struct{}, 1),
+	}
+	s.reconcile()
+	s.loadSchedules()
+	go s.scheduleLoop()
+	if after, ok := cfg.LogCompressDelay(); ok {
+		go s.compressLogsLoop(after)
+	}
+	return s
 }
 
 func (s *Service) Handler() http.Handler {
@@ -45,9 +70,26 @@ func (s *Service) Handler() http.Handler {
 	mux.HandleFunc("GET /health", s.handleHealth)
 	mux.HandleFunc("POST /v1/exec", s.auth(s.handleExecStart))
 	mux.HandleFunc("POST /v1/exec/run", s.auth(s.handleExecRun))
+	mux.HandleFunc("GET /v1/exec", s.auth(s.handleExecList))
+	mux.HandleFunc("DELETE /v1/exec", s.auth(s.handleExecDeleteSelected))
+	mux.HandleFunc("POST /v1/exec/cancel", s.auth(s.handleExecCancelSelected))
 	mux.HandleFunc("GET /v1/exec/{id}", s.auth(s.handleExecGet))
+	mux.HandleFunc("PATCH /v1/exec/{id}", s.auth(s.handleExecPatch))
+	mux.HandleFunc("DELETE /v1/exec/{id}", s.auth(s.handleExecDelete))
 	mux.HandleFunc("GET /v1/exec/{id}/logs", s.auth(s.handleExecLogs))
+	mux.HandleFunc("GET /v1/exec/{id}/logs/search", s.auth(s.handleExecLogSearch))
+	mux.HandleFunc("GET /v1/exec/logs/search", s.auth(s.handleLogSearch))
 	mux.HandleFunc("POST /v1/exec/{id}/cancel", s.auth(s.handleExecCancel))
+	mux.HandleFunc("POST /v1/exec/{id}/signal", s.auth(s.handleExecSignal))
+	mux.HandleFunc("POST /v1/exec/{id}/pause", s.auth(s.handleExecPause))
+	mux.HandleFunc("POST /v1/exec/{id}/resume", s.auth(s.handleExecResume))
+	mux.HandleFunc("POST /v1/exec/{id}/stdin", s.auth(s.handleExecStdin))
+	mux.HandleFunc("POST /v1/exec/{id}/rerun", s.auth(s.handleExecRerun))
+	mux.HandleFunc("GET /v1/exec/{id}/attach", s.auth(s.handleExecAttach))
+	mux.HandleFunc("POST /v1/schedules", s.auth(s.handleScheduleCreate))
+	mux.HandleFunc("GET /v1/schedules", s.auth(s.handleScheduleList))
+	mux.HandleFunc("GET /v1/schedules/{id}", s.auth(s.handleScheduleGet))
+	mux.HandleFunc("DELETE /v1/schedules/{id}", s.auth(s.handleScheduleDelete))
 	mux.HandleFunc("POST /v1/file/write", s.auth(s.handleFileWrite))
 	mux.HandleFunc("POST /v1/file/read", s.auth(s.handleFileRead))
 	mux.HandleFunc("POST /v1/sync/upload", s.auth(s.handleSyncUpload))
@@ -80,330 +122,530 @@ func (s *Service) handleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 type execRequest struct {
-	ProjectID string            `json:"project_id"`
-	Ref       string            `json:"ref"`
-	Cmd       string            `json:"cmd"`
-	Cwd       string            `json:"cwd"`
-	Env       map[string]string `json:"env"`
-	Shell     string            `json:"shell,omitempty"`
+	ProjectID string             `json:"project_id"`
+	Ref       string             `json:"ref"`
+	Cmd       string             `json:"cmd"`
+	Cwd       string             `json:"cwd"`
+	Env       map[string]string  `json:"env"`
+	Shell     string             `json:"shell,omitempty"`
+	Timeout   string             `json:"timeout,omitempty"` // Go duration, e.g. "90m"
+	Limits    *config.ExecLimits `json:"limits,omitempty"`
+	LogLimits *config.LogLimits  `json:"log_limits,omitempty"` // over the daemon's log_limits
+	Priority  int                `json:"priority,omitempty"`   // higher is dispatched first when queued
+	Resources map[string]int     `json:"resources,omitempty"`  // slots to reserve per pool, e.g. {"gpu": 2}
+	Stdin     bool               `json:"stdin,omitempty"`      // keep stdin open for POST /v1/exec/{id}/stdin, or the rest of the exec run body
+	PTY       bool               `json:"pty,omitempty"`        // run on a pseudo-terminal, see GET /v1/exec/{id}/attach
+	// DependsOn holds the exec back until these execs have finished, and
+	// DependsOnPolicy (success, any or failure) says how they must have ended.
+	DependsOn       []string `json:"depends_on,omitempty"`
+	DependsOnPolicy string   `json:"depends_on_policy,omitempty"`
+	// NotBefore (RFC3339) holds the exec back until then.
+	NotBefore string     `json:"not_before,omitempty"`
+	Retry     *execRetry `json:"retry,omitempty"`
+	// Name and Labels (key/value) describe the exec for people and selectors,
+	// see PATCH /v1/exec/{id}.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	timeout        time.Duration
+	notBefore      time.Time
+	rerunOf        string // exec_id of the exec this one reruns, see handleExecRerun
+	scheduleID     string // schedule_id of the schedule that created the exec
+	idempotencyKey string // Idempotency-Key header of the request, see idempotencyRecord
+}
+
+const (
+	statusWaiting  = "waiting" // for its not_before or depends_on, see awaitStart
+	statusQueued   = "queued"
+	statusRunning  = "running"
+	statusPaused   = "paused" // running, with its process group stopped; see pausedPath
+	statusFinished = "finished"
+	statusTimedOut = "timed_out"
+	statusLost     = "lost" // codexd restarted and the outcome is unknown
+	statusCanceled = "canceled"
+	statusSkipped  = "skipped" // its depends_on did not end as required
+)
+
+// isTerminalStatus reports whether an exec with this status will not change anymore.
+func isTerminalStatus(status string) bool {
+	return status == statusFinished || status == statusTimedOut || status == statusLost || status == statusCanceled || status == statusSkipped
 }
 
 type execMeta struct {
-	ExecID     string            `json:"exec_id"`
-	Status     string            `json:"status"` // running|finished
-	ProjectID  string            `json:"project_id,omitempty"`
-	Ref        string            `json:"ref,omitempty"`
-	Cmd        string            `json:"cmd"`
-	Cwd        string            `json:"cwd"`
-	Env        map[string]string `json:"env,omitempty"`
-	PID        int               `json:"pid,omitempty"`
-	StartedAt  string            `json:"started_at,omitempty"`
-	FinishedAt string            `json:"finished_at,omitempty"`
-	ExitCode   *int              `json:"exit_code,omitempty"`
-	Error      string            `json:"error,omitempty"`
-	Artifacts  json.RawMessage   `json:"artifacts,omitempty"`
-	Warn       string            `json:"warning,omitempty"`
+	ExecID          string              `json:"exec_id"`
+	Status          string              `json:"status"` // waiting|queued|running|paused|finished|timed_out|lost|canceled|skipped
+	ProjectID       string              `json:"project_id,omitempty"`
+	Ref             string              `json:"ref,omitempty"`
+	Cmd             string              `json:"cmd"`
+	Cwd             string              `json:"cwd"`
+	Env             map[string]string   `json:"env,omitempty"`
+	Timeout         string              `json:"timeout,omitempty"`
+	Limits          *appliedLimits      `json:"limits,omitempty"`
+	Priority        int                 `json:"priority,omitempty"`
+	Resources       map[string]int      `json:"resources,omitempty"`
+	Slots           map[string][]string `json:"slots,omitempty"` // reserved on dispatch, e.g. {"gpu": ["2", "3"]}
+	Stdin           bool                `json:"stdin,omitempty"`
+	PTY             bool                `json:"pty,omitempty"`
+	PID             int                 `json:"pid,omitempty"`
+	DependsOn       []string            `json:"depends_on,omitempty"`
+	DependsOnPolicy string              `json:"depends_on_policy,omitempty"`
+	NotBefore       string              `json:"not_before,omitempty"`
+	WaitingSince    string              `json:"waiting_since,omitempty"`
+	PausedAt        string              `json:"paused_at,omitempty"`
+	QueuedAt        string              `json:"queued_at,omitempty"`
+	StartedAt       string              `json:"started_at,omitempty"`
+	FinishedAt      string              `json:"finished_at,omitempty"`
+	ExitCode        *int                `json:"exit_code,omitempty"`
+	Error           string              `json:"error,omitempty"`
+	// Retry is the exec's retry policy and Attempts its runs so far; the exec's
+	// status, exit_code and logs are those of the last. RetryAt is when the next
+	// attempt starts, while it waits for it.
+	Retry    *execRetry    `json:"retry,omitempty"`
+	Attempts []execAttempt `json:"attempts,omitempty"`
+	RetryAt  string        `json:"retry_at,omitempty"`
+	// CanceledAt, CancelReason and CanceledBy describe the cancel of a
+	// canceled exec, see execCancel.
+	CanceledAt   string                 `json:"canceled_at,omitempty"`
+	CancelReason string                 `json:"cancel_reason,omitempty"`
+	CanceledBy   *execCanceler          `json:"canceled_by,omitempty"`
+	Artifacts    json.RawMessage        `json:"artifacts,omitempty"`
+	LogDropped   map[string]int64       `json:"log_dropped,omitempty"` // bytes dropped per stream by log_limits
+	LogSizes     map[string]logFileSize `json:"log_sizes,omitempty"`   // stdout, stderr and index, once gzipped
+	Usage        *execUsage             `json:"usage,omitempty"`       // once the cmd has run, see execUsage
+	Warn         string                 `json:"warning,omitempty"`
+	Provenance   *execProvenance        `json:"provenance,omitempty"`
+	// ParentExecID is the exec this one reruns, see POST /v1/exec/{id}/rerun.
+	ParentExecID string `json:"parent_exec_id,omitempty"`
+	// ScheduleID is the schedule that created the exec, see POST /v1/schedules.
+	ScheduleID string `json:"schedule_id,omitempty"`
+	// Name, Labels and Notes are as submitted or changed since, see
+	// execAnnotations.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Notes  []execNote        `json:"notes,omitempty"`
+	// IdempotencyKey is the Idempotency-Key the exec was created with.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// QueuePosition is filled in from the live queue when serving a queued exec.
+	QueuePosition int `json:"queue_position,omitempty"`
 }
 
 func (s *Service) handleExecStart(w http.ResponseWriter, r *http.Request) {
-	req, ok := decodeExecRequest(w, r)
+	req, ok := s.decodeExecRequest(w, r)
 	if !ok {
 		return
 	}
-	execID, execDir, meta, err := s.initExec(req)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
+	if req.idempotencyKey, ok = idempotencyKey(w, r); !ok {
 		return
 	}
-
-	go s.runExec(execDir, req, meta)
-
-	_ = jsonutil.WriteJSON(w, map[string]any{
-		"exec_id": execID,
-		"status":  "running",
-	})
+	s.startExec(w, req)
 }
 
-func (s *Service) handleExecRun(w http.ResponseWriter, r *http.Request) {
-	req, ok := decodeExecRequest(w, r)
-	if !ok {
+// startExec creates an exec, queues it and runs it in the background, and
+// answers with its exec_id and status. A request whose Idempotency-Key created
+// an exec before is answered with that exec, as it is now.
+func (s *Service) startExec(w http.ResponseWriter, req execRequest) {
+	if err := s.queue.checkDemand(req.Resources); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	execID, execDir, meta, err := s.initExec(req)
-	if err != nil {
+	meta, err := s.submitExec(req)
+	replay := errors.Is(err, errIdempotentReplay)
+	switch {
+	case replay:
+	case errors.Is(err, errIdempotencyMismatch):
+		writeErr(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
 		writeErr(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	out := map[string]any{
+		"exec_id": meta.ExecID,
+		"status":  meta.Status,
+	}
+	if meta.Status == statusQueued {
+		out["queue_position"] = s.queue.position(meta.ExecID)
+	}
+	if meta.ParentExecID != "" {
+		out["parent_exec_id"] = meta.ParentExecID
+	}
+	if replay {
+		out["idempotent_replay"] = true
+	}
+	_ = jsonutil.WriteJSON(w, out)
+}
 
-	ew, err := newEventWriter(w)
+// submitExec creates an exec and runs it in the background once it is due and
+// dispatched. It returns the exec's meta as submitted, or that of the exec
+// replayed with errIdempotentReplay.
+func (s *Service) submitExec(req execRequest) (execMeta, error) {
+	_, execDir, meta, err := s.initExec(req)
 	if err != nil {
-		writeErr(w, http.StatusInternalServerError, "streaming not supported")
-		return
-	}
-	if err := ew.Write(map[string]any{
-		"type":       "started",
-		"exec_id":    execID,
-		"status":     "running",
-		"started_at": meta.StartedAt,
-	}); err != nil {
-		return
+		return meta, err
 	}
 
-	s.runExecStreaming(r.Context(), execDir, req, meta, ew)
+	var ticket *queueTicket
+	if meta.Status != statusWaiting {
+		ticket, _ = s.enqueueExec(execDir, &meta, req)
+	}
+	go s.launchExec(execDir, meta, req, ticket)
+	return meta, nil
 }
 
-func (s *Service) resolveShell(requested string) string {
-	if requested != "" {
-		return requested
+// launchExec runs an exec once it is due and dispatched. A waiting exec is held
+// by awaitStart, then queued; any other comes with its ticket.
+func (s *Service) launchExec(execDir string, meta execMeta, req execRequest, ticket *queueTicket) {
+	if meta.Status == statusWaiting {
+		var ok bool
+		if meta, ok = s.awaitStart(execDir, meta, req); !ok {
+			_ = s.cleanupRetention()
+			return
+		}
+		ticket, _ = s.enqueueExec(execDir, &meta, req)
 	}
-	if s.cfg.DefaultShell != "" {
-		return s.cfg.DefaultShell
+	meta, ok := s.awaitDispatch(context.Background(), execDir, meta, ticket)
+	if !ok {
+		return
 	}
-	return "sh"
+	s.runExec(execDir, req, meta)
+	s.queue.release(ticket)
+	_ = s.cleanupRetention()
+	s.execFinished()
 }
 
-func (s *Service) runExec(execDir string, req execRequest, meta execMeta) {
-	ctx := context.Background()
-
-	shell := s.resolveShell(req.Shell)
-	if _, err := exec.LookPath(shell); err != nil {
-		meta.Status = "finished"
-		now := time.Now().UTC().Format(time.RFC3339Nano)
-		meta.FinishedAt = now
-		code := 127
-		meta.ExitCode = &code
-		meta.Error = fmt.Sprintf("shell not found: %s", shell)
-		_ = writeMeta(execDir, meta)
-		_ = writeExitCode(execDir, code)
+// handleExecRun serves POST /v1/exec/run. The body is the exec request; with
+// "stdin": true, the request ends at a newline and the rest of the body is the
+// exec's stdin, read while the exec runs and closed at the end of the body.
+func (s *Service) handleExecRun(w http.ResponseWriter, r *http.Request) {
+	req, stdin, ok := s.decodeExecRequestBody(w, r)
+	if !ok {
 		return
 	}
-
-	workDir, cleanupWorktree, err := s.prepareWorkdir(ctx, execDir, req.ProjectID, req.Ref)
-	if err != nil {
-		meta.Status = "finished"
-		now := time.Now().UTC().Format(time.RFC3339Nano)
-		meta.FinishedAt = now
-		code := 127
-		meta.ExitCode = &code
-		meta.Error = err.Error()
-		_ = writeMeta(execDir, meta)
-		_ = writeExitCode(execDir, code)
+	if len(req.DependsOn) > 0 || req.NotBefore != "" {
+		writeErr(w, http.StatusBadRequest, "depends_on and not_before are only supported by POST /v1/exec")
 		return
 	}
-	if cleanupWorktree != nil {
-		defer cleanupWorktree()
+	if req.idempotencyKey, ok = idempotencyKey(w, r); !ok {
+		return
 	}
-
-	cwd, err := s.resolveCwd(workDir, req.ProjectID, req.Cwd)
-	if err != nil {
-		meta.Status = "finished"
-		now := time.Now().UTC().Format(time.RFC3339Nano)
-		meta.FinishedAt = now
-		code := 126
-		meta.ExitCode = &code
-		meta.Error = err.Error()
-		_ = writeMeta(execDir, meta)
-		_ = writeExitCode(execDir, code)
+	if err := s.queue.checkDemand(req.Resources); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	execID, execDir, meta, err := s.initExec(req)
+	replay := errors.Is(err, errIdempotentReplay)
+	for replay && !s.attachReplay(req, execID) {
+		// Its exec was stopped when its client went away: the key is free again.
+		execID, execDir, meta, err = s.initExec(req)
+		replay = errors.Is(err, errIdempotentReplay)
+	}
+	switch {
+	case replay:
+		defer s.detachReplay(execID)
+	case errors.Is(err, errIdempotencyMismatch):
+		writeErr(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	stdoutPath := filepath.Join(execDir, "stdout.log")
-	stderrPath := filepath.Join(execDir, "stderr.log")
-	stdoutFile, err := os.OpenFile(stdoutPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	ew, err := newEventWriter(w)
 	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "streaming not supported")
 		return
 	}
-	defer stdoutFile.Close()
-	stderrFile, err := os.OpenFile(stderrPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
+	if replay {
+		s.replayRun(r.Context(), execDir, meta, ew)
 		return
 	}
-	defer stderrFile.Close()
-
-	cmd := exec.CommandContext(ctx, shell, "-lc", req.Cmd)
-	cmd.Dir = cwd
-	cmd.Stdout = stdoutFile
-	cmd.Stderr = stderrFile
-	configureCmd(cmd)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "PYTHONUNBUFFERED=1")
-	for k, v := range req.Env {
-		cmd.Env = append(cmd.Env, k+"="+v)
+	// When the client goes away, the exec is stopped, unless a replay of the
+	// request follows it: ctx ends only then.
+	var leaveOnce sync.Once
+	stop := false
+	leave := func() bool {
+		leaveOnce.Do(func() { stop = s.releaseRun(req, execID) })
+		return stop
 	}
-
-	if err := cmd.Start(); err != nil {
-		meta.Status = "finished"
-		now := time.Now().UTC().Format(time.RFC3339Nano)
-		meta.FinishedAt = now
-		code := 127
-		meta.ExitCode = &code
-		meta.Error = err.Error()
-		_ = writeMeta(execDir, meta)
-		_ = writeExitCode(execDir, code)
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	go func() {
+		select {
+		case <-r.Context().Done():
+			if ctx.Err() == nil && leave() {
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	if req.Stdin {
+		// The events go out while the stdin still comes in. A client that goes
+		// away cuts the body short, which ends r.Context().
+		rc := http.NewResponseController(w)
+		_ = rc.EnableFullDuplex()
+		copied := make(chan struct{})
+		go func() {
+			defer close(copied)
+			_ = appendRunStdin(execDir, stdin)
+		}()
+		defer func() {
+			// Stop reading input the exec no longer needs.
+			_ = rc.SetReadDeadline(time.Now())
+			<-copied
+		}()
+	}
+	ticket, queued := s.enqueueExec(execDir, &meta, req)
+	if queued {
+		// A client already gone is noticed by awaitDispatch.
+		_ = ew.Write(map[string]any{
+			"type":           "queued",
+			"exec_id":        execID,
+			"status":         statusQueued,
+			"queued_at":      meta.QueuedAt,
+			"queue_position": s.queue.position(execID),
+		})
+	}
+	meta, ok = s.awaitDispatch(ctx, execDir, meta, ticket)
+	if !ok {
+		_ = ew.Write(finishedEvent(meta))
 		return
 	}
+	defer func() {
+		s.queue.release(ticket)
+		_ = s.cleanupRetention()
+		s.execFinished()
+	}()
+	_ = ew.Write(map[string]any{
+		"type":       "started",
+		"exec_id":    execID,
+		"status":     statusRunning,
+		"started_at": meta.StartedAt,
+	})
 
-	meta.PID = cmd.Process.Pid
-	_ = writeMeta(execDir, meta)
-	_ = writePID(execDir, meta.PID)
+	s.streamExec(r.Context(), execDir, req, meta, ew, leave)
+}
 
-	err = cmd.Wait()
-	_ = stdoutFile.Sync()
-	_ = stderrFile.Sync()
-	exitCode := 0
-	if err != nil {
-		if cmd.ProcessState != nil {
-			exitCode = cmd.ProcessState.ExitCode()
-			if exitCode < 0 {
-				exitCode = 1
+// enqueueExec hands the exec to the queue and marks it queued when no run slot or
+// requested resources are free (max_concurrent_execs, resources).
+func (s *Service) enqueueExec(execDir string, meta *execMeta, req execRequest) (*queueTicket, bool) {
+	ticket, queued := s.queue.enqueue(meta.ExecID, req.Priority, req.Resources)
+	if !queued {
+		return ticket, false
+	}
+	meta.Status = statusQueued
+	meta.QueuedAt = meta.StartedAt
+	meta.StartedAt = ""
+	_ = writeMeta(execDir, *meta)
+	return ticket, true
+}
+
+// awaitDispatch blocks until the exec holds a run slot and its resources, and
+// records them in meta. It returns false with the final meta if the exec was
+// canceled, or ctx ended, before it started.
+func (s *Service) awaitDispatch(ctx context.Context, execDir string, meta execMeta, ticket *queueTicket) (execMeta, bool) {
+	if err := s.queue.wait(ctx, ticket); err != nil {
+		if errors.Is(err, errQueueCanceled) {
+			// handleExecCancel already finalized the meta.
+			if canceled, readErr := readMeta(execDir); readErr == nil {
+				return canceled, false
 			}
-		} else {
-			exitCode = 1
+			return meta, false
 		}
+		// The client of an exec run went away.
+		c := execCancel{CanceledAt: time.Now().UTC().Format(time.RFC3339Nano), Reason: "client disconnected while queued"}
+		return finalizeMeta(execDir, c.apply(meta), statusCanceled, 1, errQueueCanceled), false
 	}
-	now := time.Now().UTC().Format(time.RFC3339Nano)
-	meta.Status = "finished"
-	meta.FinishedAt = now
-	meta.ExitCode = &exitCode
-	if err != nil {
-		meta.Error = err.Error()
+	if len(ticket.slots) > 0 {
+		meta.Slots = ticket.slots
 	}
-	if artifacts, warn := collectArtifacts(execDir, cwd); len(artifacts) > 0 {
-		meta.Artifacts = artifacts
-		meta.Warn = warn
-	} else if warn != "" {
-		meta.Warn = warn
+	if meta.Status == statusQueued {
+		meta.Status = statusRunning
+		meta.StartedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	} else if meta.Slots == nil {
+		return meta, true
 	}
 	_ = writeMeta(execDir, meta)
-	_ = writeExitCode(execDir, exitCode)
+	return meta, true
 }
 
-func (s *Service) runExecStreaming(ctx context.Context, execDir string, req execRequest, meta execMeta, ew *eventWriter) {
-	shell := s.resolveShell(req.Shell)
-	if _, err := exec.LookPath(shell); err != nil {
-		finished := s.finalizeMeta(execDir, meta, 127, fmt.Errorf("shell not found: %s", shell))
-		_ = ew.Write(finishedEvent(finished))
-		return
+// resourceEnv exports the exec's reserved slots, e.g. CUDA_VISIBLE_DEVICES=2,3.
+func (s *Service) resourceEnv(slots map[string][]string) []string {
+	var env []string
+	for pool, names := range slots {
+		env = append(env, s.cfg.ResourceEnvVar(pool)+"="+strings.Join(names, ","))
 	}
+	sort.Strings(env)
+	return env
+}
 
-	workDir, cleanupWorktree, err := s.prepareWorkdir(ctx, execDir, req.ProjectID, req.Ref)
-	if err != nil {
-		finished := s.finalizeMeta(execDir, meta, 127, err)
-		_ = ew.Write(finishedEvent(finished))
-		return
+func (s *Service) resolveShell(requested string) string {
+	if requested != "" {
+		return requested
 	}
-	if cleanupWorktree != nil {
-		defer cleanupWorktree()
+	if s.cfg.DefaultShell != "" {
+		return s.cfg.DefaultShell
 	}
+	return "sh"
+}
 
-	cwd, err := s.resolveCwd(workDir, req.ProjectID, req.Cwd)
-	if err != nil {
-		finished := s.finalizeMeta(execDir, meta, 126, err)
-		_ = ew.Write(finishedEvent(finished))
-		return
+// runExec prepares the exec's working directory and runs it under a supervisor
+// process, which owns the child and records the result. It returns the final meta.
+func (s *Service) runExec(execDir string, req execRequest, meta execMeta) execMeta {
+	ctx := context.Background()
+
+	shell := s.resolveShell(req.Shell)
+	if meta.Provenance != nil {
+		meta.Provenance.Shell = shell
+	}
+	if _, err := exec.LookPath(shell); err != nil {
+		return finalizeMeta(execDir, meta, statusFinished, 127, fmt.Errorf("shell not found: %s", shell))
 	}
 
-	stdoutPath := filepath.Join(execDir, "stdout.log")
-	stderrPath := filepath.Join(execDir, "stderr.log")
-	stdoutFile, err := os.OpenFile(stdoutPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	workDir, commit, cleanupWorktree, err := s.prepareWorkdir(ctx, execDir, req.ProjectID, req.Ref)
 	if err != nil {
-		finished := s.finalizeMeta(execDir, meta, 127, err)
-		_ = ew.Write(finishedEvent(finished))
-		return
+		return finalizeMeta(execDir, meta, statusFinished, 127, err)
 	}
-	defer stdoutFile.Close()
-	stderrFile, err := os.OpenFile(stderrPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+
+	cwd, err := s.resolveCwd(workDir, req.ProjectID, req.Cwd)
 	if err != nil {
-		finished := s.finalizeMeta(execDir, meta, 127, err)
-		_ = ew.Write(finishedEvent(finished))
-		return
+		if cleanupWorktree != nil {
+			cleanupWorktree()
+		}
+		return finalizeMeta(execDir, meta, statusFinished, 126, err)
 	}
-	defer stderrFile.Close()
 
-	cmd := exec.CommandContext(ctx, shell, "-lc", req.Cmd)
-	cmd.Dir = cwd
-	configureCmd(cmd)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "PYTHONUNBUFFERED=1")
+	spec := execSpec{
+		Shell:     shell,
+		Cmd:       req.Cmd,
+		Dir:       cwd,
+		Env:       []string{"PYTHONUNBUFFERED=1"},
+		Timeout:   req.Timeout,
+		StopGrace: s.cfg.StopGrace().String(),
+		Limits:    s.resolveLimits(req.Limits),
+		LogLimits: s.resolveLogLimits(req.LogLimits),
+		Stdin:     req.Stdin,
+		PTY:       req.PTY,
+		Retry:     req.Retry,
+	}
 	for k, v := range req.Env {
-		cmd.Env = append(cmd.Env, k+"="+v)
+		spec.Env = append(spec.Env, k+"="+v)
 	}
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		finished := s.finalizeMeta(execDir, meta, 127, err)
-		_ = ew.Write(finishedEvent(finished))
-		return
+	spec.Env = append(spec.Env, s.resourceEnv(meta.Slots)...)
+	if cleanupWorktree != nil {
+		spec.MirrorDir = s.projectMirrorDir(req.ProjectID)
 	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		finished := s.finalizeMeta(execDir, meta, 127, err)
-		_ = ew.Write(finishedEvent(finished))
-		return
+	if cgroupLimitsRequested(spec.Limits) {
+		parent, err := resolveCgroupParent(s.cfg.CgroupParent)
+		if err != nil {
+			spec.CgroupError = err.Error()
+		}
+		spec.CgroupParent = parent
 	}
-
-	if err := cmd.Start(); err != nil {
-		finished := s.finalizeMeta(execDir, meta, 127, err)
-		_ = ew.Write(finishedEvent(finished))
-		return
+	if meta.Provenance != nil {
+		// The supervisor inherits codexd's environment.
+		meta.Provenance.Commit = commit
+		meta.Provenance.EnvHash = envHash(append(os.Environ(), spec.Env...))
+		_ = writeMeta(execDir, meta)
 	}
 
-	meta.PID = cmd.Process.Pid
-	_ = writeMeta(execDir, meta)
-	_ = writePID(execDir, meta.PID)
-
-	streamErrs := make(chan error, 2)
-	go func() {
-		streamErrs <- streamToFileAndEvents(stdoutPipe, stdoutFile, "stdout", ew)
-	}()
-	go func() {
-		streamErrs <- streamToFileAndEvents(stderrPipe, stderrFile, "stderr", ew)
-	}()
-
-	waitErr := cmd.Wait()
-	firstStreamErr := <-streamErrs
-	secondStreamErr := <-streamErrs
-	streamErr := firstNonNil(firstStreamErr, secondStreamErr)
-
-	_ = stdoutFile.Sync()
-	_ = stderrFile.Sync()
-
-	exitCode := 0
-	if waitErr != nil {
-		if cmd.ProcessState != nil {
-			exitCode = cmd.ProcessState.ExitCode()
-			if exitCode < 0 {
-				exitCode = 1
-			}
-		} else {
-			exitCode = 1
+	cmd, err := s.startSupervisor(execDir, spec)
+	if err != nil {
+		if cleanupWorktree != nil {
+			cleanupWorktree()
 		}
+		return finalizeMeta(execDir, meta, statusFinished, 127, err)
 	}
+	return s.awaitSupervisor(execDir, cmd)
+}
 
-	finalErr := waitErr
-	if finalErr == nil {
-		finalErr = streamErr
-	}
-	finished := s.finalizeMeta(execDir, meta, exitCode, finalErr)
-	_ = ew.Write(finishedEvent(finished))
+func (s *Service) decodeExecRequest(w http.ResponseWriter, r *http.Request) (execRequest, bool) {
+	req, _, ok := s.decodeExecRequestBody(w, r)
+	return req, ok
 }
 
-func decodeExecRequest(w http.ResponseWriter, r *http.Request) (execRequest, bool) {
+// decodeExecRequestBody decodes the exec request at the start of the body of r
+// and returns the rest of the body, after the newline that ends the request.
+func (s *Service) decodeExecRequestBody(w http.ResponseWriter, r *http.Request) (execRequest, io.Reader, bool) {
 	var req execRequest
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
 		writeErr(w, http.StatusBadRequest, "invalid json body")
-		return execRequest{}, false
+		return execRequest{}, nil, false
+	}
+	if err := s.checkExecRequest(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return execRequest{}, nil, false
+	}
+	rest := bufio.NewReader(io.MultiReader(dec.Buffered(), r.Body))
+	if b, err := rest.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = rest.Discard(1)
 	}
+	return req, rest, true
+}
+
+// checkExecRequest validates an exec request and fills in what is derived from it.
+func (s *Service) checkExecRequest(req *execRequest) error {
 	req.Cmd = strings.TrimSpace(req.Cmd)
 	if req.Cmd == "" {
-		writeErr(w, http.StatusBadRequest, "cmd is required")
-		return execRequest{}, false
+		return errors.New("cmd is required")
+	}
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 {
+			return errors.New("timeout must be a positive duration like 90m")
+		}
+		req.timeout = d
+	}
+	if req.NotBefore != "" {
+		t, err := time.Parse(time.RFC3339, req.NotBefore)
+		if err != nil {
+			return errors.New("not_before must be RFC3339")
+		}
+		req.notBefore = t
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := checkExecName(req.Name); err != nil {
+		return err
+	}
+	if err := checkLabels(req.Labels); err != nil {
+		return err
+	}
+	if req.PTY && !ptySupported {
+		return errors.New("pty is not supported on " + runtime.GOOS)
+	}
+	if req.PTY && req.Stdin {
+		return errors.New("pty and stdin are mutually exclusive; send input with attach")
+	}
+	if req.Limits != nil {
+		if err := req.Limits.Validate(); err != nil {
+			return errors.New("limits: " + err.Error())
+		}
 	}
-	return req, true
+	if req.LogLimits != nil {
+		if err := s.resolveLogLimits(req.LogLimits).Validate(); err != nil {
+			return errors.New("log_limits: " + err.Error())
+		}
+	}
+	if req.Retry != nil {
+		if req.PTY {
+			return errors.New("retry is not supported with pty")
+		}
+		if err := checkRetry(req.Retry); err != nil {
+			return err
+		}
+	}
+	return s.checkDependencies(req)
 }
 
 func (s *Service) initExec(req execRequest) (string, string, execMeta, error) {
+	if req.idempotencyKey != "" {
+		s.idempotencyMu.Lock()
+		defer s.idempotencyMu.Unlock()
+		if execDir, meta, err := s.replayedExec(req); err != nil {
+			return meta.ExecID, execDir, meta, err
+		}
+	}
 	execID, err := id.New()
 	if err != nil {
 		return "", "", execMeta{}, errors.New("failed to generate exec_id")
@@ -413,32 +655,70 @@ func (s *Service) initExec(req execRequest) (string, string, execMeta, error) {
 		return "", "", execMeta{}, errors.New("failed to create exec dir")
 	}
 	meta := execMeta{
-		ExecID:    execID,
-		Status:    "running",
-		ProjectID: req.ProjectID,
-		Ref:       req.Ref,
-		Cmd:       req.Cmd,
-		Cwd:       req.Cwd,
-		Env:       req.Env,
-		StartedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		ExecID:          execID,
+		Status:          statusRunning,
+		ProjectID:       req.ProjectID,
+		Ref:             req.Ref,
+		Cmd:             req.Cmd,
+		Cwd:             req.Cwd,
+		Env:             req.Env,
+		Timeout:         req.Timeout,
+		Priority:        req.Priority,
+		Resources:       req.Resources,
+		Stdin:           req.Stdin,
+		PTY:             req.PTY,
+		StartedAt:       time.Now().UTC().Format(time.RFC3339Nano),
+		DependsOn:       req.DependsOn,
+		DependsOnPolicy: req.DependsOnPolicy,
+		NotBefore:       req.NotBefore,
+		Retry:           req.Retry,
+		Provenance: &execProvenance{
+			DaemonVersion: Version,
+			Request:       req,
+		},
+		ParentExecID:   req.rerunOf,
+		ScheduleID:     req.scheduleID,
+		Name:           req.Name,
+		Labels:         req.Labels,
+		IdempotencyKey: req.idempotencyKey,
+	}
+	meta.Provenance.Hostname, _ = os.Hostname()
+	if len(req.DependsOn) > 0 || time.Now().Before(req.notBefore) {
+		meta.Status = statusWaiting
+		meta.WaitingSince, meta.StartedAt = meta.StartedAt, ""
+	}
+	switch {
+	case req.Stdin && req.rerunOf != "":
+		if err := replayStdin(filepath.Join(s.cfg.DataDir, "exec", req.rerunOf), execDir); err != nil {
+			return "", "", execMeta{}, errors.New("failed to replay stdin")
+		}
+	case req.Stdin:
+		if err := os.WriteFile(stdinPath(execDir), nil, 0o644); err != nil {
+			return "", "", execMeta{}, errors.New("failed to create stdin")
+		}
 	}
 	if err := writeMeta(execDir, meta); err != nil {
 		return "", "", execMeta{}, errors.New("failed to write meta")
 	}
+	if req.idempotencyKey != "" {
+		if err := s.recordIdempotencyKey(req, execID); err != nil {
+			return "", "", execMeta{}, errors.New("failed to record Idempotency-Key")
+		}
+	}
 	_ = s.cleanupRetention()
 	return execID, execDir, meta, nil
 }
 
-func (s *Service) finalizeMeta(execDir string, meta execMeta, exitCode int, err error) execMeta {
+func finalizeMeta(execDir string, meta execMeta, status string, exitCode int, err error) execMeta {
 	now := time.Now().UTC().Format(time.RFC3339Nano)
-	meta.Status = "finished"
+	meta.Status = status
 	meta.FinishedAt = now
 	meta.ExitCode = &exitCode
 	if err != nil {
 		meta.Error = err.Error()
 	}
-	_ = writeMeta(execDir, meta)
 	_ = writeExitCode(execDir, exitCode)
+	_ = writeMeta(execDir, meta)
 	return meta
 }
 
@@ -456,43 +736,10 @@ func finishedEvent(meta execMeta) map[string]any {
 	if meta.Error != "" {
 		out["error"] = meta.Error
 	}
-	return out
-}
-
-func streamToFileAndEvents(src io.Reader, dst *os.File, stream string, ew *eventWriter) error {
-	reader := bufio.NewReader(src)
-	for {
-		chunk, err := reader.ReadString('\n')
-		if len(chunk) > 0 {
-			if _, werr := dst.WriteString(chunk); werr != nil {
-				return werr
-			}
-			line := strings.TrimSuffix(chunk, "\n")
-			line = strings.TrimSuffix(line, "\r")
-			if err := ew.Write(map[string]any{
-				"type":   "log",
-				"stream": stream,
-				"line":   line,
-			}); err != nil {
-				return err
-			}
-		}
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil
-			}
-			return err
-		}
-	}
-}
-
-func firstNonNil(errs ...error) error {
-	for _, err := range errs {
-		if err != nil {
-			return err
-		}
+	if meta.Usage != nil {
+		out["usage"] = meta.Usage
 	}
-	return nil
+	return out
 }
 
 type eventWriter struct {
@@ -532,125 +779,61 @@ func (s *Service) handleExecGet(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusNotFound, "exec_id not found")
 		return
 	}
+	if meta.Status == statusQueued {
+		meta.QueuePosition = s.queue.position(id)
+	}
 	_ = jsonutil.WriteJSON(w, meta)
 }
 
-func (s *Service) handleExecLogs(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
-	if _, err := os.Stat(execDir); err != nil {
-		writeErr(w, http.StatusNotFound, "exec_id not found")
-		return
-	}
-	stream := r.URL.Query().Get("stream")
-	if stream == "" {
-		stream = "stdout"
-	}
-	if stream != "stdout" && stream != "stderr" {
-		writeErr(w, http.StatusBadRequest, "stream must be stdout or stderr")
-		return
-	}
-	tailStr := r.URL.Query().Get("tail")
-	tailLinesStr := r.URL.Query().Get("tail_lines")
-	var maxBytes int64 = 2000
-	var maxLines int
-	if tailStr != "" {
-		if n, err := strconv.ParseInt(tailStr, 10, 64); err == nil && n >= 0 {
-			maxBytes = n
-		}
-	}
-	if tailLinesStr != "" {
-		n, err := strconv.Atoi(tailLinesStr)
-		if err != nil || n < 0 {
-			writeErr(w, http.StatusBadRequest, "tail_lines must be >= 0")
-			return
-		}
-		maxLines = n
-	}
-	sinceFilter, err := parseRFC3339(r.URL.Query().Get("since"))
-	if err != nil {
-		writeErr(w, http.StatusBadRequest, "since must be RFC3339")
-		return
-	}
-	untilFilter, err := parseRFC3339(r.URL.Query().Get("until"))
-	if err != nil {
-		writeErr(w, http.StatusBadRequest, "until must be RFC3339")
-		return
-	}
-	format := r.URL.Query().Get("format") // "" or "jsonl"
-	fullMode := r.URL.Query().Get("full") == "true"
-
-	path := filepath.Join(execDir, stream+".log")
-	var b []byte
-	if fullMode {
-		b, err = tail.ReadAll(path)
-	} else if maxLines > 0 {
-		b, err = tail.ReadTailLines(path, maxLines)
-	} else if tailStr != "" {
-		b, err = tail.ReadTailBytes(path, maxBytes)
-	} else if sinceFilter != nil || untilFilter != nil {
-		b, err = tail.ReadAll(path)
-	} else {
-		b, err = tail.ReadTailBytes(path, maxBytes)
-	}
-	if err != nil {
-		if os.IsNotExist(err) {
-			b = []byte{}
-		} else {
-			writeErr(w, http.StatusInternalServerError, "failed to read logs")
-			return
-		}
-	}
-	filtered := filterLogLinesByTime(bytes.Split(b, []byte{'\n'}), sinceFilter, untilFilter)
-	b = bytes.Join(filtered, []byte{'\n'})
-	if format != "jsonl" {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		_, _ = w.Write(b)
-		return
-	}
-	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
-	for _, line := range filtered {
-		if len(line) == 0 {
-			continue
-		}
-		_ = jsonutil.WriteJSON(w, map[string]any{
-			"type":   "log",
-			"stream": stream,
-			"line":   string(line),
-		})
-	}
-}
+// execStopGrace is how long a stopped exec gets between SIGTERM and SIGKILL,
+// unless cancel_grace says otherwise.
+const execStopGrace = 3 * time.Second
 
-func (s *Service) handleExecCancel(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
-	pid, err := readPID(execDir)
-	if err != nil {
-		if _, metaErr := readMeta(execDir); metaErr == nil {
-			_ = jsonutil.WriteJSON(w, map[string]any{
-				"canceled": false,
-				"reason":   "not started yet",
-			})
-			return
-		}
-		writeErr(w, http.StatusNotFound, "exec_id not found")
-		return
-	}
-	// Best-effort: SIGTERM then SIGKILL after timeout.
+// stopExecGroup terminates the exec's process group, escalating to a kill once grace elapses.
+func stopExecGroup(pid int, grace time.Duration) error {
 	if err := gracefulStopExec(pid); err != nil {
-		writeErr(w, http.StatusInternalServerError, "failed to signal process")
-		return
+		return err
 	}
-	deadline := time.Now().Add(3 * time.Second)
+	deadline := time.Now().Add(grace)
 	for time.Now().Before(deadline) {
 		if !processAlive(pid) {
-			_ = jsonutil.WriteJSON(w, map[string]any{"canceled": true})
-			return
+			return nil
 		}
 		time.Sleep(100 * time.Millisecond)
 	}
 	_ = forceStopExec(pid)
-	_ = jsonutil.WriteJSON(w, map[string]any{"canceled": true})
+	return nil
+}
+
+// execDeadline stops an exec's process group once its timeout elapses.
+type execDeadline struct {
+	timer *time.Timer
+	fired atomic.Bool
+}
+
+func startExecDeadline(pid int, timeout, grace time.Duration) *execDeadline {
+	if timeout <= 0 {
+		return nil
+	}
+	d := &execDeadline{}
+	d.timer = time.AfterFunc(timeout, func() {
+		d.fired.Store(true)
+		_ = stopExecGroup(pid, grace)
+	})
+	return d
+}
+
+// stop disarms the deadline and reports whether it had already fired.
+func (d *execDeadline) stop() bool {
+	if d == nil {
+		return false
+	}
+	d.timer.Stop()
+	return d.fired.Load()
+}
+
+func timeoutError(timeout time.Duration) error {
+	return fmt.Errorf("timed out after %s", timeout)
 }
 
 func (s *Service) cleanupRetention() error {
@@ -661,14 +844,14 @@ func (s *Service) cleanupRetention() error {
 	defer s.mu.Unlock()
 
 	execRoot := filepath.Join(s.cfg.DataDir, "exec")
-	_ = os.MkdirAll(execRoot, 0o755)
 	entries, err := os.ReadDir(execRoot)
 	if err != nil {
 		return err
 	}
 	type item struct {
-		name string
-		mod  time.Time
+		name   string
+		mod    time.Time
+		active bool
 	}
 	var items []item
 	for _, e := range entries {
@@ -679,30 +862,49 @@ func (s *Service) cleanupRetention() error {
 		if err != nil {
 			continue
 		}
-		items = append(items, item{name: e.Name(), mod: info.ModTime()})
+		it := item{name: e.Name(), mod: info.ModTime()}
+		// Order by submission time: meta.json rewrites bump the directory mtime.
+		if meta, err := readMeta(filepath.Join(execRoot, e.Name())); err == nil {
+			if t, err := time.Parse(time.RFC3339Nano, meta.listTime()); err == nil {
+				it.mod = t
+			}
+			it.active = !isTerminalStatus(meta.Status)
+		}
+		items = append(items, it)
 	}
 	if len(items) <= s.cfg.RetentionCount {
 		return nil
 	}
 	sort.Slice(items, func(i, j int) bool { return items[i].mod.Before(items[j].mod) })
-	toDelete := items[:len(items)-s.cfg.RetentionCount]
-	for _, it := range toDelete {
+	excess := len(items) - s.cfg.RetentionCount
+	for _, it := range items {
+		if excess == 0 {
+			break
+		}
+		// Queued and running execs are kept; they are pruned once they finish.
+		if it.active {
+			continue
+		}
 		_ = os.RemoveAll(filepath.Join(execRoot, it.name))
+		excess--
 	}
+	s.pruneIdempotencyKeys()
 	return nil
 }
 
-func (s *Service) prepareWorkdir(ctx context.Context, execDir, projectID, ref string) (string, func(), error) {
+// prepareWorkdir returns the directory an exec runs in and, for a project,
+// the commit its ref resolved to and the cleanup of its worktree.
+func (s *Service) prepareWorkdir(ctx context.Context, execDir, projectID, ref string) (string, string, func(), error) {
 	// No project context: run in home dir by default.
 	if projectID == "" {
 		home, err := os.UserHomeDir()
 		if err != nil {
-			return "", nil, err
+			return "", "", nil, err
 		}
-		return home, nil, nil
+		return home, "", nil, nil
 	}
 	if ref == "" {
-		return "", nil, errors.New("ref is required when project_id is set")
+		return "", "", nil, errors.New("ref is required when project_id is set")
 	}
 	var proj *config.Project
 	for i := range s.cfg.Projects {
@@ -712,38 +914,52 @@ func (s *Service) prepareWorkdir(ctx context.Context, execDir, projectID, ref st
 		}
 	}
 	if proj == nil {
-		return "", nil, fmt.Errorf("unknown project_id: %s", projectID)
-	}
-	mirrorDir := proj.MirrorDir
-	if mirrorDir == "" {
-		mirrorDir = filepath.Join(s.cfg.DataDir, "mirrors", projectID+".git")
+		return "", "", nil, fmt.Errorf("unknown project_id: %s", projectID)
 	}
+	mirrorDir := s.mirrorDir(*proj)
 	if err := os.MkdirAll(filepath.Dir(mirrorDir), 0o755); err != nil {
-		return "", nil, err
+		return "", "", nil, err
 	}
 
 	if _, err := os.Stat(mirrorDir); os.IsNotExist(err) {
 		if err := runGit(ctx, "", "clone", "--mirror", proj.RepoURL, mirrorDir); err != nil {
-			return "", nil, fmt.Errorf("git clone --mirror failed: %w", err)
+			return "", "", nil, fmt.Errorf("git clone --mirror failed: %w", err)
 		}
 	} else {
 		if err := runGit(ctx, mirrorDir, "fetch", "--prune"); err != nil {
-			return "", nil, fmt.Errorf("git fetch failed: %w", err)
+			return "", "", nil, fmt.Errorf("git fetch failed: %w", err)
 		}
 	}
 
 	commit, err := gitRevParse(ctx, mirrorDir, ref)
 	if err != nil {
-		return "", nil, err
+		return "", "", nil, err
 	}
 	workdir := filepath.Join(execDir, "workdir")
 	if err := runGit(ctx, mirrorDir, "worktree", "add", "--force", workdir, commit); err != nil {
-		return "", nil, fmt.Errorf("git worktree add failed: %w", err)
+		return "", "", nil, fmt.Errorf("git worktree add failed: %w", err)
 	}
 	cleanup := func() {
 		_ = runGit(context.Background(), mirrorDir, "worktree", "remove", "--force", workdir)
 	}
-	return workdir, cleanup, nil
+	return workdir, commit, cleanup, nil
+}
+
+func (s *Service) mirrorDir(proj config.Project) string {
+	if proj.MirrorDir != "" {
+		return proj.MirrorDir
+	}
+	return filepath.Join(s.cfg.DataDir, "mirrors", proj.ID+".git")
+}
+
+// projectMirrorDir returns the mirror of a configured project, or "" if projectID is unknown.
+func (s *Service) projectMirrorDir(projectID string) string {
+	for _, proj := range s.cfg.Projects {
+		if proj.ID == projectID {
+			return s.mirrorDir(proj)
+		}
+	}
+	return ""
 }
 
 func gitRevParse(ctx context.Context, mirrorDir, ref string) (string, error) {
@@ -836,7 +1052,17 @@ func writeMeta(execDir string, meta execMeta) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(metaPath(execDir), b, 0o644)
+	return writeFileAtomic(metaPath(execDir), b)
+}
+
+// writeFileAtomic writes via a temp file and rename so concurrent readers
+// (status polls, cancel) never see a truncated file.
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 func readMeta(execDir string) (execMeta, error) {
@@ -848,15 +1074,30 @@ func readMeta(execDir string) (execMeta, error) {
 	if err := json.Unmarshal(b, &meta); err != nil {
 		return execMeta{}, err
 	}
+	if meta.Status == statusRunning {
+		if pausedAt, ok := readPaused(execDir); ok {
+			meta.Status, meta.PausedAt = statusPaused, pausedAt
+		}
+	}
+	if a, ok := readAnnotations(execDir); ok {
+		meta = a.apply(meta)
+	}
 	return meta, nil
 }
 
 func writeExitCode(execDir string, code int) error {
-	return os.WriteFile(filepath.Join(execDir, "exit_code"), []byte(strconv.Itoa(code)), 0o644)
+	return writeFileAtomic(filepath.Join(execDir, "exit_code"), []byte(strconv.Itoa(code)))
 }
 
 func writePID(execDir string, pid int) error {
-	return os.WriteFile(filepath.Join(execDir, "pid"), []byte(strconv.Itoa(pid)), 0o644)
+	return writeFileAtomic(filepath.Join(execDir, "pid"), []byte(strconv.Itoa(pid)))
+}
+
+func removePID(execDir string) error {
+	if err := os.Remove(filepath.Join(execDir, "pid")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
 func readPID(execDir string) (int, error) {
@@ -883,31 +1124,6 @@ func parseRFC3339(v string) (*time.Time, error) {
 	return &t, nil
 }
 
-func filterLogLinesByTime(lines [][]byte, since, until *time.Time) [][]byte {
-	if since == nil && until == nil {
-		return lines
-	}
-	out := make([][]byte, 0, len(lines))
-	for _, line := range lines {
-		if len(line) == 0 {
-			continue
-		}
-		ts, ok := extractLogTime(line)
-		if !ok {
-			out = append(out, line)
-			continue
-		}
-		if since != nil && ts.Before(*since) {
-			continue
-		}
-		if until != nil && ts.After(*until) {
-			continue
-		}
-		out = append(out, line)
-	}
-	return out
-}
-
 func extractLogTime(line []byte) (time.Time, bool) {
 	var obj map[string]any
 	if err := json.Unmarshal(line, &obj); err != nil {
@@ -1086,6 +1302,13 @@ func (s *Service) handleFileRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	info, err := os.Stat(req.Path)
+	if os.IsNotExist(err) && s.isExecLogPath(req.Path) {
+		// The log has been compressed: serve it as it was.
+		if _, zerr := os.Stat(req.Path + ".gz"); zerr == nil {
+			s.serveZippedFile(w, req.Path)
+			return
+		}
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			writeErr(w, http.StatusNotFound, "file not found")
@@ -1107,9 +1330,57 @@ func (s *Service) handleFileRead(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "failed to read: "+err.Error())
 		return
 	}
+	writeFileContent(w, req.Path, data)
+}
+
+// isExecLogPath reports whether path is a log or index file of an exec, or of
+// one of its attempts, which codexd compresses once the exec has finished.
+func (s *Service) isExecLogPath(path string) bool {
+	rel, err := filepath.Rel(filepath.Join(s.cfg.DataDir, "exec"), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 2 && (len(parts) != 4 || parts[1] != "attempts") {
+		return false
+	}
+	name := filepath.Base(rel)
+	for _, log := range []string{"stdout.log", "stderr.log", "logs.idx"} {
+		if name == log || strings.HasPrefix(name, log+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveZippedFile answers a file read of path from path.gz, decompressed.
+func (s *Service) serveZippedFile(w http.ResponseWriter, path string) {
+	f, err := tail.Open(path)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read: "+err.Error())
+		return
+	}
+	defer f.Close()
+	r := io.Reader(f)
+	if s.cfg.MaxFileSize > 0 {
+		r = io.LimitReader(f, s.cfg.MaxFileSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read: "+err.Error())
+		return
+	}
+	if s.cfg.MaxFileSize > 0 && int64(len(data)) > s.cfg.MaxFileSize {
+		writeErr(w, http.StatusRequestEntityTooLarge, "file too large")
+		return
+	}
+	writeFileContent(w, path, data)
+}
+
+func writeFileContent(w http.ResponseWriter, path string, data []byte) {
 	_ = jsonutil.WriteJSON(w, map[string]any{
 		"ok":      true,
-		"path":    req.Path,
+		"path":    path,
 		"content": base64.StdEncoding.EncodeToString(data),
 		"size":    len(data),
 	})
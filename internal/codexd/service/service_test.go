@@ -3,13 +3,18 @@ package service_test
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"runtime/metrics"
 	"strings"
 	"testing"
 	"time"
@@ -18,6 +23,19 @@ import (
 	"codex-runner/internal/codexd/service"
 )
 
+// TestMain lets the test binary act as the exec supervisor, which codexd starts
+// by re-running its own executable, and as the limit-exec the supervisor starts
+// the shell under.
+func TestMain(m *testing.M) {
+	if len(os.Args) == 3 && os.Args[1] == "supervise" {
+		os.Exit(service.Supervise(os.Args[2]))
+	}
+	if len(os.Args) > 2 && os.Args[1] == "limit-exec" {
+		os.Exit(service.LimitExec(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
 func TestExecEchoAndLogsJSONL(t *testing.T) {
 	dir := t.TempDir()
 	cfg := config.Default()
@@ -64,26 +82,48 @@ func TestExecCancel(t *testing.T) {
 	h := svc.Handler()
 
 	execID := startExec(t, h, `sleep 30`)
-	deadline := time.Now().Add(2 * time.Second)
-	for {
-		b := do(t, h, "POST", "/v1/exec/"+execID+"/cancel", nil)
-		var out map[string]any
-		_ = json.Unmarshal(b, &out)
-		if out["canceled"] == true {
-			break
-		}
-		if time.Now().After(deadline) {
-			t.Fatalf("cancel did not take effect in time: %s", string(b))
-		}
-		time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	var out map[string]any
+	b := do(t, h, "POST", "/v1/exec/"+execID+"/cancel", []byte(`{"reason":"wrong lr","caller":"alice@laptop","grace":"10s"}`))
+	if err := json.Unmarshal(b, &out); err != nil || out["canceled"] != true || out["grace"] != "10s" {
+		t.Fatalf("cancel response = %s (%v), want canceled with a 10s grace", b, err)
 	}
-	meta := waitFinished(t, h, execID, 5*time.Second)
-	if meta["status"] != "finished" {
-		t.Fatalf("expected finished after cancel, got %v", meta["status"])
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("cancel took %v, want it not to wait for the exec to exit", d)
 	}
+	meta := waitStatus(t, h, execID, "canceled", 5*time.Second)
 	if meta["exit_code"] == nil {
 		t.Fatalf("expected exit_code after cancel")
 	}
+	if meta["canceled_at"] == nil || meta["cancel_reason"] != "wrong lr" {
+		t.Fatalf("meta = %v, want canceled_at and cancel_reason", meta)
+	}
+	if by, _ := meta["canceled_by"].(map[string]any); by["caller"] != "alice@laptop" {
+		t.Fatalf("canceled_by = %v, want the caller", meta["canceled_by"])
+	}
+
+	// A second cancel neither signals nor overwrites a finished exec.
+	out = nil
+	_ = json.Unmarshal(do(t, h, "POST", "/v1/exec/"+execID+"/cancel", nil), &out)
+	if out["canceled"] != false || out["status"] != "canceled" {
+		t.Fatalf("cancel of a canceled exec = %v", out)
+	}
+}
+
+func TestExecCancelEscalatesAfterGrace(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.CancelGrace = "200ms"
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, `trap '' TERM; while true; do sleep 0.05; done`)
+	waitStarted(t, h, execID, 5*time.Second)
+	start := time.Now()
+	do(t, h, "POST", "/v1/exec/"+execID+"/cancel", nil)
+	waitStatus(t, h, execID, "canceled", 5*time.Second)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("exec ignoring SIGTERM took %v to stop, want cancel_grace of 200ms", d)
+	}
 }
 
 func TestRetentionCount(t *testing.T) {
@@ -318,6 +358,411 @@ func TestExecLogsTailLinesAndTimeFilter(t *testing.T) {
 	}
 }
 
+func TestExecLogsCaptureTimestamps(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, `echo early; sleep 1; echo late`)
+	_ = waitFinished(t, h, execID, 5*time.Second)
+
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&full=true&format=jsonl", nil))
+	ts := map[string]time.Time{}
+	for _, ev := range events {
+		if ev["type"] != "log" {
+			continue
+		}
+		v, err := time.Parse(time.RFC3339Nano, fmt.Sprint(ev["ts"]))
+		if err != nil {
+			t.Fatalf("log event without a valid ts: %v", ev)
+		}
+		ts[ev["line"].(string)] = v
+	}
+	if gap := ts["late"].Sub(ts["early"]); gap < 800*time.Millisecond {
+		t.Fatalf("late was captured %v after early, want about 1s", gap)
+	}
+
+	// Plain output has no time of its own; the filter uses the capture time.
+	since := url.QueryEscape(ts["late"].Add(-300 * time.Millisecond).Format(time.RFC3339Nano))
+	body := do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&since="+since, nil)
+	if got := string(body); got != "late\n" {
+		t.Fatalf("logs since late = %q, want only late", got)
+	}
+}
+
+func TestExecLogsBothInterleavesStreams(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, `echo out1; sleep 0.1; echo err1 >&2; sleep 0.1; echo out2; sleep 0.1; echo err2 >&2`)
+	_ = waitFinished(t, h, execID, 5*time.Second)
+
+	lines := func(events []map[string]any) string {
+		var out []string
+		for _, ev := range events {
+			if ev["type"] == "log" {
+				out = append(out, fmt.Sprintf("%v:%v:%v", ev["seq"], ev["stream"], ev["line"]))
+			}
+		}
+		return strings.Join(out, ",")
+	}
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=both&full=true&format=jsonl", nil))
+	if got, want := lines(events), "1:stdout:out1,2:stderr:err1,3:stdout:out2,4:stderr:err2"; got != want {
+		t.Fatalf("both = %s, want %s", got, want)
+	}
+	if end := events[len(events)-1]; end["type"] != "end" || end["next_seq"] != float64(5) {
+		t.Fatalf("trailer = %v, want end with next_seq 5", end)
+	}
+
+	events = parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=both&seq=3&format=jsonl", nil))
+	if got, want := lines(events), "3:stdout:out2,4:stderr:err2"; got != want {
+		t.Fatalf("both from seq 3 = %s, want %s", got, want)
+	}
+
+	events = parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=both&full=true&follow=true&format=jsonl", nil))
+	if got, want := lines(events[:len(events)-1]), "1:stdout:out1,2:stderr:err1,3:stdout:out2,4:stderr:err2"; got != want {
+		t.Fatalf("followed both = %s, want %s", got, want)
+	}
+	if last := events[len(events)-1]; last["type"] != "finished" || last["next_seq"] != float64(5) {
+		t.Fatalf("last event = %v, want finished with next_seq 5", last)
+	}
+}
+
+func TestExecLogsOffsetAndLimit(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, `printf 'one\ntwo\nthree\n'`)
+	_ = waitFinished(t, h, execID, 5*time.Second)
+
+	// A limit that ends mid-line returns the whole lines before it.
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&offset=0&limit=6&format=jsonl", nil))
+	if len(events) != 2 || events[0]["line"] != "one" || events[0]["next_offset"] != float64(4) {
+		t.Fatalf("first chunk = %v, want the line one", events)
+	}
+	if end := events[1]; end["type"] != "end" || end["next_offset"] != float64(4) {
+		t.Fatalf("trailer = %v, want end with next_offset 4", end)
+	}
+
+	req := httptest.NewRequest("GET", "http://example/v1/exec/"+execID+"/logs?stream=stdout&offset=4", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if got := rr.Body.String(); got != "two\nthree\n" {
+		t.Fatalf("logs from offset 4 = %q, want two and three", got)
+	}
+	if got := rr.Header().Get("X-Next-Offset"); got != "14" {
+		t.Fatalf("X-Next-Offset = %q, want 14", got)
+	}
+
+	events = parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&offset=14&format=jsonl", nil))
+	if len(events) != 1 || events[0]["type"] != "end" || events[0]["next_offset"] != float64(14) {
+		t.Fatalf("logs at the end = %v, want only the trailer", events)
+	}
+}
+
+func TestExecLogsKeepHeadAndTailWithinLimits(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	// seq 1 5000 writes 23893 bytes.
+	execID := startExecWithBody(t, h, map[string]any{
+		"cmd":        "seq 1 5000",
+		"log_limits": map[string]any{"max_bytes": "4K", "head_bytes": "1K", "rotate_bytes": "1K"},
+	})
+	meta := waitFinished(t, h, execID, 5*time.Second)
+	dropped, _ := meta["log_dropped"].(map[string]any)
+	if dropped == nil || dropped["stdout"] == nil {
+		t.Fatalf("log_dropped = %v, want stdout bytes", meta["log_dropped"])
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "exec", execID, "stdout.log*"))
+	var kept int64
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept += st.Size()
+	}
+	if kept > 4096 || kept+int64(dropped["stdout"].(float64)) != 23893 {
+		t.Fatalf("kept %d bytes in %v, dropped %v; want at most 4096 and 23893 in all", kept, files, dropped["stdout"])
+	}
+
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&full=true&format=jsonl", nil))
+	var marker map[string]any
+	for _, ev := range events {
+		if ev["type"] == "truncated" {
+			marker = ev
+		}
+	}
+	if events[0]["line"] != "1" || marker == nil || marker["dropped_bytes"] != dropped["stdout"] {
+		t.Fatalf("full read starts %v with marker %v, want line 1 and the dropped bytes", events[0], marker)
+	}
+	if last := events[len(events)-2]; last["line"] != "5000" || events[len(events)-1]["next_offset"] != float64(23893) {
+		t.Fatalf("full read ends %v %v, want line 5000 and next_offset 23893", last, events[len(events)-1])
+	}
+
+	req := httptest.NewRequest("GET", "http://example/v1/exec/"+execID+"/logs?stream=both&tail_lines=2", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if got := rr.Body.String(); got != "4999\n5000\n" {
+		t.Fatalf("tail = %q, want the last two lines", got)
+	}
+}
+
+func TestExecLogsReadCompressed(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	cfg.CompressLogsAfter = "0"
+	h := service.New(cfg).Handler()
+
+	var want strings.Builder
+	for i := 1; i <= 300000; i++ {
+		fmt.Fprintf(&want, "%d\n", i)
+	}
+	execID := startExec(t, h, "seq 1 300000; sleep 0.3; echo oops >&2")
+	waitFinished(t, h, execID, 10*time.Second)
+	var sizes map[string]any
+	for deadline := time.Now().Add(10 * time.Second); sizes == nil && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		var meta map[string]any
+		if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+execID, nil), &meta); err != nil {
+			t.Fatal(err)
+		}
+		sizes, _ = meta["log_sizes"].(map[string]any)
+	}
+	stdout, _ := sizes["stdout"].(map[string]any)
+	if stdout == nil || stdout["size"] != float64(want.Len()) || stdout["compressed_size"].(float64) >= float64(want.Len())/2 {
+		t.Fatalf("log_sizes = %v, want stdout compressed from %d bytes", sizes, want.Len())
+	}
+	logPath := filepath.Join(dir, "exec", execID, "stdout.log")
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Fatalf("stdout.log still there after compression: %v", err)
+	}
+
+	if got := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?full=true", nil)); got != want.String() {
+		t.Fatalf("full read of %d bytes, want %d", len(got), want.Len())
+	}
+	if got := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?tail_lines=2", nil)); got != "299999\n300000\n" {
+		t.Fatalf("tail_lines = %q", got)
+	}
+	// A read across the first gzip member boundary, at 1 MiB, up to its last whole line.
+	span := want.String()[1048570:1048590]
+	span = span[:strings.LastIndexByte(span, '\n')+1]
+	if got := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?offset=1048570&limit=20", nil)); got != span {
+		t.Fatalf("offset read = %q, want %q", got, span)
+	}
+	since := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if got := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=both&tail_lines=2&since="+since, nil)); got != "300000\noops\n" {
+		t.Fatalf("both with since = %q", got)
+	}
+	until := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if got := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=both&full=true&until="+until, nil)); got != "" {
+		t.Fatalf("both until an hour ago = %q, want nothing", got)
+	}
+
+	body, _ := json.Marshal(map[string]any{"path": logPath})
+	var file struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(do(t, h, "POST", "/v1/file/read", body), &file); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := base64.StdEncoding.DecodeString(file.Content); string(data) != want.String() {
+		t.Fatalf("file read of the log got %d bytes, want %d", len(data), want.Len())
+	}
+}
+
+func TestExecLogsSearch(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, "printf 'a\\nb\\nTraceback x\\nc\\nd\\n'; sleep 0.2; echo 'Error: boom' >&2")
+	waitFinished(t, h, execID, 5*time.Second)
+	pattern := url.QueryEscape("Traceback|Error")
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs/search?context=1&pattern="+pattern, nil))
+	if len(events) != 3 {
+		t.Fatalf("events = %v, want two matches and end", events)
+	}
+	tb, boom, end := events[0], events[1], events[2]
+	before, _ := tb["before"].([]any)
+	after, _ := tb["after"].([]any)
+	if tb["stream"] != "stdout" || tb["line"] != "Traceback x" || tb["line_number"] != float64(3) || tb["offset"] != float64(4) ||
+		len(before) != 1 || before[0].(map[string]any)["line"] != "b" || len(after) != 1 || after[0].(map[string]any)["line"] != "c" {
+		t.Fatalf("first match = %v", tb)
+	}
+	before, _ = boom["before"].([]any)
+	if boom["stream"] != "stderr" || boom["line_number"] != float64(1) || boom["seq"] != float64(6) ||
+		len(before) != 1 || before[0].(map[string]any)["line"] != "d" {
+		t.Fatalf("second match = %v", boom)
+	}
+	if end["type"] != "end" || end["matches"] != float64(2) || end["truncated"] != false {
+		t.Fatalf("end = %v", end)
+	}
+
+	events = parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs/search?max_matches=1&pattern="+pattern, nil))
+	if len(events) != 2 || events[0]["line"] != "Traceback x" || events[1]["truncated"] != true {
+		t.Fatalf("max_matches=1 events = %v", events)
+	}
+
+	other := startExec(t, h, "echo Error again")
+	waitFinished(t, h, other, 5*time.Second)
+	events = parseJSONLLines(t, do(t, h, "GET", "/v1/exec/logs/search?pattern=Error", nil))
+	if len(events) != 3 || events[0]["exec_id"] != other || events[1]["exec_id"] != execID || events[2]["execs_searched"] != float64(2) {
+		t.Fatalf("search across execs = %v", events)
+	}
+}
+
+func TestExecLogsFollowStreamsUntilFinished(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, `echo first; sleep 0.5; echo same; echo same; printf tail`)
+	events := parseJSONLLines(t, do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&full=true&follow=true&format=jsonl", nil))
+	var lines []string
+	for _, ev := range events[:len(events)-1] {
+		lines = append(lines, ev["line"].(string))
+	}
+	if got := strings.Join(lines, ","); got != "first,same,same,tail" {
+		t.Fatalf("followed lines = %s, want first,same,same,tail", got)
+	}
+	if last := events[len(events)-1]; last["type"] != "finished" || last["exit_code"] != float64(0) {
+		t.Fatalf("last event = %v, want finished with exit code 0", last)
+	}
+}
+
+func TestExecLogsTailOnLargeIndex(t *testing.T) {
+	// Not parallel: it counts what the reads allocate.
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, "true")
+	waitFinished(t, h, execID, 5*time.Second)
+
+	writeIndexedLogs(t, filepath.Join(dir, "exec", execID), 400000)
+
+	read := func(query string) []map[string]any {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		body := do(t, h, "GET", "/v1/exec/"+execID+"/logs?format=jsonl&"+query, nil)
+		runtime.ReadMemStats(&after)
+		// The index is over 10 MB; loading it would take many times that.
+		if n := after.TotalAlloc - before.TotalAlloc; n > 4<<20 {
+			t.Fatalf("%s allocated %d bytes, want it bounded by the lines read", query, n)
+		}
+		return parseJSONLLines(t, body)
+	}
+	events := read("stream=stdout&tail_lines=200")
+	if len(events) != 201 {
+		t.Fatalf("got %d events, want 200 lines and end", len(events))
+	}
+	if first, last := events[0], events[199]; first["line"] != "line 399800" || first["seq"] != float64(399800) ||
+		last["line"] != "line 399999" || last["seq"] != float64(399999) || last["ts"] == nil {
+		t.Fatalf("tail from %v to %v, want lines 399800 to 399999 with their seqs", first, last)
+	}
+	events = read("stream=stderr&tail_lines=2")
+	if len(events) != 3 || events[0]["seq"] != float64(399000) || events[1]["seq"] != float64(400000) {
+		t.Fatalf("stderr tail = %v, want seqs 399000 and 400000", events)
+	}
+	events = read("stream=both&tail_lines=200")
+	if len(events) != 201 || events[0]["seq"] != float64(399801) || events[199]["seq"] != float64(400000) ||
+		events[199]["stream"] != "stderr" || events[200]["next_seq"] != float64(400001) {
+		t.Fatalf("both tail = %v ... %v, want seqs 399801 to 400000", events[0], events[len(events)-1])
+	}
+	events = read("stream=both&seq=200000&limit=100")
+	if len(events) < 2 || events[0]["seq"] != float64(200000) || events[len(events)-1]["type"] != "end" {
+		t.Fatalf("both from seq 200000 = %v ... %v", events[0], events[len(events)-1])
+	}
+}
+
+func TestExecLogsSearchOnLargeIndex(t *testing.T) {
+	// Not parallel: it counts what the search allocates.
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, "true")
+	waitFinished(t, h, execID, 5*time.Second)
+	writeIndexedLogs(t, filepath.Join(dir, "exec", execID), 400000)
+
+	var body []byte
+	peak := peakHeap(func() {
+		body = do(t, h, "GET", "/v1/exec/logs/search?stream=both&pattern="+url.QueryEscape("^line (1|399999|400000)$"), nil)
+	})
+	// The index is over 10 MB; holding its entries would take many times that.
+	if peak > 32<<20 {
+		t.Fatalf("heap peaked at %d bytes during the search, want it bounded", peak)
+	}
+	events := parseJSONLLines(t, body)
+	if len(events) != 4 || events[0]["seq"] != float64(1) || events[1]["seq"] != float64(399999) ||
+		events[2]["seq"] != float64(400000) || events[2]["stream"] != "stderr" || events[3]["matches"] != float64(3) {
+		t.Fatalf("events = %v, want the first and last lines with their seqs", events)
+	}
+}
+
+// peakHeap runs f and returns the most the heap held meanwhile, as sampled.
+func peakHeap(f func()) uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	runtime.GC()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	var peak uint64
+	for {
+		metrics.Read(sample)
+		peak = max(peak, sample[0].Value.Uint64())
+		select {
+		case <-done:
+			return peak
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// writeIndexedLogs replaces the logs of a finished exec with lines "line <seq>",
+// one in a thousand on stderr, and their index.
+func writeIndexedLogs(t *testing.T, execDir string, lines int) {
+	t.Helper()
+	var stdout, stderr, index bytes.Buffer
+	at := time.Now().UnixMilli()
+	for seq := 1; seq <= lines; seq++ {
+		log, stream := &stdout, "stdout"
+		if seq%1000 == 0 {
+			log, stream = &stderr, "stderr"
+		}
+		fmt.Fprintf(log, "line %d\n", seq)
+		fmt.Fprintf(&index, "%d %s %d %d\n", seq, stream, log.Len(), at)
+	}
+	for name, data := range map[string][]byte{"stdout.log": stdout.Bytes(), "stderr.log": stderr.Bytes(), "logs.idx": index.Bytes()} {
+		if err := os.WriteFile(filepath.Join(execDir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestExecCollectArtifacts(t *testing.T) {
 	dir := t.TempDir()
 	cfg := config.Default()
@@ -345,6 +790,91 @@ echo done`
 	}
 }
 
+func TestExecTimeoutMarksTimedOut(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	svc := service.New(cfg)
+	h := svc.Handler()
+
+	execID := startExecWithBody(t, h, map[string]any{
+		"cmd":     "sleep 30",
+		"timeout": "300ms",
+	})
+	meta := waitStatus(t, h, execID, "timed_out", 5*time.Second)
+	if meta["exit_code"] == nil {
+		t.Fatalf("expected exit_code after timeout")
+	}
+	if errMsg, _ := meta["error"].(string); !strings.Contains(errMsg, "timed out after 300ms") {
+		t.Fatalf("error = %q, want timeout explanation", errMsg)
+	}
+}
+
+func TestExecRerunRecordsProvenance(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	mustInitGitRepo(t, repo)
+	mustWriteFile(t, filepath.Join(repo, "note.txt"), "v1\n")
+	mustRun(t, repo, "git", "add", ".")
+	mustRun(t, repo, "git", "-c", "commit.gpgsign=false", "commit", "-m", "v1")
+
+	cfg := config.Default()
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.Projects = []config.Project{{ID: "p1", RepoURL: repo}}
+	h := service.New(cfg).Handler()
+
+	parentID := startExecWithBody(t, h, map[string]any{
+		"project_id": "p1",
+		"ref":        "HEAD",
+		"cmd":        "echo $FOO $(cat note.txt)",
+		"env":        map[string]string{"FOO": "bar"},
+	})
+	meta := waitFinished(t, h, parentID, 10*time.Second)
+	prov, _ := meta["provenance"].(map[string]any)
+	commit, _ := prov["commit"].(string)
+	if len(commit) != 40 {
+		t.Fatalf("provenance.commit = %q, want a full sha", commit)
+	}
+	if prov["shell"] == "" || prov["hostname"] == "" || prov["daemon_version"] != service.Version {
+		t.Fatalf("provenance = %v, want shell, hostname and daemon_version", prov)
+	}
+	if hash, _ := prov["env_hash"].(string); !strings.HasPrefix(hash, "sha256:") {
+		t.Fatalf("provenance.env_hash = %q, want sha256:<hex>", hash)
+	}
+	if req, _ := prov["request"].(map[string]any); req["ref"] != "HEAD" || req["cmd"] != "echo $FOO $(cat note.txt)" {
+		t.Fatalf("provenance.request = %v, want the request as submitted", req)
+	}
+
+	// The rerun runs the commit the parent ran, not the ref's new one.
+	mustWriteFile(t, filepath.Join(repo, "note.txt"), "v2\n")
+	mustRun(t, repo, "git", "-c", "commit.gpgsign=false", "commit", "-am", "v2")
+	var started map[string]any
+	body := do(t, h, "POST", "/v1/exec/"+parentID+"/rerun", []byte(`{"env":{"FOO":"baz"}}`))
+	if err := json.Unmarshal(body, &started); err != nil {
+		t.Fatalf("decode rerun response: %v", err)
+	}
+	if started["parent_exec_id"] != parentID {
+		t.Fatalf("rerun response = %v, want parent_exec_id %s", started, parentID)
+	}
+	rerunID, _ := started["exec_id"].(string)
+	meta = waitFinished(t, h, rerunID, 10*time.Second)
+	if meta["parent_exec_id"] != parentID {
+		t.Fatalf("rerun parent_exec_id = %v, want %s", meta["parent_exec_id"], parentID)
+	}
+	if prov, _ := meta["provenance"].(map[string]any); prov["commit"] != commit {
+		t.Fatalf("rerun commit = %v, want %s", prov["commit"], commit)
+	}
+	if logs := string(do(t, h, "GET", "/v1/exec/"+rerunID+"/logs?stream=stdout", nil)); logs != "baz v1\n" {
+		t.Fatalf("rerun stdout = %q, want the same cmd with the env override", logs)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec/missing/rerun", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("rerun of unknown exec => %d, want 404", rr.Code)
+	}
+}
+
 func startExec(t *testing.T, h http.Handler, cmd string) string {
 	t.Helper()
 	reqBody := map[string]any{"cmd": cmd}
@@ -384,6 +914,32 @@ func waitFinished(t *testing.T, h http.Handler, execID string, timeout time.Dura
 	return nil
 }
 
+// cancelExec cancels an exec and waits until it has stopped.
+func cancelExec(t *testing.T, h http.Handler, execID string) {
+	t.Helper()
+	do(t, h, "POST", "/v1/exec/"+execID+"/cancel", nil)
+	waitStatus(t, h, execID, "canceled", 5*time.Second)
+}
+
+func waitStatus(t *testing.T, h http.Handler, execID string, status string, timeout time.Duration) map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	var meta map[string]any
+	for time.Now().Before(deadline) {
+		b := do(t, h, "GET", "/v1/exec/"+execID, nil)
+		meta = nil
+		if err := json.Unmarshal(b, &meta); err != nil {
+			t.Fatalf("invalid meta: %v", err)
+		}
+		if meta["status"] == status {
+			return meta
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for status %s, last meta: %v", status, meta)
+	return nil
+}
+
 func do(t *testing.T, h http.Handler, method string, path string, body []byte) []byte {
 	t.Helper()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -469,3 +1025,44 @@ func mustRun(t *testing.T, dir string, name string, args ...string) {
 		t.Fatalf("%s %v failed: %v\n%s", name, args, err, out)
 	}
 }
+
+func TestExecLimitsRecordedInMeta(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only enforced on linux")
+	}
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	cfg.Limits = config.ExecLimits{Nice: 5}
+	svc := service.New(cfg)
+	h := svc.Handler()
+
+	execID := startExecWithBody(t, h, map[string]any{
+		// The shell's first child starts with the limits too.
+		"cmd":    `cut -d' ' -f19 /proc/self/stat; grep 'Max data size' /proc/self/limits`,
+		"limits": map[string]any{"memory_max": "1G"},
+	})
+	meta := waitFinished(t, h, execID, 5*time.Second)
+	limits, ok := meta["limits"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected limits in meta, got %#v", meta)
+	}
+	via, _ := limits["via"].(map[string]any)
+	if via["memory_max"] == nil || via["nice"] != "setpriority" {
+		t.Fatalf("limits.via = %#v, want memory_max and nice applied", via)
+	}
+	if limits["memory_max"] != float64(1<<30) {
+		t.Fatalf("limits.memory_max = %v, want %d", limits["memory_max"], 1<<30)
+	}
+	out, err := os.ReadFile(filepath.Join(dir, "exec", execID, "stdout.log"))
+	if err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+	nice, dataLimit, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if nice != "5" {
+		t.Fatalf("nice inside exec = %q, want 5", nice)
+	}
+	if fields := strings.Fields(dataLimit); via["memory_max"] == "rlimit" && (len(fields) < 4 || fields[3] != "1073741824") {
+		t.Fatalf("data limit inside exec = %q, want %d", dataLimit, 1<<30)
+	}
+}
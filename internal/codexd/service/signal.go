@@ -0,0 +1,161 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"codex-runner/internal/shared/jsonutil"
+)
+
+var (
+	errUnknownSignal      = errors.New("unknown signal; use one of HUP, INT, QUIT, KILL, USR1, USR2, ALRM, TERM, WINCH")
+	errSignalsUnsupported = errors.New("signals are not supported on " + runtime.GOOS)
+)
+
+// pausedPath marks a running exec as paused: codexd writes it, holding the time
+// of the pause, after stopping the exec's process group and removes it after
+// continuing it. readMeta reports a running exec that has it as paused. The
+// supervisor keeps writing meta.json, so the mark lives beside it.
+func pausedPath(execDir string) string { return filepath.Join(execDir, "paused") }
+
+// readPaused returns when the exec was paused, if it is.
+func readPaused(execDir string) (string, bool) {
+	b, err := os.ReadFile(pausedPath(execDir))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(b)), true
+}
+
+// handleExecSignal serves POST /v1/exec/{id}/signal with body {"signal":"USR1"}:
+// it sends the signal to the exec's process group, e.g. to have a training
+// script checkpoint. The "SIG" prefix is optional.
+func (s *Service) handleExecSignal(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Signal string `json:"signal"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(body.Signal)), "SIG")
+	switch name {
+	case "":
+		writeErr(w, http.StatusBadRequest, "signal is required")
+		return
+	case "STOP", "TSTP", "CONT":
+		writeErr(w, http.StatusBadRequest, "use pause and resume to stop and continue an exec")
+		return
+	}
+	if err := checkExecSignal(name); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := r.PathValue("id")
+	pid, ok := runningExecPID(w, filepath.Join(s.cfg.DataDir, "exec", id))
+	if !ok {
+		return
+	}
+	if err := signalExec(pid, name); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to signal process")
+		return
+	}
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"exec_id": id,
+		"signal":  "SIG" + name,
+		"sent":    true,
+	})
+}
+
+// handleExecPause serves POST /v1/exec/{id}/pause: it stops the exec's process
+// group with SIGSTOP and reports the exec as paused until it is resumed. The
+// exec's timeout keeps running while it is paused.
+func (s *Service) handleExecPause(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	pid, ok := runningExecPID(w, execDir)
+	if !ok {
+		return
+	}
+	if err := pauseExec(pid); err != nil {
+		writeExecSignalErr(w, err)
+		return
+	}
+	pausedAt, paused := readPaused(execDir)
+	if !paused {
+		pausedAt = time.Now().UTC().Format(time.RFC3339Nano)
+		if err := os.WriteFile(pausedPath(execDir), []byte(pausedAt+"\n"), 0o644); err != nil {
+			_ = resumeExec(pid)
+			writeErr(w, http.StatusInternalServerError, "failed to record pause")
+			return
+		}
+	}
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"exec_id":   id,
+		"status":    statusPaused,
+		"paused_at": pausedAt,
+	})
+}
+
+// handleExecResume serves POST /v1/exec/{id}/resume: it continues a paused
+// exec's process group with SIGCONT.
+func (s *Service) handleExecResume(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	pid, ok := runningExecPID(w, execDir)
+	if !ok {
+		return
+	}
+	if err := resumeExec(pid); err != nil {
+		writeExecSignalErr(w, err)
+		return
+	}
+	if err := os.Remove(pausedPath(execDir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeErr(w, http.StatusInternalServerError, "failed to record resume")
+		return
+	}
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"exec_id": id,
+		"status":  statusRunning,
+	})
+}
+
+// runningExecPID returns the pid of an exec whose process is running, paused
+// or not, or answers why there is none, e.g. between the attempts of a retry.
+func runningExecPID(w http.ResponseWriter, execDir string) (int, bool) {
+	meta, err := readMeta(execDir)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return 0, false
+	}
+	if isTerminalStatus(meta.Status) {
+		writeErr(w, http.StatusConflict, "exec has already finished")
+		return 0, false
+	}
+	if meta.RetryAt != "" {
+		writeErr(w, http.StatusConflict, "exec is waiting to retry")
+		return 0, false
+	}
+	pid, err := readPID(execDir)
+	if err != nil {
+		writeErr(w, http.StatusConflict, "exec has not started yet")
+		return 0, false
+	}
+	return pid, true
+}
+
+func writeExecSignalErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSignalsUnsupported) {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeErr(w, http.StatusInternalServerError, "failed to signal process")
+}
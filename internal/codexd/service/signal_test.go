@@ -0,0 +1,140 @@
+//go:build !windows
+
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecSignal(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, `trap 'echo checkpoint; exit 0' USR1; echo ready; while true; do sleep 0.05; done`)
+	// The shell's own USR1 handling would kill it before the trap is set.
+	waitOutput(t, h, execID, "ready\n", 5*time.Second)
+	for name, code := range map[string]int{"STOP": http.StatusBadRequest, "NOPE": http.StatusBadRequest} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec/"+execID+"/signal", strings.NewReader(`{"signal":"`+name+`"}`)))
+		if rr.Code != code {
+			t.Fatalf("signal %s => %d, want %d", name, rr.Code, code)
+		}
+	}
+	var out map[string]any
+	if err := json.Unmarshal(do(t, h, "POST", "/v1/exec/"+execID+"/signal", []byte(`{"signal":"usr1"}`)), &out); err != nil || out["signal"] != "SIGUSR1" {
+		t.Fatalf("signal response = %v (%v), want SIGUSR1 sent", out, err)
+	}
+	meta := waitFinished(t, h, execID, 5*time.Second)
+	if meta["exit_code"] != float64(0) {
+		t.Fatalf("exit_code = %v, want 0 from the trap", meta["exit_code"])
+	}
+	if logs := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout", nil)); logs != "ready\ncheckpoint\n" {
+		t.Fatalf("stdout = %q, want the trap's output", logs)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec/"+execID+"/signal", strings.NewReader(`{"signal":"INT"}`)))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("signal of a finished exec => %d, want 409", rr.Code)
+	}
+}
+
+func TestExecSignalBetweenRetries(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	execID := startExecWithBody(t, h, map[string]any{
+		"cmd":   "exit 1",
+		"retry": map[string]any{"max_attempts": 2, "backoff": "1m"},
+	})
+	var meta map[string]any
+	for deadline := time.Now().Add(5 * time.Second); meta["retry_at"] == nil; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("meta = %v, want retry_at", meta)
+		}
+		meta = nil
+		if err := json.Unmarshal(do(t, h, "GET", "/v1/exec/"+execID, nil), &meta); err != nil {
+			t.Fatalf("invalid meta: %v", err)
+		}
+	}
+	// The failed attempt's process is gone; only its attempt record keeps it.
+	if _, ok := meta["pid"]; ok {
+		t.Fatalf("meta = %v, want no pid while waiting to retry", meta)
+	}
+	if a := meta["attempts"].([]any)[0].(map[string]any); a["pid"] == nil {
+		t.Fatalf("attempts[0] = %v, want its pid", a)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, "exec", execID, "pid")); !os.IsNotExist(err) {
+		t.Fatalf("pid file: %v, want it removed", err)
+	}
+	for path, body := range map[string]string{"signal": `{"signal":"USR1"}`, "pause": ""} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec/"+execID+"/"+path, strings.NewReader(body)))
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("%s between retries => %d, want 409", path, rr.Code)
+		}
+	}
+	cancelExec(t, h, execID)
+}
+
+// waitOutput waits until the exec's stdout is want.
+func waitOutput(t *testing.T, h http.Handler, execID, want string, timeout time.Duration) {
+	t.Helper()
+	var got string
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if got = string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&full=true", nil)); got == want {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for stdout %q, last %q", want, got)
+}
+
+func TestExecPauseResume(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	execID := startExec(t, h, `while true; do echo tick; sleep 0.05; done`)
+	waitStarted(t, h, execID, 5*time.Second)
+	do(t, h, "POST", "/v1/exec/"+execID+"/pause", nil)
+	meta := waitStatus(t, h, execID, "paused", time.Second)
+	if meta["paused_at"] == nil {
+		t.Fatalf("meta = %v, want paused_at", meta)
+	}
+	// Let output in flight land before measuring.
+	time.Sleep(100 * time.Millisecond)
+	paused := len(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&full=true", nil))
+	time.Sleep(300 * time.Millisecond)
+	if n := len(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&full=true", nil)); n != paused {
+		t.Fatalf("stdout grew from %d to %d bytes while paused", paused, n)
+	}
+
+	do(t, h, "POST", "/v1/exec/"+execID+"/resume", nil)
+	waitStatus(t, h, execID, "running", time.Second)
+	time.Sleep(300 * time.Millisecond)
+	if n := len(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout&full=true", nil)); n <= paused {
+		t.Fatalf("stdout did not grow after resume: %d bytes", n)
+	}
+
+	// A paused exec still stops when canceled.
+	do(t, h, "POST", "/v1/exec/"+execID+"/pause", nil)
+	waitStatus(t, h, execID, "paused", time.Second)
+	start := time.Now()
+	do(t, h, "POST", "/v1/exec/"+execID+"/cancel", nil)
+	waitStatus(t, h, execID, "canceled", 5*time.Second)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("cancel of a paused exec took %v, want it to stop on SIGTERM", d)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"codex-runner/internal/shared/jsonutil"
+)
+
+// stdinPollInterval is how often the supervisor checks the stdin file for new input.
+const stdinPollInterval = 50 * time.Millisecond
+
+// The stdin of an exec started with "stdin": true is the file <exec_dir>/stdin.
+// POST /v1/exec/{id}/stdin (or the body of POST /v1/exec/run) appends to it and
+// the supervisor copies it into the process. Keeping it on disk lets the exec be
+// replayed with the same input.
+func stdinPath(execDir string) string { return filepath.Join(execDir, "stdin") }
+
+// stdinClosedPath marks EOF: once it exists, nothing more is appended.
+func stdinClosedPath(execDir string) string { return filepath.Join(execDir, "stdin.closed") }
+
+func stdinClosed(execDir string) bool {
+	_, err := os.Stat(stdinClosedPath(execDir))
+	return err == nil
+}
+
+func (s *Service) handleExecStdin(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	execDir := filepath.Join(s.cfg.DataDir, "exec", id)
+	meta, err := readMeta(execDir)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "exec_id not found")
+		return
+	}
+	switch {
+	case !meta.Stdin:
+		writeErr(w, http.StatusConflict, "exec was not started with stdin")
+		return
+	case isTerminalStatus(meta.Status):
+		writeErr(w, http.StatusConflict, "exec has already finished")
+		return
+	case stdinClosed(execDir):
+		writeErr(w, http.StatusConflict, "stdin is closed")
+		return
+	}
+	closeAfter := r.URL.Query().Get("close") == "true"
+
+	f, err := os.OpenFile(stdinPath(execDir), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to open stdin")
+		return
+	}
+	written, err := io.Copy(f, r.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to write stdin: "+err.Error())
+		return
+	}
+	if closeAfter {
+		if err := os.WriteFile(stdinClosedPath(execDir), nil, 0o644); err != nil {
+			writeErr(w, http.StatusInternalServerError, "failed to close stdin")
+			return
+		}
+	}
+	_ = jsonutil.WriteJSON(w, map[string]any{
+		"exec_id": id,
+		"written": written,
+		"closed":  closeAfter,
+	})
+}
+
+// appendRunStdin copies the stdin of an exec run from the request body into the
+// stdin file and closes it once the body has ended.
+func appendRunStdin(execDir string, body io.Reader) error {
+	f, err := os.OpenFile(stdinPath(execDir), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err == nil {
+		_, err = io.Copy(f, body)
+		_ = f.Close()
+	}
+	if cerr := os.WriteFile(stdinClosedPath(execDir), nil, 0o644); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// feedStdin copies what is appended to the stdin file into the process and
+// closes its stdin once the file is marked closed and fully copied, or the
+// process has exited.
+func feedStdin(execDir string, w io.WriteCloser, exited <-chan struct{}) {
+	defer w.Close()
+	var offset int64
+	for {
+		closed := stdinClosed(execDir)
+		n, err := copyFileFrom(stdinPath(execDir), offset, w)
+		offset += n
+		if err != nil || closed {
+			return
+		}
+		select {
+		case <-exited:
+			return
+		case <-time.After(stdinPollInterval):
+		}
+	}
+}
+
+// copyFileFrom copies path from offset to its current end into w.
+func copyFileFrom(path string, offset int64, w io.Writer) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, f)
+}
@@ -0,0 +1,130 @@
+package service_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecStdinAppendAndClose(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := service.New(cfg).Handler()
+
+	execID := startExecWithBody(t, h, map[string]any{"cmd": "cat", "stdin": true})
+	do(t, h, "POST", "/v1/exec/"+execID+"/stdin", []byte("hello\n"))
+	do(t, h, "POST", "/v1/exec/"+execID+"/stdin?close=true", []byte("world\n"))
+	meta := waitFinished(t, h, execID, 5*time.Second)
+	if meta["exit_code"] != float64(0) {
+		t.Fatalf("exit_code = %v, want 0", meta["exit_code"])
+	}
+	if logs := string(do(t, h, "GET", "/v1/exec/"+execID+"/logs?stream=stdout", nil)); logs != "hello\nworld\n" {
+		t.Fatalf("stdout = %q, want the stdin echoed back", logs)
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, "exec", execID, "stdin"))
+	if err != nil || string(stored) != "hello\nworld\n" {
+		t.Fatalf("stored stdin = %q (%v), want both writes", stored, err)
+	}
+
+	noStdinID := startExec(t, h, "true")
+	waitFinished(t, h, noStdinID, 5*time.Second)
+	for name, id := range map[string]string{"finished": execID, "no stdin": noStdinID} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "http://example/v1/exec/"+id+"/stdin", strings.NewReader("x")))
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("%s: stdin => %d, want 409", name, rr.Code)
+		}
+	}
+}
+
+func TestExecRerunReplaysStdin(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	parentID := startExecWithBody(t, h, map[string]any{"cmd": "cat", "stdin": true})
+	do(t, h, "POST", "/v1/exec/"+parentID+"/stdin?close=true", []byte("again\n"))
+	waitFinished(t, h, parentID, 5*time.Second)
+
+	var started map[string]any
+	if err := json.Unmarshal(do(t, h, "POST", "/v1/exec/"+parentID+"/rerun", nil), &started); err != nil {
+		t.Fatalf("decode rerun response: %v", err)
+	}
+	rerunID, _ := started["exec_id"].(string)
+	waitFinished(t, h, rerunID, 5*time.Second)
+	if logs := string(do(t, h, "GET", "/v1/exec/"+rerunID+"/logs?stream=stdout", nil)); logs != "again\n" {
+		t.Fatalf("rerun stdout = %q, want the parent's stdin", logs)
+	}
+}
+
+func TestExecRunStreamsStdin(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	srv := httptest.NewServer(service.New(cfg).Handler())
+	defer srv.Close()
+
+	// The request, then the stdin, which the exec reads while its output
+	// streams back.
+	body, stdin := io.Pipe()
+	defer stdin.Close()
+	go func() { _, _ = stdin.Write([]byte(`{"cmd":"cat","stdin":true}` + "\n")) }()
+	resp, err := http.Post(srv.URL+"/v1/exec/run", "application/octet-stream", body)
+	if err != nil {
+		t.Fatalf("POST /v1/exec/run: %v", err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewScanner(resp.Body)
+	next := func() map[string]any {
+		t.Helper()
+		if !events.Scan() {
+			t.Fatalf("event stream ended: %v", events.Err())
+		}
+		var ev map[string]any
+		if err := json.Unmarshal(events.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", events.Text(), err)
+		}
+		return ev
+	}
+	if ev := next(); ev["type"] != "started" {
+		t.Fatalf("first event = %v, want started", ev)
+	}
+	_, _ = stdin.Write([]byte("hello\n"))
+	if ev := next(); ev["line"] != "hello" {
+		t.Fatalf("event = %v, want the first line echoed while stdin is open", ev)
+	}
+	_, _ = stdin.Write([]byte("world\n"))
+	stdin.Close()
+	if ev := next(); ev["line"] != "world" {
+		t.Fatalf("event = %v, want the second line", ev)
+	}
+	if ev := next(); ev["type"] != "finished" || ev["exit_code"] != float64(0) {
+		t.Fatalf("event = %v, want cat to finish at the end of stdin", ev)
+	}
+
+	// An exec that is done with its input finishes with the stdin still open.
+	body, stdin = io.Pipe()
+	defer stdin.Close()
+	go func() { _, _ = stdin.Write([]byte(`{"cmd":"head -n 1","stdin":true}` + "\none\n")) }()
+	resp, err = http.Post(srv.URL+"/v1/exec/run", "application/octet-stream", body)
+	if err != nil {
+		t.Fatalf("POST /v1/exec/run: %v", err)
+	}
+	defer resp.Body.Close()
+	events = bufio.NewScanner(resp.Body)
+	for _, want := range []string{"started", "log", "finished"} {
+		if ev := next(); ev["type"] != want {
+			t.Fatalf("event = %v, want %s", ev, want)
+		}
+	}
+}
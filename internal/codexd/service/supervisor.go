@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+)
+
+// execSpec is everything the supervisor needs to run an exec. codexd resolves it
+// (shell, worktree, cwd, limits) and writes it to spec.json before the launch.
+type execSpec struct {
+	Shell   string   `json:"shell"`
+	Cmd     string   `json:"cmd"`
+	Dir     string   `json:"dir"`
+	Env     []string `json:"env,omitempty"` // appended to the supervisor's environment
+	Timeout string   `json:"timeout,omitempty"`
+	// StopGrace is how long a timed-out exec gets between SIGTERM and SIGKILL.
+	StopGrace string            `json:"stop_grace,omitempty"`
+	Limits    config.ExecLimits `json:"limits"`
+	// LogLimits cap the output kept, see outputCapture.
+	LogLimits config.LogLimits `json:"log_limits"`
+	Retry     *execRetry       `json:"retry,omitempty"` // run the cmd again after a retryable failure
+	Stdin     bool             `json:"stdin,omitempty"` // feed the stdin file, see feedStdin
+	PTY       bool             `json:"pty,omitempty"`   // run on a PTY served to attach clients, see ptyHub
+	// CgroupParent is resolved by codexd, or CgroupError says why there is none.
+	CgroupParent string `json:"cgroup_parent,omitempty"`
+	CgroupError  string `json:"cgroup_error,omitempty"`
+	// MirrorDir is set when Dir is inside a git worktree the supervisor removes at the end.
+	MirrorDir string `json:"mirror_dir,omitempty"`
+}
+
+func specPath(execDir string) string { return filepath.Join(execDir, "spec.json") }
+
+func readSpec(execDir string) (execSpec, error) {
+	b, err := os.ReadFile(specPath(execDir))
+	if err != nil {
+		return execSpec{}, err
+	}
+	var spec execSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return execSpec{}, err
+	}
+	return spec, nil
+}
+
+// startSupervisor launches `codexd supervise <execDir>` detached from codexd, so
+// the exec keeps running and records its result if codexd restarts meanwhile.
+func (s *Service) startSupervisor(execDir string, spec execSpec) (*exec.Cmd, error) {
+	b, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(specPath(execDir), b); err != nil {
+		return nil, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate codexd executable: %w", err)
+	}
+	logFile, err := os.OpenFile(filepath.Join(execDir, "supervisor.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "supervise", execDir)
+	cmd.Dir = execDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	configureSupervisorCmd(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start supervisor: %w", err)
+	}
+	_ = writeFileAtomic(filepath.Join(execDir, "supervisor.pid"), []byte(strconv.Itoa(cmd.Process.Pid)))
+	return cmd, nil
+}
+
+// awaitSupervisor waits for a supervisor started by this codexd and returns the final meta.
+func (s *Service) awaitSupervisor(execDir string, cmd *exec.Cmd) execMeta {
+	_ = cmd.Wait()
+	return s.settleSupervised(execDir)
+}
+
+// watchSupervisor waits for a supervisor started by a previous codexd.
+func (s *Service) watchSupervisor(execDir string, pid int, ticket *queueTicket) {
+	for processAlive(pid) {
+		time.Sleep(orphanPollInterval)
+	}
+	s.settleSupervised(execDir)
+	s.queue.release(ticket)
+}
+
+// settleSupervised reads the result of an exec whose supervisor has exited. A
+// supervisor that died without finalizing leaves the exec lost, once its
+// process (if it outlived the supervisor) is gone too.
+func (s *Service) settleSupervised(execDir string) execMeta {
+	meta, err := readMeta(execDir)
+	if err != nil || isTerminalStatus(meta.Status) {
+		return meta
+	}
+	if pid, err := readPID(execDir); err == nil && processAlive(pid) {
+		s.watchOrphan(execDir, meta, pid)
+	} else {
+		s.markLost(execDir, meta, "supervisor exited without recording a result")
+	}
+	meta, _ = readMeta(execDir)
+	return meta
+}
+
+func readSupervisorPID(execDir string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(execDir, "supervisor.pid"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// Supervise runs the exec prepared in execDir, writes its pid, and records the
+// exit code and final meta. It is the body of `codexd supervise <exec_dir>` and
+// returns the supervisor's own exit code, not the exec's.
+func Supervise(execDir string) int {
+	spec, err := readSpec(execDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "supervise: read spec:", err)
+		return 1
+	}
+	meta, err := readMeta(execDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "supervise: read meta:", err)
+		return 1
+	}
+	if spec.MirrorDir != "" {
+		workdir := filepath.Join(execDir, "workdir")
+		defer func() {
+			_ = runGit(context.Background(), spec.MirrorDir, "worktree", "remove", "--force", workdir)
+		}()
+	}
+	if c, ok := readCancel(execDir); ok {
+		// Canceled while codexd was preparing it.
+		finalizeMeta(execDir, c.apply(meta), statusCanceled, 1, nil)
+		return 0
+	}
+	var timeout time.Duration
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	grace := execStopGrace
+	if spec.StopGrace != "" {
+		grace, _ = time.ParseDuration(spec.StopGrace)
+	}
+
+	var cgroupErr error
+	if spec.CgroupError != "" {
+		cgroupErr = errors.New(spec.CgroupError)
+	}
+	limiter := newExecLimiter(meta.ExecID, spec.Limits, spec.CgroupParent, cgroupErr)
+	defer limiter.release()
+
+	for {
+		dir := execDir
+		if spec.Retry != nil {
+			if dir, err = startAttempt(execDir, &meta); err != nil {
+				finalizeMeta(execDir, meta, statusFinished, 127, err)
+				return 0
+			}
+		}
+		res := runAttempt(execDir, dir, spec, &meta, limiter, timeout, grace)
+		if !res.started {
+			finalizeMeta(execDir, meta, statusFinished, 127, res.err)
+			return 0
+		}
+		meta.LogDropped = res.dropped
+		meta.Usage = addUsage(meta.Usage, res.usage)
+		status, err := statusFinished, res.err
+		if res.timedOut {
+			status = statusTimedOut
+			err = timeoutError(timeout)
+		} else if c, ok := readCancel(execDir); ok {
+			meta = c.apply(meta)
+			status = statusCanceled
+		}
+		if spec.Retry != nil {
+			endAttempt(execDir, dir, &meta, status, res, err)
+			n := len(meta.Attempts)
+			if status == statusFinished && res.exitCode != 0 && n < spec.Retry.MaxAttempts {
+				if reason := spec.Retry.retryReason(dir, meta.ExecID, res.exitCode); reason != "" {
+					meta.Attempts[n-1].RetryReason = reason
+					if awaitRetry(execDir, &meta, spec.Retry.delay(n)) {
+						continue
+					}
+					// Canceled while waiting to retry.
+					c, _ := readCancel(execDir)
+					meta = c.apply(meta)
+					meta.RetryAt = ""
+					status = statusCanceled
+				}
+			}
+		}
+		if artifacts, warn := collectArtifacts(execDir, spec.Dir); len(artifacts) > 0 {
+			meta.Artifacts = artifacts
+			meta.Warn = warn
+		} else if warn != "" {
+			meta.Warn = warn
+		}
+		finalizeMeta(execDir, meta, status, res.exitCode, err)
+		return 0
+	}
+}
+
+// attemptResult is how one run of an exec's cmd ended.
+type attemptResult struct {
+	started  bool // false if the cmd did not start; err says why
+	exitCode int
+	timedOut bool
+	err      error
+	dropped  map[string]int64 // see outputCapture.dropped
+	usage    *execUsage
+}
+
+// runAttempt runs the exec's cmd once, with its output captured in logDir, and
+// records its pid in meta while it runs.
+func runAttempt(execDir, logDir string, spec execSpec, meta *execMeta, limiter *execLimiter, timeout, grace time.Duration) attemptResult {
+	capture, err := openCapture(logDir, spec.LogLimits)
+	if err != nil {
+		return attemptResult{err: err}
+	}
+	defer capture.close()
+
+	cmd := exec.Command(spec.Shell, "-lc", spec.Cmd)
+	cmd.Dir = spec.Dir
+	var ptyMaster, ptySlave *os.File
+	var childEnds []*os.File
+	if spec.PTY {
+		if ptyMaster, ptySlave, err = openPTY(); err != nil {
+			return attemptResult{err: err}
+		}
+		_ = setPTYSize(ptyMaster, ptyDefaultRows, ptyDefaultCols)
+		cmd.Stdin = ptySlave
+		cmd.Stdout = ptySlave
+		cmd.Stderr = ptySlave
+		configurePTYCmd(cmd)
+	} else {
+		// The child writes into pipes so that every line is indexed as it
+		// arrives; the write ends are closed here once it has started.
+		for _, stream := range []string{"stdout", "stderr"} {
+			w, err := capture.pipe(stream)
+			if err != nil {
+				closeFiles(childEnds)
+				return attemptResult{err: err}
+			}
+			childEnds = append(childEnds, w)
+			if stream == "stdout" {
+				cmd.Stdout = w
+			} else {
+				cmd.Stderr = w
+			}
+		}
+		configureCmd(cmd)
+	}
+	limiter.configure(cmd)
+	cmd.Env = append(os.Environ(), spec.Env...)
+	var stdin io.WriteCloser
+	if spec.Stdin {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			closeFiles(childEnds)
+			return attemptResult{err: err}
+		}
+	}
+	err = cmd.Start()
+	closeFiles(childEnds)
+	if err != nil {
+		return attemptResult{err: err}
+	}
+	exited := make(chan struct{})
+	if stdin != nil {
+		// Every attempt reads the exec's stdin from the start.
+		go feedStdin(execDir, stdin, exited)
+	}
+	var hub *ptyHub
+	if ptyMaster != nil {
+		ptySlave.Close()
+		hub = startPTYHub(execDir, ptyMaster, capture.stream("stdout"))
+	}
+
+	meta.PID = cmd.Process.Pid
+	limiter.started(meta.PID)
+	meta.Limits = limiter.result()
+	if n := len(meta.Attempts); n > 0 {
+		meta.Attempts[n-1].PID = meta.PID
+	}
+	_ = writeMeta(execDir, *meta)
+	_ = writePID(execDir, meta.PID)
+	deadline := startExecDeadline(meta.PID, timeout, grace)
+	sampler := startUsageSampler(meta.PID)
+
+	err = cmd.Wait()
+	close(exited)
+	res := attemptResult{started: true, timedOut: deadline.stop(), err: err, usage: sampler.finish(cmd.ProcessState)}
+	if err != nil {
+		res.exitCode = 1
+		if cmd.ProcessState != nil && cmd.ProcessState.ExitCode() >= 0 {
+			res.exitCode = cmd.ProcessState.ExitCode()
+		}
+	}
+	if hub != nil {
+		hub.finish(res.exitCode)
+	}
+	capture.finish()
+	res.dropped = capture.dropped()
+	return res
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"math"
+	"os"
+	"time"
+)
+
+// usageSampleInterval is how often the process group of a running exec is
+// sampled for its usage.
+const usageSampleInterval = time.Second
+
+// execUsage is what an exec, or one attempt of it, used. CPU time, and on unix
+// the peak RSS and block I/O of the processes the shell waited for, come from
+// the shell's wait status. On Linux, samples of the process group in /proc add
+// the peak RSS of the group as a whole, the I/O of processes nobody waited for,
+// and how many processes ran.
+type execUsage struct {
+	// WallSeconds is how long the cmd ran, summed over the attempts of an exec
+	// with a retry policy; waits in the queue or before a retry are not counted.
+	WallSeconds      float64 `json:"wall_seconds"`
+	UserCPUSeconds   float64 `json:"user_cpu_seconds"`
+	SystemCPUSeconds float64 `json:"system_cpu_seconds"`
+	PeakRSSBytes     int64   `json:"peak_rss_bytes"`
+	ReadBytes        int64   `json:"read_bytes"`  // from storage, not the page cache
+	WriteBytes       int64   `json:"write_bytes"` // to storage
+	// Processes counts the processes seen in the exec's process group; ones
+	// that lived for less than usageSampleInterval may be missed.
+	Processes int `json:"processes"`
+}
+
+// addUsage returns the usage of an exec over two of its attempts: the sum, with
+// the higher peak RSS.
+func addUsage(a, b *execUsage) *execUsage {
+	if a == nil || b == nil {
+		if a == nil {
+			return b
+		}
+		return a
+	}
+	return &execUsage{
+		WallSeconds:      roundSeconds(a.WallSeconds + b.WallSeconds),
+		UserCPUSeconds:   roundSeconds(a.UserCPUSeconds + b.UserCPUSeconds),
+		SystemCPUSeconds: roundSeconds(a.SystemCPUSeconds + b.SystemCPUSeconds),
+		PeakRSSBytes:     max(a.PeakRSSBytes, b.PeakRSSBytes),
+		ReadBytes:        a.ReadBytes + b.ReadBytes,
+		WriteBytes:       a.WriteBytes + b.WriteBytes,
+		Processes:        a.Processes + b.Processes,
+	}
+}
+
+// roundSeconds rounds to milliseconds.
+func roundSeconds(s float64) float64 { return math.Round(s*1000) / 1000 }
+
+// procSample is one process of an exec's process group as last seen in /proc.
+type procSample struct {
+	pid        int
+	rss        int64
+	readBytes  int64
+	writeBytes int64
+}
+
+// usageSampler samples the process group of a running exec every
+// usageSampleInterval, see sampleProcessGroup.
+type usageSampler struct {
+	pgid    int
+	started time.Time
+	stop    chan struct{}
+	done    chan struct{}
+
+	peakRSS int64
+	procs   map[int]procSample // by pid
+}
+
+func startUsageSampler(pgid int) *usageSampler {
+	u := &usageSampler{
+		pgid:    pgid,
+		started: time.Now(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		procs:   map[int]procSample{},
+	}
+	go func() {
+		defer close(u.done)
+		ticker := time.NewTicker(usageSampleInterval)
+		defer ticker.Stop()
+		for {
+			u.sample()
+			select {
+			case <-u.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return u
+}
+
+func (u *usageSampler) sample() {
+	var rss int64
+	for _, p := range sampleProcessGroup(u.pgid) {
+		rss += p.rss
+		u.procs[p.pid] = p
+	}
+	u.peakRSS = max(u.peakRSS, rss)
+}
+
+// finish stops sampling once the exec's cmd has exited with state, and returns
+// its usage.
+func (u *usageSampler) finish(state *os.ProcessState) *execUsage {
+	wall := time.Since(u.started)
+	close(u.stop)
+	<-u.done
+	usage := &execUsage{
+		WallSeconds:  roundSeconds(wall.Seconds()),
+		PeakRSSBytes: u.peakRSS,
+		Processes:    max(len(u.procs), 1),
+	}
+	for _, p := range u.procs {
+		usage.ReadBytes += p.readBytes
+		usage.WriteBytes += p.writeBytes
+	}
+	if state != nil {
+		addWaitUsage(usage, state)
+	}
+	return usage
+}
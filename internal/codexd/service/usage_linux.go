@@ -0,0 +1,79 @@
+//go:build linux
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sampleProcessGroup reads the processes of process group pgid from /proc: their
+// resident memory and the bytes they have read and written so far.
+func sampleProcessGroup(pgid int) []procSample {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	pageSize := int64(os.Getpagesize())
+	var out []procSample
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", e.Name())
+		stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		// The fields after the command name in parentheses, from field 3 (state)
+		// on: pgrp is field 5, rss (in pages) field 24.
+		i := bytes.LastIndexByte(stat, ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+		if pgrp, _ := strconv.Atoi(fields[2]); pgrp != pgid {
+			continue
+		}
+		p := procSample{pid: pid}
+		if rss, err := strconv.ParseInt(fields[21], 10, 64); err == nil {
+			p.rss = rss * pageSize
+		}
+		p.readBytes, p.writeBytes = readProcIO(filepath.Join(dir, "io"))
+		out = append(out, p)
+	}
+	return out
+}
+
+// readProcIO reads read_bytes and write_bytes from a /proc/<pid>/io file.
+func readProcIO(path string) (int64, int64) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	var read, written int64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		switch k {
+		case "read_bytes":
+			read = n
+		case "write_bytes":
+			written = n
+		}
+	}
+	return read, written
+}
@@ -0,0 +1,5 @@
+//go:build !linux
+
+package service
+
+func sampleProcessGroup(pgid int) []procSample { return nil }
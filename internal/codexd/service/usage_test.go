@@ -0,0 +1,63 @@
+package service_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"codex-runner/internal/codexd/config"
+	"codex-runner/internal/codexd/service"
+)
+
+func TestExecUsage(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	h := service.New(cfg).Handler()
+
+	// Two sleeps outlive the first samples of the process group, and the loop
+	// burns some CPU.
+	execID := startExec(t, h, `sleep 1.5 & sleep 1.5 & i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done; wait`)
+	meta := waitFinished(t, h, execID, 10*time.Second)
+	usage, ok := meta["usage"].(map[string]any)
+	if !ok {
+		t.Fatalf("meta = %v, want usage", meta)
+	}
+	if wall := usage["wall_seconds"].(float64); wall < 1.5 || wall > 10 {
+		t.Fatalf("wall_seconds = %v, want about 1.5", wall)
+	}
+	if cpu := usage["user_cpu_seconds"].(float64) + usage["system_cpu_seconds"].(float64); cpu <= 0 {
+		t.Fatalf("usage = %v, want some CPU time", usage)
+	}
+	if usage["peak_rss_bytes"].(float64) <= 0 {
+		t.Fatalf("usage = %v, want a peak RSS", usage)
+	}
+	if runtime.GOOS == "linux" && usage["processes"].(float64) < 3 {
+		t.Fatalf("usage = %v, want the shell and both sleeps counted", usage)
+	}
+
+	// exec run ends with it too, and with retries each attempt has its own.
+	events := parseJSONLLines(t, runExec(t, h, map[string]any{
+		"cmd":   "exit 1",
+		"retry": map[string]any{"max_attempts": 2, "backoff": "10ms"},
+	}))
+	end := events[len(events)-1]
+	if end["type"] != "finished" || end["usage"] == nil {
+		t.Fatalf("last event = %v, want a finished event with usage", end)
+	}
+	meta = waitFinished(t, h, end["exec_id"].(string), 5*time.Second)
+	attempts, _ := meta["attempts"].([]any)
+	if len(attempts) != 2 {
+		t.Fatalf("attempts = %v, want 2", meta["attempts"])
+	}
+	var processes float64
+	for _, a := range attempts {
+		u, ok := a.(map[string]any)["usage"].(map[string]any)
+		if !ok {
+			t.Fatalf("attempt = %v, want usage", a)
+		}
+		processes += u["processes"].(float64)
+	}
+	if got := meta["usage"].(map[string]any)["processes"]; got != processes {
+		t.Fatalf("usage.processes = %v, want %v, the sum over the attempts", got, processes)
+	}
+}
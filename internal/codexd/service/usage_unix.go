@@ -0,0 +1,30 @@
+//go:build !windows
+
+package service
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// addWaitUsage adds to u what the wait status of the exec's shell says about
+// it and the processes it waited for.
+func addWaitUsage(u *execUsage, state *os.ProcessState) {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return
+	}
+	u.UserCPUSeconds = roundSeconds(time.Duration(ru.Utime.Nano()).Seconds())
+	u.SystemCPUSeconds = roundSeconds(time.Duration(ru.Stime.Nano()).Seconds())
+	// ru_maxrss is in bytes on darwin, kilobytes elsewhere.
+	maxRSS := int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		maxRSS *= 1024
+	}
+	u.PeakRSSBytes = max(u.PeakRSSBytes, maxRSS)
+	// Block I/O is counted in 512-byte units.
+	u.ReadBytes = max(u.ReadBytes, int64(ru.Inblock)*512)
+	u.WriteBytes = max(u.WriteBytes, int64(ru.Oublock)*512)
+}
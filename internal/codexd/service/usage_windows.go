@@ -0,0 +1,24 @@
+//go:build windows
+
+package service
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// addWaitUsage adds the CPU time of the exec's shell to u.
+func addWaitUsage(u *execUsage, state *os.ProcessState) {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return
+	}
+	u.UserCPUSeconds = roundSeconds(filetimeDuration(ru.UserTime).Seconds())
+	u.SystemCPUSeconds = roundSeconds(filetimeDuration(ru.KernelTime).Seconds())
+}
+
+// filetimeDuration converts a FILETIME holding a duration, in 100ns units.
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+}
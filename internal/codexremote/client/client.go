@@ -8,9 +8,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"codex-runner/internal/shared/attach"
 	"codex-runner/internal/shared/jsonutil"
 )
 
@@ -38,11 +40,65 @@ type ExecStartRequest struct {
 	Cwd       string            `json:"cwd,omitempty"`
 	Env       map[string]string `json:"env,omitempty"`
 	Shell     string            `json:"shell,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+	Limits    *ExecLimits       `json:"limits,omitempty"`
+	LogLimits *ExecLogLimits    `json:"log_limits,omitempty"`
+	Priority  int               `json:"priority,omitempty"`
+	Resources map[string]int    `json:"resources,omitempty"`
+	Stdin     bool              `json:"stdin,omitempty"`
+	PTY       bool              `json:"pty,omitempty"`
+	// DependsOn holds the exec in status waiting until these execs have ended
+	// as DependsOnPolicy ("success", "any" or "failure") requires.
+	DependsOn       []string `json:"depends_on,omitempty"`
+	DependsOnPolicy string   `json:"depends_on_policy,omitempty"`
+	// NotBefore (RFC3339) holds the exec in status waiting until then.
+	NotBefore string `json:"not_before,omitempty"`
+	// Retry runs the cmd again after a failure, as the policy allows.
+	Retry *ExecRetry `json:"retry,omitempty"`
+	// Name and Labels describe the exec; ExecListOptions.Selector and
+	// ExecCancelSelected pick execs by their labels.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// IdempotencyKey is sent as the Idempotency-Key header: codexd creates one
+	// exec per key and answers a request it has seen with that exec.
+	IdempotencyKey string `json:"-"`
+}
+
+// ExecRetry mirrors codexd's retry policy: up to MaxAttempts runs, with a wait
+// of Backoff doubling up to MaxBackoff between them. With ExitCodes or
+// LogPattern set, only failures with one of those exit codes or a matching
+// output line are retried.
+type ExecRetry struct {
+	MaxAttempts int    `json:"max_attempts"`
+	Backoff     string `json:"backoff,omitempty"`
+	MaxBackoff  string `json:"max_backoff,omitempty"`
+	ExitCodes   []int  `json:"exit_codes,omitempty"`
+	LogPattern  string `json:"log_pattern,omitempty"`
+}
+
+// ExecLimits mirrors codexd's per-exec resource limits; zero fields use daemon defaults.
+type ExecLimits struct {
+	MemoryMax string  `json:"memory_max,omitempty"`
+	CPUQuota  float64 `json:"cpu_quota,omitempty"`
+	PidsMax   int     `json:"pids_max,omitempty"`
+	Nice      int     `json:"nice,omitempty"`
+	IONice    string  `json:"ionice,omitempty"`
+}
+
+// ExecLogLimits mirrors codexd's per-exec output caps; empty fields use daemon defaults.
+type ExecLogLimits struct {
+	MaxBytes    string `json:"max_bytes,omitempty"`
+	HeadBytes   string `json:"head_bytes,omitempty"`
+	RotateBytes string `json:"rotate_bytes,omitempty"`
 }
 
 type ExecStartResponse struct {
-	ExecID string `json:"exec_id"`
-	Status string `json:"status"`
+	ExecID        string `json:"exec_id"`
+	Status        string `json:"status"`
+	QueuePosition int    `json:"queue_position,omitempty"`
+	ParentExecID  string `json:"parent_exec_id,omitempty"` // set by ExecRerun
+	// IdempotentReplay is set when the Idempotency-Key had already created the exec.
+	IdempotentReplay bool `json:"idempotent_replay,omitempty"`
 }
 
 type ExecLogsOptions struct {
@@ -53,6 +109,39 @@ type ExecLogsOptions struct {
 	Until     string
 	Format    string
 	Full      bool
+	// Follow keeps the response open and streams new output until the exec
+	// finishes; jsonl output then ends with a finished event.
+	Follow bool
+	// Offset, when set, reads from that byte offset of the log instead of its
+	// tail; pass the next offset of the previous read to get only new output.
+	Offset *int64
+	// Seq is the cursor for Stream "both": read from that output line number,
+	// counted across stdout and stderr.
+	Seq *int64
+	// Limit bounds the bytes read from Offset; 0 means no bound.
+	Limit int64
+	// Attempt reads the logs of that attempt of an exec with a retry policy
+	// instead of the last; it cannot be combined with Follow.
+	Attempt int
+}
+
+type ExecListOptions struct {
+	Status     string
+	ProjectID  string
+	ScheduleID string
+	// Selector filters by labels: comma-separated key=value, key!=value, key
+	// (has the label) or !key (does not), all of which must hold.
+	Selector string
+	Since    string
+	Until    string
+	ExitCode *int
+	Limit    int
+	Cursor   string
+}
+
+type ExecListResponse struct {
+	Execs      []json.RawMessage `json:"execs"`
+	NextCursor string            `json:"next_cursor,omitempty"`
 }
 
 func (c *Client) Health(ctx context.Context) (map[string]any, error) {
@@ -86,6 +175,9 @@ func (c *Client) ExecStart(ctx context.Context, r ExecStartRequest) (ExecStartRe
 		return ExecStartResponse{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if r.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", r.IdempotencyKey)
+	}
 	c.addAuth(req)
 	resp, err := c.HTTP.Do(req)
 	if err != nil {
@@ -103,16 +195,57 @@ func (c *Client) ExecStart(ctx context.Context, r ExecStartRequest) (ExecStartRe
 	return out, nil
 }
 
-func (c *Client) ExecRun(ctx context.Context, r ExecStartRequest, w io.Writer) error {
+// ExecRerun submits the request of an exec again as a new exec linked to it,
+// with env set over the env the exec had.
+func (c *Client) ExecRerun(ctx context.Context, execID string, env map[string]string) (ExecStartResponse, error) {
+	b, err := json.Marshal(map[string]any{"env": env})
+	if err != nil {
+		return ExecStartResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v1/exec/"+url.PathEscape(execID)+"/rerun", bytes.NewReader(b))
+	if err != nil {
+		return ExecStartResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.addAuth(req)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return ExecStartResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return ExecStartResponse{}, fmt.Errorf("exec rerun failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out ExecStartResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return ExecStartResponse{}, err
+	}
+	return out, nil
+}
+
+// ExecRun runs an exec and copies its event stream to w. With r.Stdin set, stdin
+// is streamed to the exec as its stdin until EOF.
+func (c *Client) ExecRun(ctx context.Context, r ExecStartRequest, stdin io.Reader, w io.Writer) error {
 	b, err := json.Marshal(r)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v1/exec/run", bytes.NewReader(b))
+	var body io.Reader = bytes.NewReader(b)
+	contentType := "application/json"
+	if stdin != nil {
+		// The stdin follows the request in the body, streamed as it is read.
+		body = io.MultiReader(bytes.NewReader(append(b, '\n')), stdin)
+		contentType = "application/octet-stream"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v1/exec/run", body)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
+	if r.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", r.IdempotencyKey)
+	}
 	c.addAuth(req)
 
 	hc := c.HTTP
@@ -156,11 +289,170 @@ func (c *Client) ExecGet(ctx context.Context, execID string) (json.RawMessage, e
 	return json.RawMessage(b), nil
 }
 
-func (c *Client) ExecCancel(ctx context.Context, execID string) (json.RawMessage, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v1/exec/"+url.PathEscape(execID)+"/cancel", nil)
+func (o ExecListOptions) query() url.Values {
+	q := url.Values{}
+	if o.Status != "" {
+		q.Set("status", o.Status)
+	}
+	if o.ProjectID != "" {
+		q.Set("project_id", o.ProjectID)
+	}
+	if o.ScheduleID != "" {
+		q.Set("schedule_id", o.ScheduleID)
+	}
+	if o.Selector != "" {
+		q.Set("selector", o.Selector)
+	}
+	if o.Since != "" {
+		q.Set("since", o.Since)
+	}
+	if o.Until != "" {
+		q.Set("until", o.Until)
+	}
+	if o.ExitCode != nil {
+		q.Set("exit_code", fmt.Sprintf("%d", *o.ExitCode))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", fmt.Sprintf("%d", o.Limit))
+	}
+	if o.Cursor != "" {
+		q.Set("cursor", o.Cursor)
+	}
+	return q
+}
+
+func (c *Client) ExecList(ctx context.Context, opts ExecListOptions) (ExecListResponse, error) {
+	q := opts.query()
+	u := c.BaseURL + "/v1/exec"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return ExecListResponse{}, err
+	}
+	c.addAuth(req)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return ExecListResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return ExecListResponse{}, fmt.Errorf("exec list failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out ExecListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return ExecListResponse{}, err
+	}
+	return out, nil
+}
+
+// ExecCancelOptions describe a cancel; all are optional.
+type ExecCancelOptions struct {
+	Reason string
+	// Grace is how long the exec gets between SIGTERM and SIGKILL; 0 uses the
+	// daemon's cancel_grace.
+	Grace time.Duration
+	// Caller names who asks, e.g. user@host; codexd records it in canceled_by.
+	Caller string
+}
+
+func (c *Client) ExecCancel(ctx context.Context, execID string, opts ExecCancelOptions) (json.RawMessage, error) {
+	b, err := json.Marshal(opts.body(""))
 	if err != nil {
 		return nil, err
 	}
+	return c.call(ctx, "exec cancel", "POST", "/v1/exec/"+url.PathEscape(execID)+"/cancel", bytes.NewReader(b))
+}
+
+// ExecCancelSelected cancels every exec whose labels match selector (see
+// ExecListOptions.Selector) and that has not ended.
+func (c *Client) ExecCancelSelected(ctx context.Context, selector string, opts ExecCancelOptions) (json.RawMessage, error) {
+	b, err := json.Marshal(opts.body(selector))
+	if err != nil {
+		return nil, err
+	}
+	return c.call(ctx, "exec cancel", "POST", "/v1/exec/cancel", bytes.NewReader(b))
+}
+
+func (o ExecCancelOptions) body(selector string) map[string]string {
+	body := map[string]string{}
+	if o.Reason != "" {
+		body["reason"] = o.Reason
+	}
+	if o.Grace > 0 {
+		body["grace"] = o.Grace.String()
+	}
+	if o.Caller != "" {
+		body["caller"] = o.Caller
+	}
+	if selector != "" {
+		body["selector"] = selector
+	}
+	return body
+}
+
+// ExecPatch describes a change to an exec's annotations; nil and empty fields
+// are left as they are. A nil value in Labels removes that label.
+type ExecPatch struct {
+	Name   *string            `json:"name,omitempty"`
+	Labels map[string]*string `json:"labels,omitempty"`
+	Note   string             `json:"note,omitempty"` // appended to the exec's notes
+	Caller string             `json:"caller,omitempty"`
+}
+
+// ExecAnnotate renames, relabels or adds a note to an exec, and returns its meta.
+func (c *Client) ExecAnnotate(ctx context.Context, execID string, p ExecPatch) (json.RawMessage, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return c.call(ctx, "exec annotate", "PATCH", "/v1/exec/"+url.PathEscape(execID), bytes.NewReader(b))
+}
+
+// ExecDelete removes an exec that has ended, with its logs.
+func (c *Client) ExecDelete(ctx context.Context, execID string) (json.RawMessage, error) {
+	return c.call(ctx, "exec rm", "DELETE", "/v1/exec/"+url.PathEscape(execID), nil)
+}
+
+// ExecDeleteSelected removes the execs that match opts, which must have a
+// Selector, and have ended.
+func (c *Client) ExecDeleteSelected(ctx context.Context, opts ExecListOptions) (json.RawMessage, error) {
+	return c.call(ctx, "exec rm", "DELETE", "/v1/exec?"+opts.query().Encode(), nil)
+}
+
+// ExecSignal sends a signal, e.g. "USR1" or "SIGINT", to a running exec's
+// process group.
+func (c *Client) ExecSignal(ctx context.Context, execID, signal string) (json.RawMessage, error) {
+	return c.execControl(ctx, execID, "signal", map[string]string{"signal": signal})
+}
+
+// ExecPause stops a running exec's process group until ExecResume.
+func (c *Client) ExecPause(ctx context.Context, execID string) (json.RawMessage, error) {
+	return c.execControl(ctx, execID, "pause", nil)
+}
+
+func (c *Client) ExecResume(ctx context.Context, execID string) (json.RawMessage, error) {
+	return c.execControl(ctx, execID, "resume", nil)
+}
+
+func (c *Client) execControl(ctx context.Context, execID, action string, body any) (json.RawMessage, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v1/exec/"+url.PathEscape(execID)+"/"+action, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	c.addAuth(req)
 	resp, err := c.HTTP.Do(req)
 	if err != nil {
@@ -172,12 +464,86 @@ func (c *Client) ExecCancel(ctx context.Context, execID string) (json.RawMessage
 		return nil, err
 	}
 	if resp.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("exec cancel failed: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+		return nil, fmt.Errorf("exec %s failed: %s: %s", action, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.RawMessage(b), nil
+}
+
+// ExecStdin appends body to the stdin of an exec started with Stdin set, and
+// closes it afterwards if closeStdin is true. body may be a long-lived stream.
+func (c *Client) ExecStdin(ctx context.Context, execID string, body io.Reader, closeStdin bool) (json.RawMessage, error) {
+	u := c.BaseURL + "/v1/exec/" + url.PathEscape(execID) + "/stdin"
+	if closeStdin {
+		u += "?close=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	c.addAuth(req)
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = &http.Client{}
+	}
+	noTimeout := *hc
+	noTimeout.Timeout = 0
+
+	resp, err := noTimeout.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("exec stdin failed: %s: %s", resp.Status, strings.TrimSpace(string(b)))
 	}
 	return json.RawMessage(b), nil
 }
 
-func (c *Client) ExecLogs(ctx context.Context, execID string, opts ExecLogsOptions, w io.Writer) error {
+// ExecAttach opens an attach connection to a PTY exec. Reads and writes on the
+// returned connection are attach frames; closing it detaches.
+func (c *Client) ExecAttach(ctx context.Context, execID string) (io.ReadWriteCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/v1/exec/"+url.PathEscape(execID)+"/attach", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", attach.Upgrade)
+	c.addAuth(req)
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = &http.Client{}
+	}
+	noTimeout := *hc
+	noTimeout.Timeout = 0
+
+	resp, err := noTimeout.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("exec attach failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("exec attach failed: connection is not writable")
+	}
+	return conn, nil
+}
+
+// ExecLogs copies the exec's log to w and returns where a following read
+// resumes: the next offset, or the next seq for Stream "both". It is -1 if
+// codexd did not report one (follow reads report it in their events instead).
+func (c *Client) ExecLogs(ctx context.Context, execID string, opts ExecLogsOptions, w io.Writer) (int64, error) {
 	u := c.BaseURL + "/v1/exec/" + url.PathEscape(execID) + "/logs"
 	q := url.Values{}
 	if opts.Stream != "" {
@@ -200,6 +566,21 @@ func (c *Client) ExecLogs(ctx context.Context, execID string, opts ExecLogsOptio
 	if opts.Format != "" {
 		q.Set("format", opts.Format)
 	}
+	if opts.Follow {
+		q.Set("follow", "true")
+	}
+	if opts.Offset != nil {
+		q.Set("offset", fmt.Sprintf("%d", *opts.Offset))
+	}
+	if opts.Seq != nil {
+		q.Set("seq", fmt.Sprintf("%d", *opts.Seq))
+	}
+	if opts.Attempt > 0 {
+		q.Set("attempt", fmt.Sprintf("%d", opts.Attempt))
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", fmt.Sprintf("%d", opts.Limit))
+	}
 	if strings.Contains(u, "?") {
 		u += "&" + q.Encode()
 	} else {
@@ -207,20 +588,95 @@ func (c *Client) ExecLogs(ctx context.Context, execID string, opts ExecLogsOptio
 	}
 	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		return err
+		return -1, err
 	}
 	c.addAuth(req)
-	resp, err := c.HTTP.Do(req)
+	hc := c.HTTP
+	if opts.Follow {
+		noTimeout := *hc
+		noTimeout.Timeout = 0
+		hc = &noTimeout
+	}
+	resp, err := hc.Do(req)
 	if err != nil {
-		return err
+		return -1, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode/100 != 2 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("exec logs failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return -1, fmt.Errorf("exec logs failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	next := int64(-1)
+	for _, h := range []string{"X-Next-Offset", "X-Next-Seq"} {
+		if n, err := strconv.ParseInt(resp.Header.Get(h), 10, 64); err == nil {
+			next = n
+		}
 	}
 	_, err = io.Copy(w, resp.Body)
-	return err
+	return next, err
+}
+
+// ScheduleRequest asks codexd to start Exec each time Cron is due, in Timezone
+// (an IANA name; the daemon's local time if empty).
+type ScheduleRequest struct {
+	Cron     string           `json:"cron"`
+	Timezone string           `json:"timezone,omitempty"`
+	Exec     ExecStartRequest `json:"exec"`
+}
+
+type ScheduleListResponse struct {
+	Schedules []json.RawMessage `json:"schedules"`
+}
+
+func (c *Client) ScheduleAdd(ctx context.Context, r ScheduleRequest) (json.RawMessage, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.call(ctx, "schedule add", "POST", "/v1/schedules", bytes.NewReader(b))
+}
+
+func (c *Client) ScheduleList(ctx context.Context) (ScheduleListResponse, error) {
+	b, err := c.call(ctx, "schedule list", "GET", "/v1/schedules", nil)
+	if err != nil {
+		return ScheduleListResponse{}, err
+	}
+	var out ScheduleListResponse
+	if err := json.Unmarshal(b, &out); err != nil {
+		return ScheduleListResponse{}, err
+	}
+	return out, nil
+}
+
+// ScheduleDelete removes a schedule; the execs it created are kept.
+func (c *Client) ScheduleDelete(ctx context.Context, scheduleID string) (json.RawMessage, error) {
+	return c.call(ctx, "schedule delete", "DELETE", "/v1/schedules/"+url.PathEscape(scheduleID), nil)
+}
+
+// call makes one request to codexd and returns the JSON it answers with; what
+// names the request in errors, e.g. "schedule add".
+func (c *Client) call(ctx context.Context, what, method, path string, body io.Reader) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.addAuth(req)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s failed: %s: %s", what, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.RawMessage(b), nil
 }
 
 type FileWriteRequest struct {
@@ -243,6 +699,67 @@ type FileReadResponse struct {
 	Size    int    `json:"size"`
 }
 
+// ExecLogSearchOptions says what ExecLogSearch looks for.
+type ExecLogSearchOptions struct {
+	// Pattern is a Go regular expression matched against each line.
+	Pattern    string
+	Stream     string
+	Context    int
+	MaxMatches int
+	// Status, ProjectID and Selector narrow a search across all execs.
+	Status    string
+	ProjectID string
+	Selector  string
+}
+
+// ExecLogSearch searches the logs of an exec on the daemon, or of every
+// retained exec if execID is empty, and copies the JSONL match events to w.
+func (c *Client) ExecLogSearch(ctx context.Context, execID string, opts ExecLogSearchOptions, w io.Writer) error {
+	u := c.BaseURL + "/v1/exec/logs/search"
+	if execID != "" {
+		u = c.BaseURL + "/v1/exec/" + url.PathEscape(execID) + "/logs/search"
+	}
+	q := url.Values{}
+	q.Set("pattern", opts.Pattern)
+	if opts.Stream != "" {
+		q.Set("stream", opts.Stream)
+	}
+	if opts.Context > 0 {
+		q.Set("context", fmt.Sprintf("%d", opts.Context))
+	}
+	if opts.MaxMatches > 0 {
+		q.Set("max_matches", fmt.Sprintf("%d", opts.MaxMatches))
+	}
+	if opts.Status != "" {
+		q.Set("status", opts.Status)
+	}
+	if opts.ProjectID != "" {
+		q.Set("project_id", opts.ProjectID)
+	}
+	if opts.Selector != "" {
+		q.Set("selector", opts.Selector)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	c.addAuth(req)
+	// A search of large logs can take a while; ctx bounds it.
+	noTimeout := *c.HTTP
+	noTimeout.Timeout = 0
+	resp, err := noTimeout.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("exec grep failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
 func (c *Client) FileWrite(ctx context.Context, req FileWriteRequest) (FileWriteResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
@@ -0,0 +1,70 @@
+// Package attach is the framing used on an attached PTY exec connection, between
+// codex-remote and codexd and between codexd and the exec supervisor.
+//
+// A frame is one type byte, a big-endian uint32 payload length, and the payload.
+package attach
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Upgrade is the protocol name in the Upgrade header of GET /v1/exec/{id}/attach.
+const Upgrade = "codexd-attach"
+
+const (
+	FrameData   byte = 'd' // terminal bytes: keyboard input, or PTY output
+	FrameResize byte = 'r' // client to server: rows and cols, uint16 each
+	FrameExit   byte = 'x' // server to client: the exec exited, payload is the exit code
+)
+
+// maxPayload bounds a single frame so a corrupt length cannot exhaust memory.
+const maxPayload = 1 << 20
+
+// WriteFrame writes one frame to w in a single Write call.
+func WriteFrame(w io.Writer, typ byte, payload []byte) error {
+	if len(payload) > maxPayload {
+		return fmt.Errorf("attach frame too large: %d bytes", len(payload))
+	}
+	buf := make([]byte, 5+len(payload))
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:5], uint32(len(payload)))
+	copy(buf[5:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads the next frame from r.
+func ReadFrame(r io.Reader) (typ byte, payload []byte, err error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:5])
+	if n > maxPayload {
+		return 0, nil, fmt.Errorf("attach frame too large: %d bytes", n)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+// ResizePayload encodes a terminal size for a FrameResize frame.
+func ResizePayload(rows, cols int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b[0:2], uint16(rows))
+	binary.BigEndian.PutUint16(b[2:4], uint16(cols))
+	return b
+}
+
+// ParseResize decodes the payload of a FrameResize frame.
+func ParseResize(b []byte) (rows, cols int, err error) {
+	if len(b) != 4 {
+		return 0, 0, errors.New("invalid resize frame")
+	}
+	return int(binary.BigEndian.Uint16(b[0:2])), int(binary.BigEndian.Uint16(b[2:4])), nil
+}
@@ -18,8 +18,13 @@ import (
 //     key:
 //       - a: 1
 //         b: "x"
+// - top-level key: followed by a one-level map:
+//     key:
+//       a: 1
+//       b: "x"
+// - flow lists of scalars as values: key: [0, 1, "x"]
 //
-// Not supported: nested maps (beyond list-of-objects), multiline scalars, anchors, etc.
+// Not supported: deeper nested maps, multiline scalars, anchors, etc.
 
 type Node map[string]any
 
@@ -30,6 +35,7 @@ func Parse(r io.Reader) (Node, error) {
 	root := Node{}
 	var currentListKey string
 	var currentObj map[string]any
+	var currentMap map[string]any
 
 	for s.Scan() {
 		raw := s.Text()
@@ -41,6 +47,7 @@ func Parse(r io.Reader) (Node, error) {
 		indent := len(line) - len(strings.TrimLeft(line, " "))
 		if indent == 0 {
 			currentObj = nil
+			currentMap = nil
 			currentListKey = ""
 			k, v, hasValue, err := parseKeyLine(trim)
 			if err != nil {
@@ -58,6 +65,26 @@ func Parse(r io.Reader) (Node, error) {
 		if currentListKey == "" {
 			return nil, fmt.Errorf("unexpected indentation: %q", raw)
 		}
+		// Map field? The first indented line decides between a list and a map.
+		isItem := strings.HasPrefix(strings.TrimLeft(line, " "), "- ")
+		if !isItem && (currentMap != nil || len(root[currentListKey].([]any)) == 0) && currentObj == nil {
+			if currentMap == nil {
+				currentMap = map[string]any{}
+				root[currentListKey] = currentMap
+			}
+			k, v, hasValue, err := parseKeyLine(trim)
+			if err != nil {
+				return nil, err
+			}
+			if !hasValue {
+				return nil, fmt.Errorf("nested maps are not supported: %q", raw)
+			}
+			currentMap[k] = v
+			continue
+		}
+		if currentMap != nil {
+			return nil, fmt.Errorf("unexpected list item in map: %q", raw)
+		}
 		// List item?
 		if strings.HasPrefix(strings.TrimLeft(line, " "), "- ") {
 			itemText := strings.TrimSpace(strings.TrimLeft(line, " "))
@@ -115,6 +142,17 @@ func parseKeyLine(s string) (key string, val any, hasValue bool, err error) {
 
 func parseScalar(s string) any {
 	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '[' && s[len(s)-1] == ']' {
+		out := []any{}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return out
+		}
+		for _, it := range strings.Split(inner, ",") {
+			out = append(out, parseScalar(it))
+		}
+		return out
+	}
 	if len(s) >= 2 {
 		if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
 			return s[1 : len(s)-1]
@@ -3,21 +3,58 @@ package tail
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
 	"os"
 )
 
+// Open opens the file at path for reading. If it does not exist but path.gz
+// does, such as an exec log codexd has compressed, it reads that decompressed.
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	zf, zerr := os.Open(path + ".gz")
+	if zerr != nil {
+		return nil, err
+	}
+	zr, zerr := gzip.NewReader(zf)
+	if zerr != nil {
+		zf.Close()
+		return nil, zerr
+	}
+	return &gzipFile{Reader: zr, f: zf}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
 // ReadTailBytes reads up to maxBytes from the end of the file.
 func ReadTailBytes(path string, maxBytes int64) ([]byte, error) {
 	if maxBytes <= 0 {
 		return []byte{}, nil
 	}
-	f, err := os.Open(path)
+	r, err := Open(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	defer r.Close()
+	f, ok := r.(*os.File)
+	if !ok {
+		return readTailBytes(r, maxBytes)
+	}
 
 	st, err := f.Stat()
 	if err != nil {
@@ -39,16 +76,46 @@ func ReadTailBytes(path string, maxBytes int64) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if start > 0 && len(buf) > 0 {
+	return dropPartialLine(buf, start > 0), nil
+}
+
+// readTailBytes keeps the last maxBytes of a stream that cannot seek.
+func readTailBytes(r io.Reader, maxBytes int64) ([]byte, error) {
+	var buf []byte
+	chunk := make([]byte, 64<<10)
+	cut := false
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if over := int64(len(buf)) - maxBytes; over > maxBytes {
+			buf = append(buf[:0], buf[over:]...)
+			cut = true
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if over := int64(len(buf)) - maxBytes; over > 0 {
+		buf, cut = buf[over:], true
+	}
+	return dropPartialLine(buf, cut), nil
+}
+
+// dropPartialLine drops the first line of buf if it starts mid-line.
+func dropPartialLine(buf []byte, cut bool) []byte {
+	if cut && len(buf) > 0 {
 		// If we started in the middle of a line, drop the partial first line.
 		for i, b := range buf {
 			if b == '\n' {
-				return buf[i+1:], nil
+				return buf[i+1:]
 			}
 		}
-		return []byte{}, nil
+		return []byte{}
 	}
-	return buf, nil
+	return buf
 }
 
 // ReadTailLines reads the last maxLines lines from file.
@@ -56,7 +123,7 @@ func ReadTailLines(path string, maxLines int) ([]byte, error) {
 	if maxLines <= 0 {
 		return []byte{}, nil
 	}
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -84,7 +151,12 @@ func ReadTailLines(path string, maxLines int) ([]byte, error) {
 
 // ReadAll reads the whole file as bytes.
 func ReadAll(path string) ([]byte, error) {
-	return os.ReadFile(path)
+	f, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
 }
 
 var ErrNotFound = errors.New("not found")
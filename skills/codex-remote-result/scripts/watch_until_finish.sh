@@ -2,19 +2,56 @@
 set -euo pipefail
 
 if [[ $# -lt 2 ]]; then
-  echo "usage: $0 <machine> <exec_id>" >&2
+  echo "usage: $0 <machine> <exec_id> [both|stdout|stderr]" >&2
   exit 2
 fi
 
 machine="$1"
 exec_id="$2"
+stream="${3:-both}"
 
+# Each poll prints only the log lines after the previous one's cursor: next_seq
+# for both streams interleaved, next_offset for one.
+if [[ "$stream" == "both" ]]; then
+  cursor_flag=--seq cursor_key=next_seq first=1
+else
+  cursor_flag=--offset cursor_key=next_offset first=0
+fi
+cursor="$first"
+
+# print_logs prints the lines after the cursor and moves it past them; extra
+# args (--attempt N) pick the logs to read.
+print_logs() {
+  local logs next
+  logs="$(codex-remote exec logs --machine "$machine" --id "$exec_id" --stream "$stream" "$cursor_flag" "$cursor" "$@")"
+  echo "$logs" | rg -v '"type":"end"' || true
+  next="$(echo "$logs" | rg '"type":"end"' | rg -o "\"$cursor_key\":[0-9]+" | rg -o '[0-9]+' || true)"
+  if [[ -n "$next" ]]; then
+    cursor="$next"
+  fi
+}
+
+# An exec with a retry policy logs each attempt apart: finish reading an
+# attempt once the next has started, then read that one from its start.
+attempt=0
 while true; do
   out="$(codex-remote exec result --machine "$machine" --id "$exec_id")"
-  echo "$out"
-  if echo "$out" | rg -q '"status"\s*:\s*"finished"'; then
+  attempts="$(echo "$out" | rg -o '"log_dir"' | wc -l | tr -d ' ')"
+  while (( attempt < attempts )); do
+    if (( attempt > 0 )); then
+      print_logs --attempt "$attempt"
+      cursor="$first"
+    fi
+    attempt=$((attempt + 1))
+  done
+  if (( attempt > 0 )); then
+    print_logs --attempt "$attempt"
+  else
+    print_logs
+  fi
+  if echo "$out" | rg -q '"status"\s*:\s*"(finished|timed_out|lost|canceled|skipped)"'; then
+    echo "$out"
     break
   fi
   sleep 2
 done
-